@@ -25,9 +25,11 @@ func init() {
 			if err != nil {
 				exitError(1, "Cannot open database: %s\n", err)
 			}
+			defer notedb.CloseDatabase(db)
 
 			file, err := db.Open(args[1])
 			if err != nil {
+				notedb.CloseDatabase(db)
 				exitError(1, "%s\n", err)
 			}
 
@@ -46,6 +48,7 @@ func init() {
 			} else {
 				_, err = io.Copy(os.Stdout, file)
 				if err != nil {
+					notedb.CloseDatabase(db)
 					exitError(1, "%s\n", err)
 				}
 			}
@@ -28,12 +28,16 @@ func init() {
 				exitError(1, "Cannot open source database: %s\n", err)
 			}
 
+			defer notedb.CloseDatabase(src)
+
 			dstURL, err := notedb.ResolveURL(args[1])
 			if err != nil {
+				notedb.CloseDatabase(src)
 				exitError(1, "Cannot determine database type: %s\n", err)
 			}
 			dst, err := notedb.OpenDatabase(dstURL)
 			if err != nil {
+				notedb.CloseDatabase(src)
 				exitError(1, "Cannot open destination database: %s\n", err)
 			}
 
@@ -80,7 +84,16 @@ func init() {
 					if err != nil {
 						fileErrs = multierr.Append(fileErrs, err)
 					}
+					metadata, err := note.Metadata()
+					if err != nil {
+						fileErrs = multierr.Append(fileErrs, err)
+					}
 					if dstNote, ok := dstFile.(notedb.Note); ok {
+						if !metadata.IsZero() {
+							if err := notedb.WriteMetadata(dstNote, metadata); err != nil {
+								fileErrs = multierr.Append(fileErrs, err)
+							}
+						}
 						if err := notedb.WriteAST(dstNote, ast, note.Data()); err != nil {
 							fileErrs = multierr.Append(fileErrs, err)
 						}
@@ -93,7 +106,9 @@ func init() {
 					_, fileErrs = io.Copy(dstFile, srcFile)
 				}
 
-				dstFile.Close()
+				if err := dstFile.Close(); err != nil {
+					fileErrs = multierr.Append(fileErrs, err)
+				}
 
 				stat, err := d.Info()
 				if err != nil {
@@ -105,8 +120,13 @@ func init() {
 				return nil
 			})
 			if err != nil {
+				notedb.CloseDatabase(dst)
+				notedb.CloseDatabase(src)
 				exitError(1, "Error reading database: %s\n", err)
 			}
+			if err := notedb.CloseDatabase(dst); err != nil {
+				allErrs = multierr.Append(allErrs, err)
+			}
 
 			errs := multierr.Errors(allErrs)
 			logError("Finished with %d errors\n", len(errs))
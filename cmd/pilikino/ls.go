@@ -23,6 +23,7 @@ func init() {
 			if err != nil {
 				exitError(1, "Cannot open database: %s\n", err)
 			}
+			defer notedb.CloseDatabase(db)
 
 			err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
 				if err != nil {
@@ -32,6 +33,7 @@ func init() {
 				return nil
 			})
 			if err != nil {
+				notedb.CloseDatabase(db)
 				exitError(1, "Cannot read database: %s\n", err)
 			}
 		},
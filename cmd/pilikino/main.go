@@ -6,8 +6,27 @@ import (
 
 	"github.com/spf13/cobra"
 
+	_ "github.com/CGamesPlay/pilikino/lib/formats/archive"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/enex"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/epub"
 	_ "github.com/CGamesPlay/pilikino/lib/formats/file"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/git"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/htmlsite"
 	_ "github.com/CGamesPlay/pilikino/lib/formats/jex"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/jsonl"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/keep"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/logseq"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/mem"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/notion"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/obsidian"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/org"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/roam"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/simplenote"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/sitegen"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/standardnotes"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/textbundle"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/tiddlywiki"
+	_ "github.com/CGamesPlay/pilikino/lib/formats/webdav"
 )
 
 var rootCmd = &cobra.Command{
@@ -27,6 +46,9 @@ func logError(format string, a ...interface{}) (int, error) {
 	return fmt.Fprintf(os.Stderr, format, a...)
 }
 
+// exitError prints the message and exits immediately. Deferred calls do not
+// run, so callers close any open databases first, which lets formats such as
+// the archives remove their temporary files.
 func exitError(exitCode int, format string, a ...interface{}) {
 	logError(format, a...)
 	os.Exit(exitCode)
@@ -1,14 +1,27 @@
 module github.com/CGamesPlay/pilikino
 
-go 1.16
+go 1.17
 
 require (
-	github.com/litao91/goldmark-mathjax v0.0.0-20210217064022-a43cf739a50f // indirect
-	github.com/mattn/go-runewidth v0.0.13 // indirect
-	github.com/relab/wrfs v0.0.0-20210628111300-b51570396aec // indirect
-	github.com/spf13/cobra v1.3.0 // indirect
+	github.com/litao91/goldmark-mathjax v0.0.0-20210217064022-a43cf739a50f
+	github.com/mattn/go-runewidth v0.0.13
+	github.com/mattn/go-sqlite3 v1.14.10
+	github.com/relab/wrfs v0.0.0-20210628111300-b51570396aec
+	github.com/spf13/cobra v1.3.0
 	github.com/stretchr/testify v1.7.0
-	github.com/yuin/goldmark v1.4.4 // indirect
-	github.com/yuin/goldmark-meta v1.0.0 // indirect
-	go.uber.org/multierr v1.7.0 // indirect
+	github.com/yuin/goldmark v1.4.4
+	github.com/yuin/goldmark-meta v1.0.0
+	go.uber.org/multierr v1.7.0
+	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/rivo/uniseg v0.2.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
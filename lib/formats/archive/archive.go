@@ -0,0 +1,202 @@
+// Package archive provides formats which wrap another database inside of an
+// archive file, such as a zip file. The archive is extracted into a temporary
+// directory, which the inner format operates on.
+package archive
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+// archiveFormat describes how to read and write one kind of archive.
+type archiveFormat struct {
+	extensions []string
+	extract    func(archive string, dir string) error
+	create     func(archive string, dir string) error
+}
+
+func init() {
+	register("zip", "Zip archive", []string{".zip"}, extractZip, createZip)
+	register("tgz", "Gzipped tar archive", []string{".tar.gz", ".tgz"}, extractTar, createTar)
+}
+
+func register(id, description string, extensions []string, extract, create func(string, string) error) {
+	format := &archiveFormat{extensions, extract, create}
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          id,
+		Description: description,
+		Documentation: fmt.Sprintf(`This format wraps another database inside of an archive. The format of
+the contents is given after a + in the URL, for example %s+file:///notes%s or
+%s+obsidian:///vault%s. Files ending with %s are detected
+automatically, and are assumed to contain a directory of Markdown files.
+
+If the archive does not exist, it is created once the conversion finishes.
+If files are written to an existing archive, it is replaced with one holding
+the updated contents.`,
+			id, extensions[0], id, extensions[0], strings.Join(extensions, " or ")),
+		Open:    format.open,
+		Detect:  format.detect,
+		Wrapper: true,
+	})
+}
+
+func (f *archiveFormat) detect(dbURL *url.URL) notedb.DetectResult {
+	for _, ext := range f.extensions {
+		if strings.HasSuffix(dbURL.Path, ext) {
+			return notedb.DetectResultPositive
+		}
+	}
+	return notedb.DetectResultNegative
+}
+
+// Database is a database stored in an archive. It forwards all operations to
+// the inner database.
+type Database struct {
+	notedb.Database
+	format *archiveFormat
+	path   string
+	// dir is the temporary directory holding the contents of the archive.
+	// The inner database is at the root subdirectory.
+	dir string
+	// dirty is set when the archive must be written when the database is
+	// closed, either because it did not exist or because it was modified.
+	dirty bool
+}
+
+var _ fs.MkdirAllFS = (*Database)(nil)
+var _ fs.OpenFileFS = (*Database)(nil)
+var _ fs.ChtimesFS = (*Database)(nil)
+var _ io.Closer = (*Database)(nil)
+
+func (f *archiveFormat) open(dbURL *url.URL) (notedb.Database, error) {
+	inner := "file"
+	if idx := strings.IndexByte(dbURL.Scheme, '+'); idx != -1 {
+		inner = dbURL.Scheme[idx+1:]
+	}
+
+	_, err := os.Stat(dbURL.Path)
+	create := os.IsNotExist(err)
+	if err != nil && !create {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "pilikino-")
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, "root")
+	if !create {
+		if err := f.extract(dbURL.Path, root); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("while extracting %s: %w", dbURL.Path, err)
+		}
+	}
+
+	db, err := notedb.OpenDatabase(&url.URL{Scheme: inner, Path: root, RawQuery: dbURL.RawQuery})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &Database{db, f, dbURL.Path, dir, create}, nil
+}
+
+func (db *Database) OpenFile(path string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		db.dirty = true
+	}
+	return fs.OpenFile(db.Database, path, flag, perm)
+}
+
+func (db *Database) MkdirAll(path string, perm fs.FileMode) error {
+	db.dirty = true
+	return fs.MkdirAll(db.Database, path, perm)
+}
+
+func (db *Database) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	db.dirty = true
+	return fs.Chtimes(db.Database, name, atime, mtime)
+}
+
+// Close closes the inner database and, if the archive did not exist when it
+// was opened or has been written to, writes the archive. The temporary
+// directory is removed.
+func (db *Database) Close() error {
+	err := notedb.CloseDatabase(db.Database)
+	if db.dirty {
+		root := filepath.Join(db.dir, "root")
+		if mkdirErr := os.MkdirAll(root, 0777); mkdirErr != nil {
+			err = multierr.Append(err, mkdirErr)
+		} else {
+			err = multierr.Append(err, db.writeArchive(root))
+		}
+	}
+	return multierr.Append(err, os.RemoveAll(db.dir))
+}
+
+// writeArchive creates the archive from the directory. The archive is built
+// next to the destination and then renamed over it, so that an existing
+// archive is not lost if writing fails.
+func (db *Database) writeArchive(root string) error {
+	tmp := filepath.Join(filepath.Dir(db.path), "."+filepath.Base(db.path)+".tmp")
+	if err := db.format.create(tmp, root); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, db.path)
+}
+
+// extractFile writes a file from the archive into the directory.
+func extractFile(dir, name string, r io.Reader, modTime time.Time) error {
+	dest, err := destPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, modTime, modTime)
+}
+
+// destPath returns the location in the directory of a file in the archive,
+// refusing names which would be outside of the directory.
+func destPath(dir, name string) (string, error) {
+	clean := strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if !fs.ValidPath(clean) {
+		return "", fmt.Errorf("invalid file name in archive: %#v", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// walkFiles calls fn for every file and directory in the directory, with the
+// slash-separated path relative to the directory.
+func walkFiles(dir string, fn func(name string, info os.FileInfo, path string) error) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info, p)
+	})
+}
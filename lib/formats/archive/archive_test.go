@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/CGamesPlay/pilikino/lib/formats/file"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"notes.zip", "notes.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			dbURL, err := notedb.ResolveURL(filepath.Join(t.TempDir(), name))
+			require.NoError(t, err)
+			require.Contains(t, []string{"zip+file", "tgz+file"}, dbURL.Scheme)
+
+			db, err := notedb.OpenDatabase(dbURL)
+			require.NoError(t, err)
+			modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+			require.NoError(t, fs.MkdirAll(db, "Notebook", 0777))
+			notedbtest.WriteFile(t, db, "Notebook/Note.md", "# Note\n")
+			require.NoError(t, fs.Chtimes(db, "Notebook/Note.md", modTime, modTime))
+			require.NoError(t, notedb.CloseDatabase(db))
+
+			db, err = notedb.OpenDatabase(dbURL)
+			require.NoError(t, err)
+			defer notedb.CloseDatabase(db)
+			var paths []string
+			err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+				require.NoError(t, err)
+				paths = append(paths, path)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, []string{".", "Notebook", "Notebook/Note.md"}, paths)
+
+			data, err := fs.ReadFile(db, "Notebook/Note.md")
+			require.NoError(t, err)
+			require.Equal(t, "# Note\n", string(data))
+			info, err := fs.Stat(db, "Notebook/Note.md")
+			require.NoError(t, err)
+			require.True(t, info.ModTime().Equal(modTime))
+
+			// Writing to an existing archive replaces it.
+			notedbtest.WriteFile(t, db, "Other.md", "Other\n")
+			require.NoError(t, notedb.CloseDatabase(db))
+			db, err = notedb.OpenDatabase(dbURL)
+			require.NoError(t, err)
+			require.Equal(t, []string{".", "Notebook", "Notebook/Note.md", "Other.md"}, notedbtest.ListPaths(t, db))
+			entries, err := os.ReadDir(filepath.Dir(dbURL.Path))
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	dbURL, err := notedb.ResolveURL("/backup/notes.tgz")
+	require.NoError(t, err)
+	require.Equal(t, &url.URL{Scheme: "tgz+file", Path: "/backup/notes.tgz"}, dbURL)
+}
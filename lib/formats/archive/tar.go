@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+func extractTar(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			dest, err := destPath(dir, header.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dest, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(dir, header.Name, r, header.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func createTar(archive string, dir string) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	w := tar.NewWriter(gz)
+	err = walkFiles(dir, func(name string, info os.FileInfo, path string) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+			return w.WriteHeader(header)
+		}
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+)
+
+func extractZip(archive string, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			dest, err := destPath(dir, f.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dest, 0777); err != nil {
+				return err
+			}
+			continue
+		}
+		contents, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(dir, f.Name, contents, f.Modified)
+		contents.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createZip(archive string, dir string) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	err = walkFiles(dir, func(name string, info os.FileInfo, path string) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+			_, err := w.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		dest, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(dest, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
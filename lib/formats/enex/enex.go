@@ -0,0 +1,309 @@
+package enex
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+var resourcesFolder = "_resources"
+
+// enexTimeFormat is the format of timestamps in ENEX files.
+const enexTimeFormat = "20060102T150405Z"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "evernote-export",
+		Description: "Evernote export (ENEX)",
+		Documentation: `This is the export format for Evernote. The path may point at a
+single .enex file, or at a directory of .enex files, in which case each file
+is treated as a notebook and becomes a directory.
+
+Attachments are placed in a _resources directory alongside the notes. The
+title, creation time, tags, author, source URL, and location of each note are
+available as its metadata.
+
+If the path does not exist, a new .enex file is created; an existing export
+can only be read. Every note is exported with its modification time and
+metadata, and attachments linked from a note are embedded into it. Notes in
+subdirectories are flattened, and constructs which ENML cannot represent,
+such as math and links between notes, are reported as errors.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// Database is a database loaded from one or more ENEX files.
+type Database struct {
+	*mem.Database
+	// errs holds the problems encountered while converting each note, which
+	// are reported when the note is parsed.
+	errs map[string]error
+}
+
+// OpenDatabase is the entrypoint for the ENEX format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	info, err := os.Stat(dbURL.Path)
+	if os.IsNotExist(err) {
+		return newWriter(dbURL.Path), nil
+	} else if err != nil {
+		return nil, err
+	}
+	db := &Database{mem.New(), map[string]error{}}
+	if !info.IsDir() {
+		if err := db.loadFile(dbURL.Path, ""); err != nil {
+			return nil, err
+		}
+		db.SetReadOnly()
+		return db, nil
+	}
+
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	usedNames := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".enex") {
+			continue
+		}
+		notebook := notedb.UniqueName(notedb.EscapeName(strings.TrimSuffix(entry.Name(), ".enex")), usedNames)
+		if err := db.loadFile(filepath.Join(dbURL.Path, entry.Name()), notebook); err != nil {
+			return nil, err
+		}
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be an ENEX file, or a directory
+// of them.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if strings.HasSuffix(dbURL.Path, ".enex") {
+		return notedb.DetectResultPositive
+	}
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	found := false
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".enex") {
+			found = true
+		} else if strings.HasSuffix(entry.Name(), ".md") {
+			return notedb.DetectResultNegative
+		}
+	}
+	if found {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+type enexNote struct {
+	Title      string         `xml:"title"`
+	Content    string         `xml:"content"`
+	Created    string         `xml:"created"`
+	Updated    string         `xml:"updated"`
+	Tags       []string       `xml:"tag"`
+	Attributes enexAttributes `xml:"note-attributes"`
+	Resources  []enexResource `xml:"resource"`
+}
+
+type enexAttributes struct {
+	Latitude  string `xml:"latitude,omitempty"`
+	Longitude string `xml:"longitude,omitempty"`
+	Altitude  string `xml:"altitude,omitempty"`
+	Author    string `xml:"author,omitempty"`
+	SourceURL string `xml:"source-url,omitempty"`
+}
+
+type enexResource struct {
+	Data struct {
+		Encoding string `xml:"encoding,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"data"`
+	Mime       string `xml:"mime"`
+	Attributes struct {
+		FileName string `xml:"file-name"`
+	} `xml:"resource-attributes"`
+}
+
+// loadFile adds all of the notes in the ENEX file to the given directory of
+// the database.
+func (db *Database) loadFile(filename string, dir string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	usedNames := map[string]bool{}
+	usedResourceNames := map[string]bool{}
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("while reading %s: %w", filename, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		var note enexNote
+		if err := decoder.DecodeElement(&note, &start); err != nil {
+			return fmt.Errorf("while reading %s: %w", filename, err)
+		}
+		if err := db.addNote(&note, dir, usedNames, usedResourceNames); err != nil {
+			return fmt.Errorf("while loading %s: %w", note.Title, err)
+		}
+	}
+	return nil
+}
+
+func parseTime(value string) time.Time {
+	t, err := time.Parse(enexTimeFormat, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (db *Database) addNote(note *enexNote, dir string, usedNames, usedResourceNames map[string]bool) error {
+	metadata := notedb.Metadata{
+		Title:     note.Title,
+		Tags:      note.Tags,
+		Created:   parseTime(note.Created),
+		Updated:   parseTime(note.Updated),
+		Author:    note.Attributes.Author,
+		SourceURL: note.Attributes.SourceURL,
+	}
+	if metadata.Updated.IsZero() {
+		metadata.Updated = metadata.Created
+	}
+	if note.Attributes.Latitude != "" && note.Attributes.Longitude != "" {
+		metadata.Location = &notedb.Location{}
+		metadata.Location.Latitude, _ = strconv.ParseFloat(note.Attributes.Latitude, 64)
+		metadata.Location.Longitude, _ = strconv.ParseFloat(note.Attributes.Longitude, 64)
+		metadata.Location.Altitude, _ = strconv.ParseFloat(note.Attributes.Altitude, 64)
+	}
+
+	media := map[string]enmlMedia{}
+	for i, resource := range note.Resources {
+		data, err := decodeResource(&resource)
+		if err != nil {
+			return fmt.Errorf("resource %d: %w", i, err)
+		}
+		name := resourceName(&resource)
+		name = notedb.UniqueName(name, usedResourceNames)
+		resourcePath := path.Join(dir, resourcesFolder, name)
+		if err := db.WriteFile(resourcePath, data, metadata.Updated); err != nil {
+			return err
+		}
+		sum := md5.Sum(data)
+		media[hex.EncodeToString(sum[:])] = enmlMedia{
+			Destination: (&url.URL{Path: path.Join(resourcesFolder, name)}).String(),
+			Name:        name,
+		}
+	}
+
+	converter := &enmlConverter{media: media}
+	doc, err := parseENML(note.Content)
+	if err != nil {
+		converter.err = multierr.Append(converter.err, err)
+	}
+	markdown := converter.convert(doc)
+
+	title := notedb.EscapeName(note.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	notePath := path.Join(dir, notedb.UniqueName(title+".md", usedNames))
+	if err := db.WriteFile(notePath, []byte(markdown), metadata.Updated); err != nil {
+		return err
+	}
+	if converter.err != nil {
+		db.errs[notePath] = converter.err
+	}
+	return db.SetMetadata(notePath, metadata)
+}
+
+func decodeResource(resource *enexResource) ([]byte, error) {
+	if resource.Data.Encoding != "" && resource.Data.Encoding != "base64" {
+		return nil, fmt.Errorf("unsupported encoding %s", resource.Data.Encoding)
+	}
+	encoded := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, resource.Data.Value)
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// resourceName determines a file name for the resource, falling back to a
+// generic name with an extension based on its MIME type.
+func resourceName(resource *enexResource) string {
+	if name := notedb.EscapeName(resource.Attributes.FileName); name != "" {
+		return name
+	}
+	name := "attachment"
+	if ext, ok := preferredExtensions[resource.Mime]; ok {
+		name = name + ext
+	} else if exts, err := mime.ExtensionsByType(resource.Mime); err == nil && len(exts) > 0 {
+		sort.Strings(exts)
+		name = name + exts[0]
+	}
+	return name
+}
+
+// preferredExtensions overrides the extension chosen for MIME types which
+// have several common extensions.
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/tiff": ".tiff",
+	"audio/mpeg": ".mp3",
+	"text/plain": ".txt",
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &enexFile{f.(mem.File), db.errs[name]}, nil
+}
+
+type enexFile struct {
+	mem.File
+	err error
+}
+
+var _ notedb.Note = (*enexFile)(nil)
+var _ fs.ReadDirFile = (*enexFile)(nil)
+
+func (f *enexFile) ParseAST() (ast.Node, error) {
+	doc, err := f.File.ParseAST()
+	return doc, multierr.Append(f.err, err)
+}
@@ -0,0 +1,165 @@
+package enex
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func convertENML(t *testing.T, content string, media map[string]enmlMedia) (string, error) {
+	doc, err := parseENML(content)
+	require.NoError(t, err)
+	converter := &enmlConverter{media: media}
+	return converter.convert(doc), converter.err
+}
+
+func TestConvertENML(t *testing.T) {
+	t.Run("blocks", func(t *testing.T) {
+		out, err := convertENML(t, `<en-note><h2>Title</h2><div>Some <b>bold</b>, <span style="font-style: italic;">italic</span> and *literal* text.</div><div><br/></div><div>1. not a list</div></en-note>`, nil)
+		require.NoError(t, err)
+		require.Equal(t, "## Title\n\nSome **bold**, *italic* and \\*literal\\* text.\n\n1\\. not a list\n", out)
+	})
+	t.Run("escaping", func(t *testing.T) {
+		out, err := convertENML(t, `<en-note><div># Not a heading, a|b &amp;amp; ~tilde</div><div>~~~</div></en-note>`, nil)
+		require.NoError(t, err)
+		require.Equal(t, "\\# Not a heading, a\\|b \\&amp; ~tilde\n\n\\~~~\n", out)
+	})
+	t.Run("lists", func(t *testing.T) {
+		out, err := convertENML(t, `<en-note><ul><li><en-todo checked="true"/>done</li><li>todo</li><ul><li>nested</li></ul></ul><ol><li>one</li><li>two</li></ol></en-note>`, nil)
+		require.NoError(t, err)
+		require.Equal(t, "- [x] done\n- todo\n  - nested\n\n1. one\n2. two\n", out)
+	})
+	t.Run("media", func(t *testing.T) {
+		media := map[string]enmlMedia{
+			"abc": {"_resources/a%20b.png", "a b.png"},
+		}
+		out, err := convertENML(t, `<en-note><div><en-media hash="ABC" type="image/png"/><en-media hash="def" type="application/pdf"/></div></en-note>`, media)
+		require.Error(t, err)
+		require.Equal(t, "missing resource: def", err.Error())
+		require.Equal(t, "![a b.png](_resources/a%20b.png)\n", out)
+	})
+	t.Run("table", func(t *testing.T) {
+		out, err := convertENML(t, `<en-note><table><tbody><tr><td>a</td><td>b</td></tr><tr><td>c|d</td></tr></tbody></table></en-note>`, nil)
+		require.NoError(t, err)
+		require.Equal(t, "| a | b |\n| --- | --- |\n| c\\|d |  |\n", out)
+	})
+}
+
+func TestReadDirectory(t *testing.T) {
+	root := t.TempDir()
+	sum := md5.Sum([]byte("PNG"))
+	hash := hex.EncodeToString(sum[:])
+	enexFile := func(title, created, updated string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20210601T120000Z" application="Evernote" version="10.0">
+<note><title>` + title + `</title><content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>See <en-media hash="` + hash + `" type="image/png"/></div></en-note>]]></content>
+<created>` + created + `</created><updated>` + updated + `</updated>
+<resource><data encoding="base64">
+` + base64.StdEncoding.EncodeToString([]byte("PNG")) + `
+</data><mime>image/png</mime><resource-attributes><file-name>a.png</file-name></resource-attributes></resource>
+</note>
+</en-export>
+`
+	}
+	notebookNotes := enexFile("First", "20210601T120000Z", "20210602T120000Z")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "Notebook.enex"), []byte(notebookNotes), 0666))
+	otherNotes := enexFile("Second", "20210603T120000Z", "")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "Other.enex"), []byte(otherNotes), 0666))
+
+	dbURL := &url.URL{Scheme: "evernote-export", Path: root}
+	require.Equal(t, notedb.DetectResultPositive, Detect(dbURL))
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notebook",
+		"Notebook/First.md",
+		"Notebook/_resources",
+		"Notebook/_resources/a.png",
+		"Other",
+		"Other/Second.md",
+		"Other/_resources",
+		"Other/_resources/a.png",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "See ![a.png](_resources/a.png)\n", notedbtest.RenderNote(t, db, "Notebook/First.md"))
+	data, err := fs.ReadFile(db, "Other/_resources/a.png")
+	require.NoError(t, err)
+	require.Equal(t, "PNG", string(data))
+
+	times := map[string][2]time.Time{
+		"Notebook/First.md": {time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC)},
+		"Other/Second.md":   {time.Date(2021, 6, 3, 12, 0, 0, 0, time.UTC), time.Date(2021, 6, 3, 12, 0, 0, 0, time.UTC)},
+	}
+	for notePath, expected := range times {
+		note, err := db.Open(notePath)
+		require.NoError(t, err)
+		metadata, err := note.(notedb.Note).Metadata()
+		require.NoError(t, err)
+		require.True(t, metadata.Created.Equal(expected[0]), notePath)
+		require.True(t, metadata.Updated.Equal(expected[1]), notePath)
+		info, err := note.Stat()
+		require.NoError(t, err)
+		require.True(t, info.ModTime().Equal(expected[1]), notePath)
+		require.NoError(t, note.Close())
+	}
+}
+
+func TestWriter(t *testing.T) {
+	enexPath := filepath.Join(t.TempDir(), "out.enex")
+	dbURL := &url.URL{Scheme: "evernote-export", Path: enexPath}
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "images", 0777))
+	f, err := fs.Create(db, "First.md")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("# First\n\nAn image ![img](images/a.png) and $x^2$.\n"))
+	require.NoError(t, err)
+	metadata := notedb.Metadata{
+		Title:     "First: the note",
+		Tags:      []string{"work", "ideas"},
+		Author:    "Alice",
+		SourceURL: "https://example.com/",
+		Location:  &notedb.Location{Latitude: 35.6895, Longitude: 139.6917},
+	}
+	require.NoError(t, notedb.WriteMetadata(f.(notedb.Note), metadata))
+	require.NoError(t, f.Close())
+	notedbtest.WriteFile(t, db, "images/a.png", "PNG")
+	require.NoError(t, fs.Chtimes(db, "First.md", modTime, modTime))
+	err = notedb.CloseDatabase(db)
+	require.Error(t, err)
+	require.Equal(t, "convert First.md: inline math is not supported in ENML", err.Error())
+
+	db, err = OpenDatabase(dbURL)
+	require.NoError(t, err)
+	data, err := fs.ReadFile(db, "First the note.md")
+	require.NoError(t, err)
+	require.Equal(t, "# First\n\nAn image ![a.png](_resources/a.png) and `x^2`.\n", string(data))
+	info, err := fs.Stat(db, "First the note.md")
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(modTime))
+	note, err := db.Open("First the note.md")
+	require.NoError(t, err)
+	metadata.Created = modTime
+	metadata.Updated = modTime
+	readMetadata, err := note.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, metadata, readMetadata)
+	data, err = fs.ReadFile(db, "_resources/a.png")
+	require.NoError(t, err)
+	require.Equal(t, "PNG", string(data))
+}
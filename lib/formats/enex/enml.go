@@ -0,0 +1,450 @@
+package enex
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"go.uber.org/multierr"
+)
+
+// enmlNode is a node in a parsed ENML document. Text nodes have an empty name.
+type enmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*enmlNode
+	text     string
+}
+
+func (n *enmlNode) style(property string) string {
+	for _, decl := range strings.Split(n.attrs["style"], ";") {
+		sep := strings.IndexByte(decl, ':')
+		if sep == -1 {
+			continue
+		}
+		if strings.TrimSpace(decl[:sep]) == property {
+			return strings.TrimSpace(decl[sep+1:])
+		}
+	}
+	return ""
+}
+
+// parseENML parses the content of an Evernote note. ENML is XHTML, but it is
+// parsed leniently since Evernote does not always produce well-formed
+// documents.
+func parseENML(content string) (*enmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &enmlNode{name: "#document"}
+	stack := []*enmlNode{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return root, err
+		}
+		top := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &enmlNode{name: strings.ToLower(t.Name.Local), attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				node.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			top.children = append(top.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			top.children = append(top.children, &enmlNode{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// enmlMedia describes the file referred to by an en-media element.
+type enmlMedia struct {
+	// Destination is the Markdown link destination of the file.
+	Destination string
+	// Name is the file name, used as the link text.
+	Name string
+}
+
+// enmlConverter converts ENML into Markdown. Problems which cause content to
+// be lost are collected in err.
+type enmlConverter struct {
+	media map[string]enmlMedia
+	err   error
+}
+
+// convert returns the Markdown source of the document.
+func (c *enmlConverter) convert(doc *enmlNode) string {
+	blocks := c.blocks(doc.children)
+	if len(blocks) == 0 {
+		return ""
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+var blockElements = map[string]bool{
+	"en-note": true, "div": true, "p": true, "center": true, "section": true,
+	"article": true, "header": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "table": true, "pre": true, "blockquote": true,
+	"hr": true, "dl": true,
+}
+
+// blocks converts the nodes into Markdown blocks. Runs of inline nodes are
+// collected into paragraphs.
+func (c *enmlConverter) blocks(nodes []*enmlNode) []string {
+	var ret []string
+	var paragraph strings.Builder
+	flush := func() {
+		if text := finishParagraph(paragraph.String()); text != "" {
+			ret = append(ret, text)
+		}
+		paragraph.Reset()
+	}
+	for _, node := range nodes {
+		if blockElements[node.name] {
+			flush()
+			ret = append(ret, c.block(node)...)
+		} else {
+			paragraph.WriteString(c.inline(node))
+		}
+	}
+	flush()
+	return ret
+}
+
+var hardBreak = "\\\n"
+
+// finishParagraph trims the whitespace from the lines of the paragraph, and
+// escapes anything which would be mistaken for the start of a block.
+func finishParagraph(text string) string {
+	lines := strings.Split(text, hardBreak)
+	kept := lines[:0]
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kept = append(kept, parser.EscapeLineStart(line))
+	}
+	return strings.Join(kept, hardBreak)
+}
+
+func (c *enmlConverter) block(node *enmlNode) []string {
+	switch node.name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.TrimSpace(strings.ReplaceAll(c.inlines(node.children), hardBreak, " "))
+		if text == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", int(node.name[1]-'0')) + " " + text}
+	case "ul", "ol":
+		return []string{c.list(node)}
+	case "table":
+		if table := c.table(node); table != "" {
+			return []string{table}
+		}
+		return nil
+	case "pre":
+		return []string{codeBlock(textContent(node))}
+	case "blockquote":
+		content := strings.Join(c.blocks(node.children), "\n\n")
+		if content == "" {
+			return nil
+		}
+		return []string{prefixLines(content, "> ", "> ")}
+	case "hr":
+		return []string{"---"}
+	}
+	if strings.HasPrefix(node.style("-en-codeblock"), "true") {
+		return []string{codeBlock(textContent(node))}
+	}
+	return c.blocks(node.children)
+}
+
+// list converts a ul or ol element. Evernote places nested lists directly
+// inside of the parent list, rather than inside of a list item, so these are
+// attached to the previous item.
+func (c *enmlConverter) list(node *enmlNode) string {
+	var items []string
+	number := 1
+	for _, child := range node.children {
+		switch child.name {
+		case "li":
+			marker := "- "
+			if node.name == "ol" {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			content := joinItemBlocks(c.blocks(child.children))
+			items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+		case "ul", "ol":
+			nested := c.list(child)
+			if len(items) == 0 {
+				items = append(items, nested)
+				continue
+			}
+			indent := "  "
+			if node.name == "ol" {
+				indent = "   "
+			}
+			items[len(items)-1] += "\n" + prefixLines(nested, indent, indent)
+		}
+	}
+	return strings.Join(items, "\n")
+}
+
+var listStart = regexp.MustCompile(`^(- |\d+\. )`)
+
+// joinItemBlocks combines the blocks inside of a list item. Nested lists
+// directly follow the preceding block, so that the list remains tight.
+func joinItemBlocks(blocks []string) string {
+	var out strings.Builder
+	for i, block := range blocks {
+		if i != 0 {
+			if listStart.MatchString(block) {
+				out.WriteString("\n")
+			} else {
+				out.WriteString("\n\n")
+			}
+		}
+		out.WriteString(block)
+	}
+	return out.String()
+}
+
+func (c *enmlConverter) table(node *enmlNode) string {
+	var rows [][]string
+	var visit func(n *enmlNode)
+	visit = func(n *enmlNode) {
+		for _, child := range n.children {
+			switch child.name {
+			case "tr":
+				var row []string
+				for _, cell := range child.children {
+					if cell.name != "td" && cell.name != "th" {
+						continue
+					}
+					text := strings.Join(c.blocks(cell.children), " ")
+					text = strings.ReplaceAll(text, hardBreak, " ")
+					text = strings.ReplaceAll(text, "\n", " ")
+					row = append(row, escapePipes(text))
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				visit(child)
+			}
+		}
+	}
+	visit(node)
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	writeRow := func(row []string) {
+		for i := 0; i < columns; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			out.WriteString("| " + cell + " ")
+		}
+		out.WriteString("|\n")
+	}
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+	writeRow(rows[0])
+	writeRow(separator)
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// unescapedPipe matches a pipe along with the backslashes before it.
+var unescapedPipe = regexp.MustCompile(`\\*\|`)
+
+// escapePipes escapes the pipes in the text of a table cell which are not
+// already escaped. Text is escaped when it is converted, but code spans are
+// not, and a pipe in them would still end the cell.
+func escapePipes(text string) string {
+	return unescapedPipe.ReplaceAllStringFunc(text, func(m string) string {
+		if len(m)%2 == 1 {
+			return "\\" + m
+		}
+		return m
+	})
+}
+
+func (c *enmlConverter) inlines(nodes []*enmlNode) string {
+	var out strings.Builder
+	for _, node := range nodes {
+		out.WriteString(c.inline(node))
+	}
+	return out.String()
+}
+
+func (c *enmlConverter) inline(node *enmlNode) string {
+	switch node.name {
+	case "":
+		return parser.EscapeInline(collapseSpace(node.text))
+	case "br":
+		return hardBreak
+	case "b", "strong":
+		return wrapInline(c.inlines(node.children), "**")
+	case "i", "em":
+		return wrapInline(c.inlines(node.children), "*")
+	case "s", "strike", "del":
+		return wrapInline(c.inlines(node.children), "~~")
+	case "code", "tt", "kbd":
+		text := collapseSpace(textContent(node))
+		if strings.TrimSpace(text) == "" {
+			return text
+		}
+		return "`" + text + "`"
+	case "a":
+		text := strings.TrimSpace(c.inlines(node.children))
+		href := node.attrs["href"]
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = parser.EscapeInline(href)
+		}
+		return "[" + text + "](" + escapeDestination(href) + ")"
+	case "img":
+		if src := node.attrs["src"]; src != "" {
+			return "![" + parser.EscapeInline(node.attrs["alt"]) + "](" + escapeDestination(src) + ")"
+		}
+		return ""
+	case "en-media":
+		hash := strings.ToLower(node.attrs["hash"])
+		media, ok := c.media[hash]
+		if !ok {
+			c.err = multierr.Append(c.err, fmt.Errorf("missing resource: %s", hash))
+			return ""
+		}
+		link := "[" + parser.EscapeInline(media.Name) + "](" + media.Destination + ")"
+		if strings.HasPrefix(node.attrs["type"], "image/") {
+			link = "!" + link
+		}
+		return link
+	case "en-todo":
+		if node.attrs["checked"] == "true" {
+			return "[x] "
+		}
+		return "[ ] "
+	case "en-crypt":
+		c.err = multierr.Append(c.err, fmt.Errorf("encrypted content is not supported"))
+		return ""
+	}
+
+	text := c.inlines(node.children)
+	if weight := node.style("font-weight"); weight == "bold" || weight == "700" {
+		text = wrapInline(text, "**")
+	}
+	if node.style("font-style") == "italic" {
+		text = wrapInline(text, "*")
+	}
+	if strings.Contains(node.style("text-decoration"), "line-through") {
+		text = wrapInline(text, "~~")
+	}
+	return text
+}
+
+// wrapInline surrounds the text with the delimiter. Surrounding whitespace is
+// kept outside of the delimiters, since Markdown does not allow it inside.
+func wrapInline(text, delim string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + delim + trimmed + delim + text[start+len(trimmed):]
+}
+
+func textContent(node *enmlNode) string {
+	if node.name == "" {
+		return node.text
+	}
+	var out strings.Builder
+	for _, child := range node.children {
+		if child.name == "br" {
+			out.WriteString("\n")
+			continue
+		}
+		out.WriteString(textContent(child))
+		if child.name == "div" || child.name == "p" {
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+func codeBlock(text string) string {
+	text = strings.Trim(text, "\n")
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence = fence + "`"
+	}
+	return fence + "\n" + text + "\n" + fence
+}
+
+// prefixLines adds the first prefix to the first line and the rest prefix to
+// all subsequent non-empty lines.
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			lines[i] = first + line
+		} else if line != "" {
+			lines[i] = rest + line
+		} else {
+			lines[i] = strings.TrimRight(rest, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var spaceRun = regexp.MustCompile(`[ \t\r\n\x{a0}]+`)
+
+func collapseSpace(text string) string {
+	return spaceRun.ReplaceAllString(text, " ")
+}
+
+var destinationEscaper = strings.NewReplacer(
+	" ", "%20",
+	"(", "%28",
+	")", "%29",
+	"<", "%3C",
+	">", "%3E",
+)
+
+func escapeDestination(dest string) string {
+	return destinationEscaper.Replace(dest)
+}
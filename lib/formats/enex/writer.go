@@ -0,0 +1,208 @@
+package enex
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/enml"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+const exportHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+`
+
+// Writer is a database which holds the written notes in memory and produces
+// an ENEX file when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+func newWriter(path string) *Writer {
+	return &Writer{mem.New(), path}
+}
+
+type exportDocument struct {
+	XMLName     xml.Name     `xml:"en-export"`
+	ExportDate  string       `xml:"export-date,attr"`
+	Application string       `xml:"application,attr"`
+	Notes       []exportNote `xml:"note"`
+}
+
+type exportNote struct {
+	Title      string          `xml:"title"`
+	Content    exportContent   `xml:"content"`
+	Created    string          `xml:"created"`
+	Updated    string          `xml:"updated"`
+	Tags       []string        `xml:"tag"`
+	Attributes *enexAttributes `xml:"note-attributes"`
+	Resources  []enexResource  `xml:"resource"`
+}
+
+type exportContent struct {
+	Value string `xml:",cdata"`
+}
+
+// Close writes the ENEX file. ENEX files do not support directories, so all
+// notes are placed at the top level. Files which are not referenced by any
+// note cannot be represented, and are reported as errors.
+func (w *Writer) Close() error {
+	doc := exportDocument{
+		ExportDate:  time.Now().UTC().Format(enexTimeFormat),
+		Application: "Pilikino",
+	}
+	var notePaths []string
+	attachments := map[string]bool{}
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(p, ".md") {
+			notePaths = append(notePaths, p)
+		} else {
+			attachments[p] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, notePath := range notePaths {
+		note, noteErr := w.buildNote(notePath, attachments)
+		for _, noteErr := range multierr.Errors(noteErr) {
+			errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: notePath, Err: noteErr})
+		}
+		if note != nil {
+			doc.Notes = append(doc.Notes, *note)
+		}
+	}
+	for p, unused := range attachments {
+		if unused {
+			errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: fmt.Errorf("file is not attached to any note")})
+		}
+	}
+
+	file, err := os.Create(w.path)
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+	defer file.Close()
+	if _, err := io.WriteString(file, exportHeader); err != nil {
+		return multierr.Append(errs, err)
+	}
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(&doc); err != nil {
+		return multierr.Append(errs, err)
+	}
+	return multierr.Append(errs, file.Close())
+}
+
+// buildNote converts a note into its ENEX representation. Any attachments
+// referenced by the note are marked as used in the attachments map.
+func (w *Writer) buildNote(notePath string, attachments map[string]bool) (*exportNote, error) {
+	data, err := fs.ReadFile(w.Database, notePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(w.Database, notePath)
+	if err != nil {
+		return nil, err
+	}
+	modTime := info.ModTime().UTC().Format(enexTimeFormat)
+	note := &exportNote{
+		Title:   strings.TrimSuffix(path.Base(notePath), ".md"),
+		Created: modTime,
+		Updated: modTime,
+	}
+	metadata, err := w.Metadata(notePath)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Title != "" {
+		note.Title = metadata.Title
+	}
+	note.Tags = metadata.Tags
+	if !metadata.Created.IsZero() {
+		note.Created = metadata.Created.UTC().Format(enexTimeFormat)
+	}
+	if metadata.Author != "" || metadata.SourceURL != "" || metadata.Location != nil {
+		note.Attributes = &enexAttributes{Author: metadata.Author, SourceURL: metadata.SourceURL}
+		if location := metadata.Location; location != nil {
+			note.Attributes.Latitude = strconv.FormatFloat(location.Latitude, 'f', -1, 64)
+			note.Attributes.Longitude = strconv.FormatFloat(location.Longitude, 'f', -1, 64)
+			note.Attributes.Altitude = strconv.FormatFloat(location.Altitude, 'f', -1, 64)
+		}
+	}
+
+	var errs error
+	hashes := map[string]bool{}
+	resolveMedia := func(dest []byte) (enml.Media, bool) {
+		target, _, ok := notedb.LocalLink(notePath, dest)
+		if !ok {
+			return enml.Media{}, false
+		}
+		if _, ok := attachments[target]; !ok {
+			return enml.Media{}, false
+		}
+		attachment, readErr := fs.ReadFile(w.Database, target)
+		if readErr != nil {
+			errs = multierr.Append(errs, readErr)
+			return enml.Media{}, false
+		}
+		attachments[target] = false
+		sum := md5.Sum(attachment)
+		media := enml.Media{Hash: hex.EncodeToString(sum[:]), Type: mimeType(target)}
+		if !hashes[media.Hash] {
+			hashes[media.Hash] = true
+			resource := enexResource{Mime: media.Type}
+			resource.Data.Encoding = "base64"
+			resource.Data.Value = base64.StdEncoding.EncodeToString(attachment)
+			resource.Attributes.FileName = path.Base(target)
+			note.Resources = append(note.Resources, resource)
+		}
+		return media, true
+	}
+
+	doc, err := parser.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	renderErr := enml.NewRenderer(enml.WithMediaResolver(resolveMedia)).Render(&buf, data, doc)
+	errs = multierr.Append(errs, renderErr)
+	note.Content.Value = buf.String()
+	return note, errs
+}
+
+// mimeType returns the MIME type of the file, based on its extension.
+func mimeType(name string) string {
+	t := mime.TypeByExtension(path.Ext(name))
+	if t == "" {
+		return "application/octet-stream"
+	}
+	if sep := strings.IndexByte(t, ';'); sep != -1 {
+		t = t[:sep]
+	}
+	return t
+}
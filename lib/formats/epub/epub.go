@@ -0,0 +1,425 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	mathjax "github.com/litao91/goldmark-mathjax"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	goldmarkHTML "github.com/yuin/goldmark/renderer/html"
+	"go.uber.org/multierr"
+)
+
+const (
+	// contentDir is the directory inside of the book which holds the
+	// package document and everything it refers to.
+	contentDir  = "EPUB"
+	chaptersDir = "text"
+	imagesDir   = "images"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "epub",
+		Description: "EPUB 3 book",
+		Documentation: `This format writes an EPUB 3 book, which can be read on most e-readers.
+It can only be used as the destination of a conversion, and the file must not
+exist. Paths ending with .epub are detected automatically.
+
+Each note becomes a chapter, in the order that the notes appear in the
+directory, and the table of contents follows the directory hierarchy. Links
+between notes become links between chapters, and images are embedded in the
+book. Other attachments cannot be embedded and are reported as errors, and
+raw HTML in the notes is omitted.
+
+The title of the book is the name of the file, which can be changed with the
+title query parameter, and the language can be set with the lang parameter,
+for example epub:///notes.epub?title=Notes&lang=en.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// imageTypes maps the extensions of the images which can be embedded in the
+// book to their media types.
+var imageTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+// markdown renders the chapters with the same extensions used by
+// parser.Parse. Raw HTML is omitted, since it may not be valid XHTML.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(mathjax.MathJax, extension.Table),
+	goldmark.WithRendererOptions(goldmarkHTML.WithXHTML()),
+)
+
+var templates = template.Must(template.New("epub").Funcs(template.FuncMap{
+	"escape": html.EscapeString,
+}).Parse(`{{define "container"}}<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+{{end}}
+{{define "package"}}<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" xml:lang="{{escape .Lang}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:uuid:{{.ID}}</dc:identifier>
+    <dc:title>{{escape .Title}}</dc:title>
+    <dc:language>{{escape .Lang}}</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range .Chapters}}
+    <item id="{{.ID}}" href="{{.Href}}" media-type="application/xhtml+xml"/>
+{{- end}}
+{{- range .Images}}
+    <item id="{{.ID}}" href="{{.Href}}" media-type="{{.Type}}"/>
+{{- end}}
+  </manifest>
+  <spine>
+{{- range .Chapters}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+{{end}}
+{{define "nav"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{escape .Lang}}" xml:lang="{{escape .Lang}}">
+<head>
+<meta charset="UTF-8"/>
+<title>{{escape .Title}}</title>
+</head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>{{escape .Title}}</h1>
+{{template "toc" .TOC}}
+</nav>
+</body>
+</html>
+{{end}}
+{{define "toc"}}<ol>
+{{- range .}}
+<li>{{if .Href}}<a href="{{.Href}}">{{escape .Name}}</a>{{else}}<span>{{escape .Name}}</span>
+{{template "toc" .Children}}{{end}}</li>
+{{- end}}
+</ol>{{end}}
+{{define "chapter"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{escape .Lang}}" xml:lang="{{escape .Lang}}">
+<head>
+<meta charset="UTF-8"/>
+<title>{{escape .Title}}</title>
+</head>
+<body>
+{{.Content}}</body>
+</html>
+{{end}}`))
+
+// item is a file in the manifest of the book.
+type item struct {
+	ID   string
+	Href string
+	Type string
+}
+
+// tocEntry is an entry in the table of contents. Directories have children
+// but no link.
+type tocEntry struct {
+	Name     string
+	Href     string
+	Children []*tocEntry
+}
+
+// Writer is a database which holds the written notes in memory and produces
+// the book when it is closed.
+type Writer struct {
+	*mem.Database
+	path  string
+	title string
+	lang  string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+// OpenDatabase is the entrypoint for the EPUB format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	if _, err := os.Stat(dbURL.Path); err == nil {
+		return nil, fmt.Errorf("%s: already exists, and EPUB files cannot be read", dbURL.Path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	query := dbURL.Query()
+	w := &Writer{mem.New(), dbURL.Path, query.Get("title"), query.Get("lang")}
+	if w.title == "" {
+		w.title = strings.TrimSuffix(filepath.Base(dbURL.Path), filepath.Ext(dbURL.Path))
+	}
+	if w.lang == "" {
+		w.lang = "en"
+	}
+	return w, nil
+}
+
+// Detect determines if the URL is likely to be an EPUB file.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if strings.HasSuffix(strings.ToLower(dbURL.Path), ".epub") {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+// noteTitle returns the title of the chapter for the note, which is the title
+// from its metadata or else the name of the note.
+func (w *Writer) noteTitle(p string) string {
+	if metadata, err := w.Metadata(p); err == nil && metadata.Title != "" {
+		return metadata.Title
+	}
+	return strings.TrimSuffix(path.Base(p), ".md")
+}
+
+// Close writes the book.
+func (w *Writer) Close() error {
+	// targets maps the paths in the database to their paths in the book,
+	// relative to contentDir.
+	targets := map[string]string{}
+	var chapters, images []item
+	var notes, files []string
+	modified := time.Time{}
+	toc := map[string]*tocEntry{".": {}}
+	var errs error
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		parent := toc[path.Dir(p)]
+		if d.IsDir() {
+			toc[p] = &tocEntry{Name: d.Name()}
+			parent.Children = append(parent.Children, toc[p])
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		ext := strings.ToLower(path.Ext(p))
+		if ext == ".md" {
+			id := fmt.Sprintf("chapter%03d", len(chapters)+1)
+			targets[p] = path.Join(chaptersDir, id+".xhtml")
+			chapters = append(chapters, item{id, targets[p], ""})
+			notes = append(notes, p)
+			parent.Children = append(parent.Children, &tocEntry{Name: w.noteTitle(p), Href: targets[p]})
+		} else if mediaType, ok := imageTypes[ext]; ok {
+			id := fmt.Sprintf("image%03d", len(images)+1)
+			targets[p] = path.Join(imagesDir, id+ext)
+			images = append(images, item{id, targets[p], mediaType})
+			files = append(files, p)
+		} else {
+			// Other attachments are not part of the book.
+			targets[p] = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	out, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	errs = multierr.Append(errs, writeMimetype(zw))
+	errs = multierr.Append(errs, w.writeTemplate(zw, "META-INF/container.xml", "container", nil, modified))
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	errs = multierr.Append(errs, w.writeTemplate(zw, path.Join(contentDir, "package.opf"), "package", map[string]interface{}{
+		"ID":       id,
+		"Title":    w.title,
+		"Lang":     w.lang,
+		"Modified": modified.UTC().Format("2006-01-02T15:04:05Z"),
+		"Chapters": chapters,
+		"Images":   images,
+	}, modified))
+	errs = multierr.Append(errs, w.writeTemplate(zw, path.Join(contentDir, "nav.xhtml"), "nav", map[string]interface{}{
+		"Title": w.title,
+		"Lang":  w.lang,
+		"TOC":   pruneTOC(toc["."].Children),
+	}, modified))
+
+	for _, p := range notes {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		content, convertErr := renderChapter(p, data, targets)
+		for _, err := range multierr.Errors(convertErr) {
+			errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: err})
+		}
+		errs = multierr.Append(errs, w.writeTemplate(zw, path.Join(contentDir, targets[p]), "chapter", map[string]interface{}{
+			"Title":   w.noteTitle(p),
+			"Lang":    w.lang,
+			"Content": content,
+		}, w.ModTime(p)))
+	}
+	for _, p := range files {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		errs = multierr.Append(errs, writeEntry(zw, path.Join(contentDir, targets[p]), data, zip.Deflate, w.ModTime(p)))
+	}
+
+	if err := zw.Close(); err != nil {
+		return multierr.Append(errs, err)
+	}
+	return multierr.Append(errs, out.Close())
+}
+
+func (w *Writer) writeTemplate(zw *zip.Writer, name, template string, data interface{}, modTime time.Time) error {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, template, data); err != nil {
+		return err
+	}
+	return writeEntry(zw, name, buf.Bytes(), zip.Deflate, modTime)
+}
+
+// mimetype is the contents of the mimetype file of every book.
+const mimetype = "application/epub+zip"
+
+// writeMimetype writes the mimetype file, which must be the first file in
+// the book. Readers identify the book by finding its contents at a fixed
+// offset, so it is stored uncompressed, with its sizes in the local header
+// instead of a data descriptor, and without an extra field.
+func writeMimetype(zw *zip.Writer) error {
+	dest, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE([]byte(mimetype)),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(dest, mimetype)
+	return err
+}
+
+func writeEntry(zw *zip.Writer, name string, data []byte, method uint16, modTime time.Time) error {
+	dest, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = dest.Write(data)
+	return err
+}
+
+// pruneTOC removes the directories which do not contain any notes, since
+// every entry in the table of contents must lead to a chapter.
+func pruneTOC(entries []*tocEntry) []*tocEntry {
+	var ret []*tocEntry
+	for _, e := range entries {
+		if e.Href == "" {
+			if e.Children = pruneTOC(e.Children); len(e.Children) == 0 {
+				continue
+			}
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// renderChapter renders the note as the body of a chapter. Links to other
+// notes and images are updated to point at their locations in the book.
+func renderChapter(notePath string, data []byte, targets map[string]string) (string, error) {
+	doc, err := parser.Parse(data, parser.HeadingIDs)
+	if err != nil {
+		return "", err
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var linkErr error
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination, linkErr = rewriteLink(notePath, n.Destination, targets)
+		case *ast.Image:
+			n.Destination, linkErr = rewriteLink(notePath, n.Destination, targets)
+		}
+		err = multierr.Append(err, linkErr)
+		return ast.WalkContinue, nil
+	})
+	if walkErr != nil {
+		return "", multierr.Append(err, walkErr)
+	}
+	var buf bytes.Buffer
+	err = multierr.Append(err, markdown.Renderer().Render(&buf, data, doc))
+	return buf.String(), err
+}
+
+// rewriteLink converts a link to a note or image into a link to its location
+// in the book, relative to the chapter. Attachments which are not part of the
+// book have an empty target.
+func rewriteLink(notePath string, dest []byte, targets map[string]string) ([]byte, error) {
+	source, fragment, ok := notedb.LocalLink(notePath, dest)
+	if !ok {
+		return dest, nil
+	}
+	target, ok := targets[source]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	} else if target == "" {
+		return dest, fmt.Errorf("attachment cannot be embedded: %s", dest)
+	}
+	return notedb.RelativeLink(targets[notePath], target, fragment), nil
+}
+
+// newUUID returns a random version 4 UUID, which identifies the book.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
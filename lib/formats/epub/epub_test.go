@@ -0,0 +1,121 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func readEntry(t *testing.T, f *zip.File) string {
+	r, err := f.Open()
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "Book.epub")
+	dbURL, err := notedb.ResolveURL(file)
+	require.NoError(t, err)
+	require.Equal(t, "epub", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Empty", 0777))
+	require.NoError(t, fs.MkdirAll(db, "Part 1", 0777))
+	notedbtest.WriteFileAt(t, db, "Introduction.md", "# Intro & Welcome\n\nRead [the chapter](Part%201/Chapter.md#start).<br>\n", modTime)
+	notedbtest.WriteFileAt(t, db, "Part 1/Chapter.md", "## Start\n\n![cover](../cover.png) [back](../Introduction.md) [file](../notes.pdf)\n", modTime)
+	notedbtest.WriteFileAt(t, db, "cover.png", "png", modTime)
+	notedbtest.WriteFileAt(t, db, "notes.pdf", "pdf", modTime)
+	require.EqualError(t, notedb.CloseDatabase(db), "convert Part 1/Chapter.md: attachment cannot be embedded: ../notes.pdf")
+
+	r, err := zip.OpenReader(file)
+	require.NoError(t, err)
+	defer r.Close()
+	entries := map[string]string{}
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		entries[f.Name] = readEntry(t, f)
+		if f.Name != "mimetype" {
+			require.True(t, modTime.Equal(f.Modified), f.Name)
+		}
+		if f.Name != "mimetype" && f.Name != "EPUB/images/image001.png" {
+			decoder := xml.NewDecoder(bytes.NewReader([]byte(entries[f.Name])))
+			for {
+				_, err := decoder.Token()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err, f.Name)
+			}
+		}
+	}
+	require.Equal(t, []string{
+		"mimetype",
+		"META-INF/container.xml",
+		"EPUB/package.opf",
+		"EPUB/nav.xhtml",
+		"EPUB/text/chapter001.xhtml",
+		"EPUB/text/chapter002.xhtml",
+		"EPUB/images/image001.png",
+	}, names)
+	require.Equal(t, zip.Store, r.File[0].Method)
+	require.Equal(t, "application/epub+zip", entries["mimetype"])
+
+	// The local header of the mimetype has no data descriptor flag, the
+	// stored method, the checksum and sizes, and no extra field, so the
+	// contents start at offset 38.
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	header := data[:30]
+	require.Equal(t, []byte("PK\x03\x04"), header[0:4])
+	require.Equal(t, uint16(0), binary.LittleEndian.Uint16(header[6:8]))
+	require.Equal(t, zip.Store, binary.LittleEndian.Uint16(header[8:10]))
+	require.Equal(t, crc32.ChecksumIEEE([]byte("application/epub+zip")), binary.LittleEndian.Uint32(header[14:18]))
+	require.Equal(t, uint32(20), binary.LittleEndian.Uint32(header[18:22]))
+	require.Equal(t, uint32(20), binary.LittleEndian.Uint32(header[22:26]))
+	require.Equal(t, uint16(8), binary.LittleEndian.Uint16(header[26:28]))
+	require.Equal(t, uint16(0), binary.LittleEndian.Uint16(header[28:30]))
+	require.Equal(t, "mimetypeapplication/epub+zip", string(data[30:58]))
+
+	opf := entries["EPUB/package.opf"]
+	require.Contains(t, opf, "<dc:title>Book</dc:title>")
+	require.Contains(t, opf, `<meta property="dcterms:modified">2021-06-01T12:00:00Z</meta>`)
+	require.Contains(t, opf, `<item id="image001" href="images/image001.png" media-type="image/png"/>`)
+	require.Contains(t, opf, "<spine>\n"+
+		`    <itemref idref="chapter001"/>`+"\n"+
+		`    <itemref idref="chapter002"/>`+"\n  </spine>")
+
+	require.Contains(t, entries["EPUB/nav.xhtml"], "<ol>\n"+
+		`<li><a href="text/chapter001.xhtml">Introduction</a></li>`+"\n"+
+		"<li><span>Part 1</span>\n<ol>\n"+
+		`<li><a href="text/chapter002.xhtml">Chapter</a></li>`+"\n</ol></li>\n</ol>")
+	require.NotContains(t, entries["EPUB/nav.xhtml"], "Empty")
+
+	intro := entries["EPUB/text/chapter001.xhtml"]
+	require.Contains(t, intro, "<title>Introduction</title>")
+	require.Contains(t, intro, `<h1 id="intro--welcome">Intro &amp; Welcome</h1>`)
+	require.Contains(t, intro, `<a href="chapter002.xhtml#start">the chapter</a>`)
+	chapter := entries["EPUB/text/chapter002.xhtml"]
+	require.Contains(t, chapter, `<h2 id="start">Start</h2>`)
+	require.Contains(t, chapter, `<img src="../images/image001.png" alt="cover" />`)
+	require.Contains(t, chapter, `<a href="chapter001.xhtml">back</a>`)
+
+	_, err = OpenDatabase(dbURL)
+	require.Error(t, err)
+}
@@ -1,10 +1,13 @@
 package file
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -13,20 +16,27 @@ import (
 	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
 	"github.com/CGamesPlay/pilikino/lib/notedb"
 	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
 )
 
 func init() {
 	notedb.RegisterFormat(notedb.FormatDescription{
-		ID:            "file",
-		Description:   "Directory of files",
-		Documentation: `This file format corresponds to a directory of Markdown files.`,
-		Open:          OpenDatabase,
-		Detect:        Detect,
+		ID:          "file",
+		Description: "Directory of files",
+		Documentation: `This file format corresponds to a directory of Markdown files. The
+metadata of each note is stored in YAML front matter at the start of the
+note, using the title, tags, created, author, source, and location keys. The
+title is only stored when it differs from the name of the file.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
 	})
 }
 
 type Database struct {
 	fs.FS
+	// root is the directory holding the database, which is created when the
+	// database is first written to.
+	root string
 }
 
 var _ fs.MkdirAllFS = (*Database)(nil)
@@ -35,7 +45,7 @@ var _ fs.ChtimesFS = (*Database)(nil)
 
 // OpenDatabase is the entrypoint for the file format.
 func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
-	return &Database{fs.DirFS(dbURL.Path)}, nil
+	return &Database{fs.DirFS(dbURL.Path), dbURL.Path}, nil
 }
 
 // Detect determines if the URL is likely to be a note database.
@@ -48,24 +58,45 @@ func (db *Database) Open(path string) (fs.File, error) {
 }
 
 func (db *Database) OpenFile(path string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := db.createRoot(); err != nil {
+			return nil, err
+		}
+	}
 	f, err := fs.OpenFile(db.FS, path, flag, perm)
 	if err != nil {
 		return nil, err
 	}
-	return &file{f, nil}, nil
+	return &file{File: f, name: path}, nil
 }
 
 func (db *Database) MkdirAll(path string, perm fs.FileMode) error {
+	if err := db.createRoot(); err != nil {
+		return err
+	}
 	return fs.MkdirAll(db.FS, path, perm)
 }
 
+func (db *Database) createRoot() error {
+	if db.root == "" {
+		return nil
+	}
+	return os.MkdirAll(db.root, 0777)
+}
+
 func (db *Database) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return fs.Chtimes(db.FS, name, atime, mtime)
 }
 
 type file struct {
 	fs.File
+	name string
 	data []byte
+	// metadata is written as front matter when the file is closed. While it
+	// is set, the content of the note is buffered in buf.
+	metadata *notedb.Metadata
+	buf      bytes.Buffer
+	written  bool
 }
 
 var _ notedb.Note = (*file)(nil)
@@ -97,11 +128,27 @@ func (f *file) Data() []byte {
 		if err != nil {
 			panic(fmt.Errorf("failed to read note: %w", err))
 		}
-		fmt.Printf("Read data %#v\n", f.data)
 	}
 	return f.data
 }
 
+// Metadata returns the metadata stored in the front matter of the note.
+func (f *file) Metadata() (notedb.Metadata, error) {
+	return ReadFrontMatter(f.Data())
+}
+
+// WriteMetadata stores the metadata as front matter when the note is closed.
+// It must be called before the content of the note is written.
+func (f *file) WriteMetadata(m notedb.Metadata) error {
+	if f.written {
+		return errors.New("metadata must be written before the note")
+	}
+	if !m.IsZero() {
+		f.metadata = &m
+	}
+	return nil
+}
+
 func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
 	rdf, ok := f.File.(fs.ReadDirFile)
 	if !ok {
@@ -122,9 +169,24 @@ func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
 }
 
 func (f *file) Write(p []byte) (n int, err error) {
+	f.written = true
+	if f.metadata != nil {
+		return f.buf.Write(p)
+	}
 	return fs.Write(f.File, p)
 }
 
+func (f *file) Close() error {
+	if f.metadata == nil {
+		return f.File.Close()
+	}
+	stem := strings.TrimSuffix(path.Base(f.name), path.Ext(f.name))
+	data, err := WriteFrontMatter(f.buf.Bytes(), stem, *f.metadata)
+	f.metadata = nil
+	_, writeErr := fs.Write(f.File, data)
+	return multierr.Combine(err, writeErr, f.File.Close())
+}
+
 type fileInfo struct {
 	fs.FileInfo
 }
@@ -0,0 +1,69 @@
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrontMatter(t *testing.T) {
+	root := t.TempDir()
+	source := "---\ntitle: \"Trip: day 1\"\ntags: [travel, \"#photos\"]\ncreated: 2021-06-01T12:00:00Z\npinned: true\n---\n\n# Day 1\n\n---\n\nText\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "Trip.md"), []byte(source), 0666))
+	db, err := OpenDatabase(&url.URL{Scheme: "file", Path: root})
+	require.NoError(t, err)
+
+	f, err := db.Open("Trip.md")
+	require.NoError(t, err)
+	note := f.(notedb.Note)
+	metadata, err := note.Metadata()
+	require.NoError(t, err)
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, notedb.Metadata{
+		Title:   "Trip: day 1",
+		Tags:    []string{"travel", "photos"},
+		Created: created,
+		Extra:   map[string]interface{}{"pinned": true},
+	}, metadata)
+	doc, err := note.ParseAST()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, renderer.NewRenderer().Render(&buf, note.Data(), doc))
+	require.Equal(t, source, buf.String())
+
+	// Metadata from other formats is written as front matter.
+	w, err := fs.Create(db, "Other.md")
+	require.NoError(t, err)
+	require.NoError(t, notedb.WriteMetadata(w.(notedb.Note), notedb.Metadata{
+		Title:    "Other",
+		Tags:     []string{"work"},
+		Created:  created,
+		Updated:  created,
+		Location: &notedb.Location{Latitude: 1.5, Longitude: -2},
+	}))
+	_, err = fs.Write(w, []byte("Body\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data, err := fs.ReadFile(db, "Other.md")
+	require.NoError(t, err)
+	require.Equal(t, "---\ntags:\n- work\ncreated: 2021-06-01T12:00:00Z\nlocation:\n  latitude: 1.5\n  longitude: -2\n  altitude: 0\n---\n\nBody\n", string(data))
+
+	// Metadata which is already in the front matter leaves the note as it is.
+	w, err = fs.Create(db, "Copy.md")
+	require.NoError(t, err)
+	require.NoError(t, notedb.WriteMetadata(w.(notedb.Note), metadata))
+	_, err = fs.Write(w, []byte(source))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data, err = fs.ReadFile(db, "Copy.md")
+	require.NoError(t, err)
+	require.Equal(t, source, string(data))
+}
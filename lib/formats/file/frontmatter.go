@@ -0,0 +1,249 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"gopkg.in/yaml.v2"
+)
+
+// frontMatterTimeLayouts are the formats accepted for times in front matter.
+var frontMatterTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ReadFrontMatter returns the metadata stored in the YAML front matter of a
+// note. The title, tags, created, updated, author, source, and location keys
+// map to the corresponding fields of the metadata, and all other keys are
+// kept in Extra. Values which cannot be interpreted, such as a created key
+// which is not a time, are also kept in Extra.
+func ReadFrontMatter(data []byte) (notedb.Metadata, error) {
+	var m notedb.Metadata
+	items, err := readFrontMatterItems(data)
+	if err != nil {
+		return m, err
+	}
+	for _, item := range items {
+		key := fmt.Sprint(item.Key)
+		ok := true
+		switch key {
+		case "title":
+			m.Title, ok = item.Value.(string)
+		case "tags":
+			m.Tags, ok = parseTags(item.Value)
+		case "created":
+			m.Created, ok = parseTime(item.Value)
+		case "updated":
+			m.Updated, ok = parseTime(item.Value)
+		case "author":
+			m.Author, ok = item.Value.(string)
+		case "source":
+			m.SourceURL, ok = item.Value.(string)
+		case "location":
+			m.Location, ok = parseLocation(item.Value)
+		default:
+			ok = false
+		}
+		if !ok {
+			if m.Extra == nil {
+				m.Extra = map[string]interface{}{}
+			}
+			m.Extra[key] = plainValue(item.Value)
+		}
+	}
+	return m, nil
+}
+
+// WriteFrontMatter stores the metadata in the front matter of the note, which
+// has the given name without its extension. Keys in the existing front matter
+// are replaced by the values in the metadata, and other keys are kept. The
+// title is only stored when it differs from the name of the note, and the
+// time the note was updated is left to the modification time of the file. If
+// the existing front matter already holds the metadata, the note is returned
+// unchanged.
+func WriteFrontMatter(data []byte, name string, m notedb.Metadata) ([]byte, error) {
+	if existing, err := ReadFrontMatter(data); err != nil {
+		return data, err
+	} else if reflect.DeepEqual(existing, m) {
+		return data, nil
+	}
+	items, err := readFrontMatterItems(data)
+	if err != nil {
+		return data, err
+	}
+	for _, item := range metadataItems(name, m) {
+		found := false
+		for i := range items {
+			if items[i].Key == item.Key {
+				items[i].Value = item.Value
+				found = true
+			}
+		}
+		if !found {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return data, nil
+	}
+	yamlData, err := yaml.Marshal(items)
+	if err != nil {
+		return data, err
+	}
+	front, _ := parser.SplitFrontMatter(data)
+	body := data[len(front):]
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(yamlData)
+	buf.WriteString("---\n")
+	if front == nil && len(body) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// readFrontMatterItems decodes the front matter of the note, preserving the
+// order of the keys.
+func readFrontMatterItems(data []byte) (yaml.MapSlice, error) {
+	_, yamlData := parser.SplitFrontMatter(data)
+	var items yaml.MapSlice
+	if err := yaml.Unmarshal(yamlData, &items); err != nil {
+		return nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	return items, nil
+}
+
+// metadataItems returns the front matter keys which represent the metadata.
+func metadataItems(name string, m notedb.Metadata) yaml.MapSlice {
+	var items yaml.MapSlice
+	if m.Title != "" && m.Title != name {
+		items = append(items, yaml.MapItem{Key: "title", Value: m.Title})
+	}
+	if len(m.Tags) > 0 {
+		items = append(items, yaml.MapItem{Key: "tags", Value: m.Tags})
+	}
+	if !m.Created.IsZero() {
+		items = append(items, yaml.MapItem{Key: "created", Value: m.Created})
+	}
+	if m.Author != "" {
+		items = append(items, yaml.MapItem{Key: "author", Value: m.Author})
+	}
+	if m.SourceURL != "" {
+		items = append(items, yaml.MapItem{Key: "source", Value: m.SourceURL})
+	}
+	if m.Location != nil {
+		items = append(items, yaml.MapItem{Key: "location", Value: yaml.MapSlice{
+			{Key: "latitude", Value: m.Location.Latitude},
+			{Key: "longitude", Value: m.Location.Longitude},
+			{Key: "altitude", Value: m.Location.Altitude},
+		}})
+	}
+	keys := make([]string, 0, len(m.Extra))
+	for key := range m.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		items = append(items, yaml.MapItem{Key: key, Value: m.Extra[key]})
+	}
+	return items
+}
+
+// parseTags accepts either a list of tags or a single comma-separated string.
+// A leading # on each tag is removed.
+func parseTags(value interface{}) ([]string, bool) {
+	var tags []string
+	switch value := value.(type) {
+	case string:
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	case []interface{}:
+		for _, tag := range value {
+			str, ok := tag.(string)
+			if !ok {
+				return nil, false
+			}
+			tags = append(tags, strings.TrimPrefix(str, "#"))
+		}
+	default:
+		return nil, false
+	}
+	return tags, true
+}
+
+func parseTime(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		for _, layout := range frontMatterTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseLocation(value interface{}) (*notedb.Location, bool) {
+	items, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, false
+	}
+	var location notedb.Location
+	for _, item := range items {
+		var coordinate *float64
+		switch item.Key {
+		case "latitude":
+			coordinate = &location.Latitude
+		case "longitude":
+			coordinate = &location.Longitude
+		case "altitude":
+			coordinate = &location.Altitude
+		default:
+			return nil, false
+		}
+		switch value := item.Value.(type) {
+		case int:
+			*coordinate = float64(value)
+		case float64:
+			*coordinate = value
+		default:
+			return nil, false
+		}
+	}
+	return &location, true
+}
+
+// plainValue converts the nested mappings decoded from the front matter into
+// maps with string keys.
+func plainValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		ret := make(map[string]interface{}, len(value))
+		for _, item := range value {
+			ret[fmt.Sprint(item.Key)] = plainValue(item.Value)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(value))
+		for i, item := range value {
+			ret[i] = plainValue(item)
+		}
+		return ret
+	}
+	return value
+}
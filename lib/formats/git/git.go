@@ -0,0 +1,370 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/file"
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+)
+
+// defaultMessage is the commit message used when none is provided.
+const defaultMessage = "Update notes"
+
+// errNotHead is returned when writing to a database whose selected commit is
+// not HEAD, since the written files would not be based on it.
+var errNotHead = fmt.Errorf("%w: the selected commit is not HEAD", fs.ErrPermission)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "git",
+		Description: "Git repository",
+		Documentation: `This format reads a directory of Markdown files from a commit in a git
+repository, without checking it out. Use a URL like
+git+file:///path/to/repo?rev=v1.2 to select the commit; the default is HEAD.
+The modification time of each file is the time of the last commit which
+changed it.
+
+Files which are written are placed in the working tree of the repository,
+which is created if it does not exist. Writing is only possible when the
+selected commit is HEAD, and files which were not written cannot be changed,
+since the snapshot itself is read-only. Add commit=true to the URL to commit
+the written files once the conversion finishes, and message=... to set the
+commit message. Reading always reflects the selected commit, not the working
+tree.
+
+The git command must be installed.`,
+		Open: OpenDatabase,
+	})
+}
+
+// Database is a snapshot of a commit in a git repository.
+type Database struct {
+	*mem.Database
+	repo    string
+	commit  bool
+	message string
+	// notHead is set when the selected commit is not HEAD, which prevents
+	// writing.
+	notHead bool
+
+	mu       sync.Mutex
+	worktree *file.Database
+	written  map[string]bool
+}
+
+var _ fs.MkdirAllFS = (*Database)(nil)
+var _ fs.OpenFileFS = (*Database)(nil)
+var _ fs.ChtimesFS = (*Database)(nil)
+var _ io.Closer = (*Database)(nil)
+
+// OpenDatabase is the entrypoint for the git format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	if transport := strings.TrimPrefix(dbURL.Scheme, "git"); transport != "" && transport != "+file" {
+		return nil, fmt.Errorf("unsupported git transport %s", transport[1:])
+	}
+	query := dbURL.Query()
+	db := &Database{
+		Database: mem.New(),
+		repo:     dbURL.Path,
+		commit:   query.Get("commit") == "true" || query.Get("message") != "",
+		message:  query.Get("message"),
+		written:  map[string]bool{},
+	}
+	if db.message == "" {
+		db.message = defaultMessage
+	}
+
+	if _, err := os.Stat(dbURL.Path); os.IsNotExist(err) {
+		// The repository will be created when it is written to.
+		db.SetReadOnly()
+		return db, nil
+	}
+	head, err := db.git(nil, "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	rev := query.Get("rev")
+	if rev == "" {
+		if err != nil {
+			// The repository has no commits yet.
+			db.SetReadOnly()
+			return db, nil
+		}
+		rev = "HEAD"
+	}
+	commit, err := db.git(nil, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	db.notHead = !bytes.Equal(commit, head)
+	if err := db.load(strings.TrimSpace(string(commit))); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// git runs a git command in the repository and returns its output.
+func (db *Database) git(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", db.repo}, args...)...)
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), "GIT_LITERAL_PATHSPECS=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+type treeEntry struct {
+	path string
+	hash string
+}
+
+// load reads all of the files in the commit into the snapshot.
+func (db *Database) load(commit string) error {
+	out, err := db.git(nil, "ls-tree", "-r", "-t", "-z", commit)
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	var blobs []treeEntry
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+		// Each line is "<mode> <type> <hash>\t<path>".
+		tab := strings.IndexByte(line, '\t')
+		var fields []string
+		if tab != -1 {
+			fields = strings.Fields(line[:tab])
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected output from git ls-tree: %#v", line)
+		}
+		switch {
+		case fields[1] == "tree":
+			dirs = append(dirs, line[tab+1:])
+		case fields[1] == "blob" && fields[0] != "120000":
+			blobs = append(blobs, treeEntry{line[tab+1:], fields[2]})
+		}
+	}
+
+	modTimes, err := db.modTimes(commit)
+	if err != nil {
+		return err
+	}
+	commitTime := modTimes[""]
+	if err := db.readBlobs(blobs, func(p string, data []byte) error {
+		modTime, ok := modTimes[p]
+		if !ok {
+			modTime = commitTime
+		}
+		if err := db.WriteFile(p, data, modTime); err != nil {
+			return err
+		}
+		if strings.HasSuffix(p, ".md") {
+			// Invalid front matter is left in the note for the reader to
+			// see, rather than failing to load the commit.
+			if metadata, err := file.ReadFrontMatter(data); err == nil && !metadata.IsZero() {
+				return db.SetMetadata(p, metadata)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := db.Database.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		if err := db.Database.Chtimes(dir, commitTime, commitTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modTimes finds the time of the last commit which changed each file. The
+// time of the commit itself is stored under the empty path.
+func (db *Database) modTimes(commit string) (map[string]time.Time, error) {
+	out, err := db.git(nil, "log", "--format=format:%x01%ct", "--name-only", "-z", "--no-renames", commit)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]time.Time{}
+	for _, record := range strings.Split(string(out), "\x01") {
+		if record == "" {
+			continue
+		}
+		newline := strings.IndexByte(record, '\n')
+		if newline == -1 {
+			newline = len(record)
+		}
+		seconds, err := strconv.ParseInt(strings.TrimRight(record[:newline], "\x00"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output from git log: %w", err)
+		}
+		modTime := time.Unix(seconds, 0).UTC()
+		if _, ok := ret[""]; !ok {
+			ret[""] = modTime
+		}
+		if newline == len(record) {
+			continue
+		}
+		for _, name := range strings.Split(record[newline+1:], "\x00") {
+			if _, ok := ret[name]; name != "" && !ok {
+				ret[name] = modTime
+			}
+		}
+	}
+	return ret, nil
+}
+
+// readBlobs reads the contents of the blobs from the object store, in a
+// single git process.
+func (db *Database) readBlobs(blobs []treeEntry, fn func(p string, data []byte) error) error {
+	var input bytes.Buffer
+	for _, blob := range blobs {
+		fmt.Fprintf(&input, "%s\n", blob.hash)
+	}
+	out, err := db.git(&input, "cat-file", "--batch")
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, blob := range blobs {
+		// Each object is "<hash> <type> <size>\n<contents>\n".
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("unexpected output from git cat-file: %w", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 || fields[0] != blob.hash {
+			return fmt.Errorf("unexpected output from git cat-file: %#v", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("unexpected output from git cat-file: %w", err)
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("unexpected output from git cat-file: %w", err)
+		}
+		if err := fn(blob.path, data[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getWorktree returns the database for the working tree, creating the
+// repository if necessary.
+func (db *Database) getWorktree() (*file.Database, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.worktree != nil {
+		return db.worktree, nil
+	}
+	if _, err := os.Stat(db.repo); os.IsNotExist(err) {
+		if err := os.MkdirAll(db.repo, 0777); err != nil {
+			return nil, err
+		}
+		if _, err := db.git(nil, "init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+	out, err := db.git(nil, "rev-parse", "--is-bare-repository")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(out)) == "true" {
+		return nil, fmt.Errorf("cannot write to a bare repository")
+	}
+	worktree, err := file.OpenDatabase(&url.URL{Scheme: "file", Path: db.repo})
+	if err != nil {
+		return nil, err
+	}
+	db.worktree = worktree.(*file.Database)
+	return db.worktree, nil
+}
+
+func (db *Database) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return db.Database.OpenFile(name, flag, perm)
+	}
+	if name == ".git" || strings.HasPrefix(name, ".git/") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	if db.notHead {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errNotHead}
+	}
+	worktree, err := db.getWorktree()
+	if err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	db.written[name] = true
+	db.mu.Unlock()
+	return worktree.OpenFile(name, flag, perm)
+}
+
+func (db *Database) MkdirAll(name string, perm fs.FileMode) error {
+	if db.notHead {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errNotHead}
+	}
+	worktree, err := db.getWorktree()
+	if err != nil {
+		return err
+	}
+	return worktree.MkdirAll(name, perm)
+}
+
+func (db *Database) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	db.mu.Lock()
+	written := db.written[name]
+	db.mu.Unlock()
+	if !written {
+		return db.Database.Chtimes(name, atime, mtime)
+	}
+	return db.worktree.Chtimes(name, atime, mtime)
+}
+
+// Close commits the files which were written, if requested.
+func (db *Database) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.commit || len(db.written) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(db.written))
+	for name := range db.written {
+		paths = append(paths, path.Clean(name))
+	}
+	sort.Strings(paths)
+	if _, err := db.git(nil, append([]string{"add", "--"}, paths...)...); err != nil {
+		return err
+	}
+	if _, err := db.git(nil, append([]string{"diff", "--cached", "--quiet", "--"}, paths...)...); err == nil {
+		// Nothing changed, so there is nothing to commit.
+		return nil
+	}
+	_, err := db.git(nil, append([]string{"commit", "--quiet", "--message", db.message, "--"}, paths...)...)
+	return err
+}
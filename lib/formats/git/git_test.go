@@ -0,0 +1,103 @@
+package git
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, repo string, date string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func TestRead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	first := "2021-01-01T00:00:00Z"
+	second := "2021-02-01T00:00:00Z"
+	runGit(t, repo, first, "init", "--quiet")
+	require.NoError(t, os.Mkdir(filepath.Join(repo, "Notebook"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "Notebook", "First.md"), []byte("first\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "Second.md"), []byte("old\n"), 0666))
+	runGit(t, repo, first, "add", "-A")
+	runGit(t, repo, first, "commit", "--quiet", "-m", "first")
+	runGit(t, repo, first, "tag", "v1")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "Second.md"), []byte("new\n"), 0666))
+	runGit(t, repo, second, "commit", "--quiet", "-am", "second")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "Second.md"), []byte("uncommitted\n"), 0666))
+
+	db, err := OpenDatabase(&url.URL{Scheme: "git+file", Path: repo})
+	require.NoError(t, err)
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Notebook", "Notebook/First.md", "Second.md"}, paths)
+
+	data, err := fs.ReadFile(db, "Second.md")
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(data))
+	info, err := fs.Stat(db, "Second.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), info.ModTime())
+	info, err = fs.Stat(db, "Notebook/First.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), info.ModTime())
+	err = fs.Chtimes(db, "Second.md", time.Time{}, time.Time{})
+	require.ErrorIs(t, err, fs.ErrPermission)
+
+	db, err = OpenDatabase(&url.URL{Scheme: "git+file", Path: repo, RawQuery: "rev=v1"})
+	require.NoError(t, err)
+	data, err = fs.ReadFile(db, "Second.md")
+	require.NoError(t, err)
+	require.Equal(t, "old\n", string(data))
+	_, err = fs.OpenFile(db, "Third.md", os.O_RDWR|os.O_CREATE, 0666)
+	require.ErrorIs(t, err, fs.ErrPermission)
+	require.ErrorIs(t, fs.MkdirAll(db, "Other", 0777), fs.ErrPermission)
+	require.NoFileExists(t, filepath.Join(repo, "Third.md"))
+}
+
+func TestWriteCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	runGit(t, repo, "", "init", "--quiet")
+	runGit(t, repo, "", "config", "user.name", "Test")
+	runGit(t, repo, "", "config", "user.email", "test@example.com")
+
+	db, err := OpenDatabase(&url.URL{Scheme: "git+file", Path: repo, RawQuery: "message=Import+notes"})
+	require.NoError(t, err)
+	require.NoError(t, fs.MkdirAll(db, "Notebook", 0777))
+	notedbtest.WriteFile(t, db, "Notebook/Note.md", "# Note\n")
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	log := runGit(t, repo, "", "log", "--format=%s", "--name-only")
+	require.Equal(t, "Import notes\n\nNotebook/Note.md\n", log)
+
+	db, err = OpenDatabase(&url.URL{Scheme: "git+file", Path: repo})
+	require.NoError(t, err)
+	data, err := fs.ReadFile(db, "Notebook/Note.md")
+	require.NoError(t, err)
+	require.Equal(t, "# Note\n", string(data))
+}
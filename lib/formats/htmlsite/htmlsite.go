@@ -0,0 +1,297 @@
+package htmlsite
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	mathjax "github.com/litao91/goldmark-mathjax"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"go.uber.org/multierr"
+)
+
+// indexPage is the name of the page listing the contents of each directory.
+const indexPage = "index.html"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "html-site",
+		Description: "Static HTML site",
+		Documentation: `This format writes a static website which can be browsed without any
+server-side software. It can only be used as the destination of a
+conversion, and the path must not exist or must be an empty directory.
+
+Each note becomes an HTML page with the same name, and links between notes
+are updated to point at the pages. Math is rendered by loading MathJax.
+Attachments are copied as they are, and each directory gets an index.html
+page which lists its contents; a note named "index" or an attachment named
+"index.html" is renamed to make room for it. The pages keep the modification
+times of the notes, and each index page has the modification time of the
+newest file in its directory.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// markdown renders notes with the same extensions used by parser.Parse. Raw
+// HTML in the notes is kept, since the notes are trusted.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(mathjax.MathJax, extension.Table),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 50em; margin: 0 auto; padding: 1em; font-family: sans-serif; line-height: 1.5; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; }
+pre { overflow-x: auto; }
+img { max-width: 100%; }
+</style>
+{{- if .Math}}
+<script src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml.js" async></script>
+{{- end}}
+</head>
+<body>
+{{- if .Up}}
+<nav><a href="{{.Up}}">Index</a></nav>
+{{- end}}
+{{if .Content}}{{.Content}}{{else}}<h1>{{.Title}}</h1>
+<ul>
+{{- range .Index}}
+<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+{{end -}}
+</body>
+</html>
+`))
+
+// page holds the values used by pageTemplate.
+type page struct {
+	Title string
+	// Up is the link to the index page of the directory, if there is one.
+	Up      string
+	Math    bool
+	Content template.HTML
+	Index   []indexEntry
+}
+
+type indexEntry struct {
+	Name string
+	Href string
+}
+
+// Writer is a database which holds the written notes in memory and produces
+// the site when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+// OpenDatabase is the entrypoint for the HTML site format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if len(entries) > 0 {
+		return nil, fmt.Errorf("%s: destination is not empty", dbURL.Path)
+	}
+	return &Writer{mem.New(), dbURL.Path}, nil
+}
+
+// Detect always returns negative, since HTML sites cannot be read.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	return notedb.DetectResultNegative
+}
+
+// directory is a directory of the site, which gets an index page.
+type directory struct {
+	entries []indexEntry
+	modTime time.Time
+}
+
+// Close writes the site.
+func (w *Writer) Close() error {
+	// targets maps the paths in the database to the paths in the site.
+	targets := map[string]string{}
+	dirs := map[string]*directory{}
+	used := map[string]map[string]bool{}
+	var notes, attachments []string
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs[p] = &directory{}
+			used[p] = map[string]bool{indexPage: true}
+			return nil
+		}
+		if path.Ext(p) == ".md" {
+			notes = append(notes, p)
+		} else {
+			attachments = append(attachments, p)
+			targets[p] = path.Join(path.Dir(p), notedb.UniqueName(path.Base(p), used[path.Dir(p)]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range notes {
+		dir := path.Dir(p)
+		name := notedb.UniqueName(strings.TrimSuffix(path.Base(p), ".md")+".html", used[dir])
+		targets[p] = path.Join(dir, name)
+	}
+
+	var errs error
+	for _, p := range notes {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		title := strings.TrimSuffix(path.Base(p), ".md")
+		if metadata, err := w.Metadata(p); err == nil && metadata.Title != "" {
+			title = metadata.Title
+		}
+		html, convertErr := renderNote(p, title, data, targets)
+		for _, err := range multierr.Errors(convertErr) {
+			errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: err})
+		}
+		errs = multierr.Append(errs, mem.WriteHostFile(w.path, targets[p], html, w.modTime(p, dirs)))
+		entry := indexEntry{title, path.Base(targets[p])}
+		dirs[path.Dir(p)].entries = append(dirs[path.Dir(p)].entries, entry)
+	}
+	for _, p := range attachments {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		errs = multierr.Append(errs, mem.WriteHostFile(w.path, targets[p], data, w.modTime(p, dirs)))
+	}
+	for p := range dirs {
+		if p != "." {
+			entry := indexEntry{path.Base(p) + "/", (&url.URL{Path: path.Base(p) + "/" + indexPage}).String()}
+			dirs[path.Dir(p)].entries = append(dirs[path.Dir(p)].entries, entry)
+		}
+	}
+	for p, dir := range dirs {
+		errs = multierr.Append(errs, w.writeIndex(p, dir))
+	}
+	return errs
+}
+
+// modTime returns the modification time of the file, and updates the
+// modification times of the directories containing it.
+func (w *Writer) modTime(p string, dirs map[string]*directory) time.Time {
+	modTime := w.ModTime(p)
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if d := dirs[dir]; d != nil && modTime.After(d.modTime) {
+			d.modTime = modTime
+		}
+		if dir == "." {
+			break
+		}
+	}
+	return modTime
+}
+
+func (w *Writer) writeIndex(p string, dir *directory) error {
+	title := path.Base(p)
+	up := ""
+	if p == "." {
+		title = "Index"
+	} else {
+		up = "../" + indexPage
+	}
+	sort.Slice(dir.entries, func(i, j int) bool {
+		return dir.entries[i].Name < dir.entries[j].Name
+	})
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, page{Title: title, Up: up, Index: dir.entries}); err != nil {
+		return err
+	}
+	modTime := dir.modTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return mem.WriteHostFile(w.path, path.Join(p, indexPage), buf.Bytes(), modTime)
+}
+
+// renderNote renders the note as a page of the site with the given title.
+// Links to other notes are updated to point at their pages.
+func renderNote(notePath, title string, data []byte, targets map[string]string) ([]byte, error) {
+	doc, err := parser.Parse(data, parser.HeadingIDs)
+	if err != nil {
+		return nil, err
+	}
+	math := false
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var linkErr error
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination, linkErr = rewriteLink(notePath, n.Destination, targets)
+		case *ast.Image:
+			n.Destination, linkErr = rewriteLink(notePath, n.Destination, targets)
+		case *mathjax.InlineMath, *mathjax.MathBlock:
+			math = true
+		}
+		err = multierr.Append(err, linkErr)
+		return ast.WalkContinue, nil
+	})
+	if walkErr != nil {
+		return nil, multierr.Append(err, walkErr)
+	}
+	var content bytes.Buffer
+	if renderErr := markdown.Renderer().Render(&content, data, doc); renderErr != nil {
+		return nil, multierr.Append(err, renderErr)
+	}
+	var buf bytes.Buffer
+	p := page{
+		Title:   title,
+		Up:      indexPage,
+		Math:    math,
+		Content: template.HTML(content.String()),
+	}
+	err = multierr.Append(err, pageTemplate.Execute(&buf, p))
+	return buf.Bytes(), err
+}
+
+// rewriteLink converts a link to a note into a link to its page.
+func rewriteLink(notePath string, dest []byte, targets map[string]string) ([]byte, error) {
+	source, fragment, ok := notedb.LocalLink(notePath, dest)
+	if !ok {
+		return dest, nil
+	}
+	target, ok := targets[source]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return notedb.RelativeLink(notePath, target, fragment), nil
+}
@@ -0,0 +1,66 @@
+package htmlsite
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, root, path string) string {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriter(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "site")
+	db, err := OpenDatabase(&url.URL{Scheme: "html-site", Path: root})
+	require.NoError(t, err)
+	older := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Projects", 0777))
+	notedbtest.WriteFileAt(t, db, "index.md", "Start at [alpha](Projects/Alpha.md#goals).\n", older)
+	notedbtest.WriteFileAt(t, db, "Projects/Alpha.md", "## Goals\n\n| A | B |\n| - | - |\n| 1 | 2 |\n\n"+
+		"Energy is $E=mc^2$. ![pic](../pic.png) [home](../index.md) [page](index.html) [missing](Beta.md)\n", newer)
+	notedbtest.WriteFileAt(t, db, "pic.png", "png", older)
+	notedbtest.WriteFileAt(t, db, "Projects/index.html", "<p>saved</p>", older)
+	require.EqualError(t, notedb.CloseDatabase(db), "convert Projects/Alpha.md: dead link: Beta.md")
+
+	alpha := readFile(t, root, "Projects/Alpha.html")
+	require.Contains(t, alpha, "<title>Alpha</title>")
+	require.Contains(t, alpha, "mathjax")
+	require.Contains(t, alpha, `<nav><a href="index.html">Index</a></nav>`)
+	require.Contains(t, alpha, `<h2 id="goals">Goals</h2>`)
+	require.Contains(t, alpha, "<table>")
+	require.Contains(t, alpha, `<span class="math inline">\(E=mc^2\)</span>`)
+	require.Contains(t, alpha, `<img src="../pic.png" alt="pic">`)
+	require.Contains(t, alpha, `<a href="../index%202.html">home</a>`)
+	require.Contains(t, alpha, `<a href="index%202.html">page</a>`)
+	require.Equal(t, "<p>saved</p>", readFile(t, root, "Projects/index 2.html"))
+	require.Contains(t, readFile(t, root, "index 2.html"), `<a href="Projects/Alpha.html#goals">alpha</a>`)
+	require.NotContains(t, readFile(t, root, "index 2.html"), "mathjax")
+	require.Equal(t, "png", readFile(t, root, "pic.png"))
+
+	index := readFile(t, root, "index.html")
+	require.Contains(t, index, "<ul>\n"+
+		`<li><a href="Projects/index.html">Projects/</a></li>`+"\n"+
+		`<li><a href="index%202.html">index</a></li>`+"\n</ul>")
+	require.Contains(t, readFile(t, root, "Projects/index.html"), `<li><a href="Alpha.html">Alpha</a></li>`)
+
+	info, err := os.Stat(filepath.Join(root, "Projects", "Alpha.html"))
+	require.NoError(t, err)
+	require.True(t, newer.Equal(info.ModTime()))
+	info, err = os.Stat(filepath.Join(root, "index.html"))
+	require.NoError(t, err)
+	require.True(t, newer.Equal(info.ModTime()))
+
+	_, err = OpenDatabase(&url.URL{Scheme: "html-site", Path: root})
+	require.Error(t, err)
+}
@@ -0,0 +1,402 @@
+package jex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+// defaultAPIAddress is where the Joplin desktop app runs its web clipper
+// service.
+const defaultAPIAddress = "http://localhost:41184"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "joplin-api",
+		Description: "Joplin Data API",
+		Documentation: `This format reads and writes the notes of a running Joplin app through its
+Data API. Enable the web clipper service in the Joplin settings, and copy the
+authorization token into a URL like
+joplin-api+http://localhost:41184?token=... The address defaults to the one
+used by the desktop app, so joplin-api:?token=... is also accepted.
+
+Reading loads all notebooks, notes, and tags when the database is first read,
+and downloads each resource when it is opened. Written notes are created in
+Joplin once the conversion finishes: directories containing notes become
+notebooks, and all other files become resources. The tags and other metadata
+of the notes are kept. Notes which are not in a directory are placed in the
+default notebook chosen by Joplin. Existing notes are never modified, so
+converting into a profile which already has notes adds a second copy of any
+notes with the same title.`,
+		Open: OpenAPIDatabase,
+	})
+}
+
+// apiClient makes requests to the Joplin Data API.
+type apiClient struct {
+	base   *url.URL
+	token  string
+	client *http.Client
+}
+
+// apiObject is an item returned by the API. Only the fields requested by the
+// client are filled in.
+type apiObject struct {
+	ID              string      `json:"id"`
+	ParentID        string      `json:"parent_id"`
+	Title           string      `json:"title"`
+	Body            string      `json:"body"`
+	UserCreatedTime int64       `json:"user_created_time"`
+	UserUpdatedTime int64       `json:"user_updated_time"`
+	IsConflict      int         `json:"is_conflict"`
+	Author          string      `json:"author"`
+	SourceURL       string      `json:"source_url"`
+	Latitude        json.Number `json:"latitude"`
+	Longitude       json.Number `json:"longitude"`
+	Altitude        json.Number `json:"altitude"`
+	Size            int         `json:"size"`
+}
+
+type apiPage struct {
+	Items   []apiObject `json:"items"`
+	HasMore bool        `json:"has_more"`
+}
+
+// do performs a request to the API and returns the body of the response.
+func (c *apiClient) do(method, path string, query url.Values, contentType string, body io.Reader) ([]byte, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("token", c.token)
+	u := c.base.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Errors from the client include the URL, which contains the token.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return data, nil
+}
+
+// request performs a request to the API and decodes the JSON response into
+// result, if it is not nil.
+func (c *apiClient) request(method, path string, query url.Values, contentType string, body io.Reader, result interface{}) error {
+	data, err := c.do(method, path, query, contentType, body)
+	if err != nil || result == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// list returns all of the items at the path, requesting one page at a time.
+func (c *apiClient) list(path string, fields string) ([]apiObject, error) {
+	var items []apiObject
+	for page := 1; ; page++ {
+		query := url.Values{
+			"fields": {fields},
+			"page":   {strconv.Itoa(page)},
+			"limit":  {"100"},
+		}
+		var result apiPage
+		if err := c.request("GET", path, query, "", nil, &result); err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+		if !result.HasMore {
+			return items, nil
+		}
+	}
+}
+
+// load reads all of the folders, notes, resources, and tags from the API.
+func (c *apiClient) load() (*JEX, error) {
+	ret := &JEX{}
+	lists := []struct {
+		objectType int
+		path       string
+		fields     string
+	}{
+		{TypeFolder, "folders", "id,parent_id,title,user_updated_time"},
+		{TypeNote, "notes", "id,parent_id,title,body,user_created_time,user_updated_time,is_conflict,author,source_url,latitude,longitude,altitude"},
+		{TypeResource, "resources", "id,title,user_updated_time,size"},
+		{TypeTag, "tags", "id,title,user_updated_time"},
+	}
+	for _, l := range lists {
+		items, err := c.list(l.path, l.fields)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.IsConflict != 0 {
+				continue
+			}
+			object := &jexObject{
+				ID:       item.ID,
+				Type:     l.objectType,
+				Title:    item.Title,
+				ParentID: item.ParentID,
+				ModTime:  time.Unix(0, item.UserUpdatedTime*int64(time.Millisecond)),
+			}
+			switch l.objectType {
+			case TypeResource:
+				// Resources are large, so they are only downloaded when they
+				// are opened.
+				id := item.ID
+				object.loadData = func() ([]byte, error) { return c.resourceData(id) }
+				object.Size = item.Size
+			case TypeNote:
+				object.Metadata = notedb.Metadata{
+					Created:   time.Unix(0, item.UserCreatedTime*int64(time.Millisecond)),
+					Updated:   object.ModTime,
+					Author:    item.Author,
+					SourceURL: item.SourceURL,
+					Location:  parseLocation(item.Latitude.String(), item.Longitude.String(), item.Altitude.String()),
+				}
+			case TypeTag:
+				// The API has no list of note_tag objects, so they are
+				// reconstructed from the notes of each tag.
+				notes, err := c.list("tags/"+item.ID+"/notes", "id")
+				if err != nil {
+					return nil, err
+				}
+				for _, note := range notes {
+					ret.objects = append(ret.objects, &jexObject{Type: TypeNoteTag, NoteID: note.ID, TagID: item.ID})
+				}
+			}
+			if item.Body != "" {
+				object.Data = []byte(item.Body)
+			}
+			ret.objects = append(ret.objects, object)
+		}
+	}
+	return ret, nil
+}
+
+func (c *apiClient) resourceData(id string) ([]byte, error) {
+	return c.do("GET", "resources/"+id+"/file", nil, "", nil)
+}
+
+// create adds the object to Joplin, keeping its ID so that links to it
+// continue to work.
+func (c *apiClient) create(o *jexObject) error {
+	timestamp := o.ModTime.UnixNano() / int64(time.Millisecond)
+	props := map[string]interface{}{
+		"id":                o.ID,
+		"title":             o.Title,
+		"user_created_time": timestamp,
+		"user_updated_time": timestamp,
+	}
+	switch o.Type {
+	case TypeFolder:
+		props["parent_id"] = o.ParentID
+		return c.post("folders", props)
+	case TypeNote:
+		props["parent_id"] = o.ParentID
+		props["body"] = string(o.Data)
+		if !o.Metadata.Created.IsZero() {
+			props["user_created_time"] = o.Metadata.Created.UnixNano() / int64(time.Millisecond)
+		}
+		props["author"] = o.Metadata.Author
+		props["source_url"] = o.Metadata.SourceURL
+		if location := o.Metadata.Location; location != nil {
+			props["latitude"] = location.Latitude
+			props["longitude"] = location.Longitude
+			props["altitude"] = location.Altitude
+		}
+		return c.post("notes", props)
+	case TypeTag:
+		return c.post("tags", map[string]interface{}{"id": o.ID, "title": o.Title})
+	case TypeNoteTag:
+		return c.post("tags/"+o.TagID+"/notes", map[string]interface{}{"id": o.NoteID})
+	}
+
+	// Resources are uploaded as a multipart form, with the properties in a
+	// separate field from the data.
+	propData, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("props", string(propData)); err != nil {
+		return err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="data"; filename="%s"`, strings.ReplaceAll(o.Title, `"`, "")))
+	if mimeType := mime.TypeByExtension("." + resourceExtension(o)); mimeType != "" {
+		header.Set("Content-Type", mimeType)
+	} else {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(o.Data); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+	return c.request("POST", "resources", nil, form.FormDataContentType(), &body, nil)
+}
+
+func (c *apiClient) post(path string, props map[string]interface{}) error {
+	data, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	return c.request("POST", path, nil, "application/json", bytes.NewReader(data), nil)
+}
+
+// APIDatabase is a note database backed by a running Joplin app. Reads come
+// from a snapshot which is loaded when the database is first read, and writes
+// are held in memory until the database is closed.
+type APIDatabase struct {
+	client *apiClient
+	writer *mem.Database
+
+	mu       sync.Mutex
+	snapshot *JoplinFS
+	written  map[string]bool
+}
+
+var _ fs.MkdirAllFS = (*APIDatabase)(nil)
+var _ fs.OpenFileFS = (*APIDatabase)(nil)
+var _ fs.ChtimesFS = (*APIDatabase)(nil)
+var _ io.Closer = (*APIDatabase)(nil)
+
+// OpenAPIDatabase is the entrypoint for the Joplin Data API format.
+func OpenAPIDatabase(dbURL *url.URL) (notedb.Database, error) {
+	token := dbURL.Query().Get("token")
+	if token == "" {
+		return nil, fmt.Errorf("the token parameter is required")
+	}
+	base, err := url.Parse(defaultAPIAddress)
+	if err != nil {
+		panic(err)
+	}
+	if idx := strings.IndexByte(dbURL.Scheme, '+'); idx != -1 {
+		transport := dbURL.Scheme[idx+1:]
+		if transport != "http" && transport != "https" {
+			return nil, fmt.Errorf("unsupported Joplin API transport %s", transport)
+		}
+		base = &url.URL{Scheme: transport, Host: dbURL.Host, Path: dbURL.Path}
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return &APIDatabase{
+		client:  &apiClient{base, token, http.DefaultClient},
+		writer:  mem.New(),
+		written: map[string]bool{},
+	}, nil
+}
+
+// getSnapshot returns the notes in Joplin, loading them if necessary.
+func (db *APIDatabase) getSnapshot() (*JoplinFS, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.snapshot != nil {
+		return db.snapshot, nil
+	}
+	jex, err := db.client.load()
+	if err != nil {
+		return nil, err
+	}
+	db.snapshot, err = newJoplinFS(jex)
+	return db.snapshot, err
+}
+
+func (db *APIDatabase) Open(name string) (fs.File, error) {
+	snapshot, err := db.getSnapshot()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return snapshot.Open(name)
+}
+
+func (db *APIDatabase) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return db.Open(name)
+	}
+	db.mu.Lock()
+	db.written[name] = true
+	db.mu.Unlock()
+	return db.writer.OpenFile(name, flag, perm)
+}
+
+func (db *APIDatabase) MkdirAll(name string, perm fs.FileMode) error {
+	return db.writer.MkdirAll(name, perm)
+}
+
+func (db *APIDatabase) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	db.mu.Lock()
+	written := db.written[name]
+	db.mu.Unlock()
+	if !written {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+	}
+	return db.writer.Chtimes(name, atime, mtime)
+}
+
+// Close creates the notes which were written in Joplin. Any links which
+// cannot be resolved are reported as errors, but do not prevent the notes from
+// being created, and objects which cannot be created are reported without
+// stopping the others.
+func (db *APIDatabase) Close() error {
+	db.mu.Lock()
+	written := len(db.written) > 0
+	db.mu.Unlock()
+	if !written {
+		return nil
+	}
+	objects, err := buildObjects(db.writer)
+	for _, object := range objects {
+		err = multierr.Append(err, db.client.create(object))
+	}
+	return err
+}
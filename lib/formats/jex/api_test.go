@@ -0,0 +1,237 @@
+package jex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+// stubAPI implements the parts of the Joplin Data API used by the format,
+// storing the objects in memory.
+type stubAPI struct {
+	mu      sync.Mutex
+	objects map[string][]map[string]interface{}
+	files   map[string][]byte
+}
+
+func newStubAPI(t *testing.T) (*stubAPI, *url.URL) {
+	api := &stubAPI{objects: map[string][]map[string]interface{}{}, files: map[string][]byte{}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.Scheme = "joplin-api+" + u.Scheme
+	u.RawQuery = "token=secret"
+	return api, u
+}
+
+func (api *stubAPI) add(kind string, props map[string]interface{}) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.objects[kind] = append(api.objects[kind], props)
+}
+
+func (api *stubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	if r.URL.Query().Get("token") != "secret" {
+		reply(http.StatusForbidden, map[string]string{"error": "Invalid \"token\" parameter"})
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 1:
+		// Return two items per page, to exercise pagination.
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		items := api.objects[parts[0]]
+		start, end := (page-1)*2, page*2
+		if end > len(items) {
+			end = len(items)
+		}
+		fields := strings.Split(r.URL.Query().Get("fields"), ",")
+		var result []map[string]interface{}
+		for _, item := range items[start:end] {
+			filtered := map[string]interface{}{}
+			for _, field := range fields {
+				if value, ok := item[field]; ok {
+					filtered[field] = value
+				}
+			}
+			result = append(result, filtered)
+		}
+		reply(http.StatusOK, map[string]interface{}{"items": result, "has_more": end < len(items)})
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "tags" && parts[2] == "notes":
+		var result []map[string]interface{}
+		for _, noteTag := range api.objects["note_tags"] {
+			if noteTag["tag_id"] == parts[1] {
+				result = append(result, map[string]interface{}{"id": noteTag["note_id"]})
+			}
+		}
+		reply(http.StatusOK, map[string]interface{}{"items": result, "has_more": false})
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "resources" && parts[2] == "file":
+		data, ok := api.files[parts[1]]
+		if !ok {
+			reply(http.StatusNotFound, map[string]string{"error": "Not found"})
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "tags" && parts[2] == "notes":
+		var props map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		noteTag := map[string]interface{}{"note_id": props["id"], "tag_id": parts[1]}
+		api.objects["note_tags"] = append(api.objects["note_tags"], noteTag)
+		reply(http.StatusOK, noteTag)
+	case r.Method == "POST" && parts[0] == "resources":
+		var props map[string]interface{}
+		file, _, err := r.FormFile("data")
+		if err == nil {
+			err = json.Unmarshal([]byte(r.FormValue("props")), &props)
+		}
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		data, _ := ioutil.ReadAll(file)
+		api.files[props["id"].(string)] = data
+		api.objects["resources"] = append(api.objects["resources"], props)
+		reply(http.StatusOK, props)
+	case r.Method == "POST":
+		var props map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		} else if props["title"] == "Rejected" {
+			reply(http.StatusBadRequest, map[string]string{"error": "Rejected by the test"})
+			return
+		}
+		api.objects[parts[0]] = append(api.objects[parts[0]], props)
+		reply(http.StatusOK, props)
+	default:
+		reply(http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+func listPaths(t *testing.T, db notedb.Database) []string {
+	var paths []string
+	err := fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestAPIRead(t *testing.T) {
+	api, dbURL := newStubAPI(t)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	updated := float64(modTime.UnixNano() / int64(time.Millisecond))
+	api.add("folders", map[string]interface{}{"id": "f1", "parent_id": "", "title": "Folder", "user_updated_time": updated})
+	api.add("notes", map[string]interface{}{"id": "n1", "parent_id": "f1", "title": "First", "is_conflict": 0,
+		"body": "See [second](:/n2#top) and ![pic](:/r1).", "user_updated_time": updated,
+		"author": "Alice", "latitude": "35.68950000", "longitude": "139.69170000", "altitude": "0.0000"})
+	api.add("notes", map[string]interface{}{"id": "n2", "parent_id": "", "title": "Second", "is_conflict": 0,
+		"body": "Back to [first](joplin://x-callback-url/openNote?id=n1).", "user_updated_time": updated})
+	api.add("notes", map[string]interface{}{"id": "n3", "parent_id": "", "title": "Second", "is_conflict": 1,
+		"body": "Conflicting copy", "user_updated_time": updated})
+	api.add("resources", map[string]interface{}{"id": "r1", "title": "pic.png", "user_updated_time": updated, "size": 3})
+	api.files["r1"] = []byte("PNG")
+	api.add("resources", map[string]interface{}{"id": "r2", "title": "gone.png", "user_updated_time": updated, "size": 4})
+	api.add("tags", map[string]interface{}{"id": "t1", "title": "travel", "user_updated_time": updated})
+	api.add("note_tags", map[string]interface{}{"note_id": "n1", "tag_id": "t1"})
+
+	db, err := notedb.OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Folder", "Folder/First.md", "Second.md", "_resources", "_resources/gone.png", "_resources/pic.png"}, listPaths(t, db))
+	require.Equal(t, "See [second](../Second.md#top) and ![pic](../_resources/pic.png).\n", notedbtest.RenderNote(t, db, "Folder/First.md"))
+	require.Equal(t, "Back to [first](Folder/First.md).\n", notedbtest.RenderNote(t, db, "Second.md"))
+	data, err := fs.ReadFile(db, "_resources/pic.png")
+	require.NoError(t, err)
+	require.Equal(t, "PNG", string(data))
+	// Resources are only downloaded when they are opened.
+	entries, err := fs.ReadDir(db, "_resources")
+	require.NoError(t, err)
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), info.Size())
+	_, err = db.Open("_resources/gone.png")
+	require.EqualError(t, err, "open _resources/gone.png: GET resources/r2/file: Not found")
+	info, err = fs.Stat(db, "Folder/First.md")
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(info.ModTime()))
+	f, err := db.Open("Folder/First.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, "First", metadata.Title)
+	require.Equal(t, []string{"travel"}, metadata.Tags)
+	require.Equal(t, "Alice", metadata.Author)
+	require.Equal(t, &notedb.Location{Latitude: 35.6895, Longitude: 139.6917}, metadata.Location)
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	dbURL.RawQuery = "token=wrong"
+	db, err = notedb.OpenDatabase(dbURL)
+	require.NoError(t, err)
+	_, err = db.Open(".")
+	require.EqualError(t, err, `open .: GET folders: Invalid "token" parameter`)
+}
+
+func TestAPIWrite(t *testing.T) {
+	api, dbURL := newStubAPI(t)
+	db, err := notedb.OpenDatabase(dbURL)
+	require.NoError(t, err)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Notebook/images", 0777))
+	notedbtest.WriteFile(t, db, "Notebook/First.md", "# First\n\nSee [second](../Second.md#top) and ![img](images/a.png).\n")
+	notedbtest.WriteFile(t, db, "Notebook/images/a.png", "PNG")
+	f, err := fs.Create(db, "Second.md")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("Back to [first](Notebook/First.md).\n"))
+	require.NoError(t, err)
+	require.NoError(t, notedb.WriteMetadata(f.(notedb.Note), notedb.Metadata{Tags: []string{"todo"}}))
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Chtimes(db, "Second.md", modTime, modTime))
+	notedbtest.WriteFile(t, db, "Rejected.md", "Not created\n")
+	// The notes after one which cannot be created are still created.
+	require.EqualError(t, notedb.CloseDatabase(db), "POST notes: Rejected by the test")
+
+	require.Len(t, api.objects["folders"], 1)
+	require.Equal(t, "Notebook", api.objects["folders"][0]["title"])
+	require.Len(t, api.objects["notes"], 2)
+	second := api.objects["notes"][1]
+	require.Equal(t, "Second", second["title"])
+	require.Equal(t, float64(modTime.UnixNano()/int64(time.Millisecond)), second["user_updated_time"])
+	require.Equal(t, "Back to [first](:/"+api.objects["notes"][0]["id"].(string)+").", second["body"])
+	require.Len(t, api.objects["resources"], 1)
+	require.Equal(t, "a.png", api.objects["resources"][0]["title"])
+	require.Len(t, api.objects["tags"], 1)
+	require.Equal(t, "todo", api.objects["tags"][0]["title"])
+	require.Equal(t, []map[string]interface{}{{"note_id": second["id"], "tag_id": api.objects["tags"][0]["id"]}}, api.objects["note_tags"])
+
+	// Read the notes back.
+	db, err = notedb.OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Notebook", "Notebook/First.md", "Second.md", "_resources", "_resources/a.png"}, listPaths(t, db))
+	require.Equal(t, "# First\n\nSee [second](../Second.md#top) and ![img](../_resources/a.png).\n", notedbtest.RenderNote(t, db, "Notebook/First.md"))
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,26 +18,8 @@ import (
 
 var resourcesFolder = "_resources"
 
-var escapePathReplacer = strings.NewReplacer(
-	"<", "(",
-	">", ")",
-	":", "",
-	"\"", "",
-	"/", "",
-	"\\", "",
-	"|", "",
-	"?", "",
-	"*", "",
-	"\n", " ",
-	"\t", " ",
-)
-
-func escapePath(in string) string {
-	return strings.Join(strings.Fields(escapePathReplacer.Replace(in)), " ")
-}
-
 func genName(obj *jexObject, long bool) string {
-	title := escapePath(obj.Title)
+	title := notedb.EscapeName(obj.Title)
 	if long {
 		title = title + "-" + obj.ID
 	}
@@ -80,6 +63,7 @@ func newJoplinFS(jex *JEX) (*JoplinFS, error) {
 		&jfsEntry{nil, "", []*jfsEntry{}},
 		map[string]string{},
 	}
+	applyTags(jex.objects)
 	itemsByParent := map[string][]*jexObject{}
 	for _, child := range jex.objects {
 		parentItems, _ := itemsByParent[child.ParentID]
@@ -162,6 +146,34 @@ func newJoplinFS(jex *JEX) (*JoplinFS, error) {
 	return ret, nil
 }
 
+// applyTags adds the titles of the tags attached to each note to its metadata.
+// Joplin does not order the tags of a note, so they are sorted by title.
+func applyTags(objects []*jexObject) {
+	notes := map[string]*jexObject{}
+	tags := map[string]string{}
+	for _, object := range objects {
+		switch object.Type {
+		case TypeNote:
+			notes[object.ID] = object
+		case TypeTag:
+			tags[object.ID] = object.Title
+		}
+	}
+	for _, object := range objects {
+		if object.Type != TypeNoteTag {
+			continue
+		}
+		note, ok := notes[object.NoteID]
+		tag, tagOk := tags[object.TagID]
+		if ok && tagOk {
+			note.Metadata.Tags = append(note.Metadata.Tags, tag)
+		}
+	}
+	for _, note := range notes {
+		sort.Strings(note.Metadata.Tags)
+	}
+}
+
 // Open satisfies notedb.Database.
 func (j *JoplinFS) Open(path string) (fs.File, error) {
 	if !fs.ValidPath(path) {
@@ -175,6 +187,11 @@ func (j *JoplinFS) Open(path string) (fs.File, error) {
 	if err != nil {
 		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
 	}
+	if entry.object != nil {
+		if err := entry.object.load(); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+		}
+	}
 	return &jfsHandle{entry, j, 0}, nil
 }
 
@@ -265,7 +282,7 @@ func (j *jfsHandle) ReadDir(n int) ([]fs.DirEntry, error) {
 		size := 0
 		var modTime time.Time
 		if item.object != nil {
-			size = len(item.object.Data)
+			size = item.object.size()
 			modTime = item.object.ModTime
 		}
 		ret[i] = &jfsNoteInfo{
@@ -294,7 +311,11 @@ func (j *jfsHandle) ParseAST() (ast.Node, error) {
 	base, _ = filepath.Split(base)
 	doc, err := parser.Parse(j.object.Data)
 
-	replaceLink := func(id string, orig []byte) []byte {
+	replaceLink := func(target string, orig []byte) []byte {
+		id, fragment := target, ""
+		if idx := strings.IndexByte(target, '#'); idx != -1 {
+			id, fragment = target[:idx], target[idx:]
+		}
 		found, ok := j.fs.pathLookup[id]
 		if !ok {
 			err = multierr.Append(err, fmt.Errorf("dead link: %s", orig))
@@ -306,7 +327,7 @@ func (j *jfsHandle) ParseAST() (ast.Node, error) {
 			// Should never happen, since all paths are absolute
 			panic(err)
 		}
-		return []byte(relative)
+		return []byte(relative + fragment)
 	}
 
 	linkResolver := func(n ast.Node, entering bool) (ast.WalkStatus, error) {
@@ -336,6 +357,15 @@ func (j *jfsHandle) ParseAST() (ast.Node, error) {
 	return doc, err
 }
 
+func (j *jfsHandle) Metadata() (notedb.Metadata, error) {
+	if j.object == nil || j.object.Type != TypeNote {
+		return notedb.Metadata{}, nil
+	}
+	metadata := j.object.Metadata
+	metadata.Title = j.object.Title
+	return metadata, nil
+}
+
 func (j *jfsHandle) Data() []byte {
 	if j.object == nil {
 		return nil
@@ -2,13 +2,17 @@ package jex
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/CGamesPlay/pilikino/lib/notedb"
@@ -33,18 +37,17 @@ const (
 	TypeCommand
 )
 
+// jexTimeFormat is the format Joplin uses for timestamps in object
+// properties.
+const jexTimeFormat = "2006-01-02T15:04:05.000Z"
+
 // JEX represents an in-memory copy of a JEX file.
 type JEX struct {
 	objects []*jexObject
 }
 
 func newJEX(file io.Reader) (*JEX, error) {
-	// Load the objects and object data into separate structs. To Joplin, an
-	// attached file or inline image is a "resource", and the resource data is
-	// stored separately in the export. The objectData object is the unparsed
-	// Joplin object.
-	objectData := make(map[string][]byte)
-	resourceData := make(map[string][]byte)
+	files := newJEXFiles()
 	archive := tar.NewReader(file)
 	for {
 		header, err := archive.Next()
@@ -61,23 +64,54 @@ func newJEX(file io.Reader) (*JEX, error) {
 		if err != nil {
 			return nil, fmt.Errorf("while reading %s: %w", header.Name, err)
 		}
-		if strings.HasPrefix(header.Name, "resources/") {
-			id := header.Name[len("resources/"):]
-			ext := strings.Index(id, ".")
-			if ext != -1 {
-				id = id[0:ext]
-			}
-			resourceData[id] = data
-		} else if strings.HasSuffix(header.Name, ".md") {
-			objectData[header.Name[0:len(header.Name)-len(".md")]] = data
-		} else {
-			return nil, fmt.Errorf("unsupported file in JEX: %s", header.Name)
+		if err := files.add(header.Name, data); err != nil {
+			return nil, err
 		}
 	}
+	return files.load()
+}
 
-	ret := &JEX{make([]*jexObject, 0, len(objectData))}
+// jexFiles collects the files of a Joplin export before they are parsed. The
+// same layout is used by both JEX archives and RAW export directories.
+type jexFiles struct {
+	// Load the objects and object data into separate structs. To Joplin, an
+	// attached file or inline image is a "resource", and the resource data is
+	// stored separately in the export. The objectData object is the unparsed
+	// Joplin object.
+	objectData   map[string][]byte
+	resourceData map[string][]byte
+}
 
-	for id, data := range objectData {
+func newJEXFiles() *jexFiles {
+	return &jexFiles{
+		make(map[string][]byte),
+		make(map[string][]byte),
+	}
+}
+
+// add records a file from the export. The name is relative to the root of the
+// export, using forward slashes.
+func (f *jexFiles) add(name string, data []byte) error {
+	if strings.HasPrefix(name, "resources/") {
+		id := name[len("resources/"):]
+		ext := strings.Index(id, ".")
+		if ext != -1 {
+			id = id[0:ext]
+		}
+		f.resourceData[id] = data
+	} else if strings.HasSuffix(name, ".md") {
+		f.objectData[name[0:len(name)-len(".md")]] = data
+	} else {
+		return fmt.Errorf("unsupported file in JEX: %s", name)
+	}
+	return nil
+}
+
+// load parses all of the collected objects.
+func (f *jexFiles) load() (*JEX, error) {
+	ret := &JEX{make([]*jexObject, 0, len(f.objectData))}
+
+	for id, data := range f.objectData {
 		object, err := newjexObject(string(data))
 		if err != nil {
 			return nil, fmt.Errorf("while loading %s: %w", id, err)
@@ -86,7 +120,7 @@ func newJEX(file io.Reader) (*JEX, error) {
 			if object.Data != nil {
 				return nil, fmt.Errorf("while loading %s: resource object has data", id)
 			}
-			data, ok := resourceData[id]
+			data, ok := f.resourceData[id]
 			if !ok {
 				return nil, fmt.Errorf("while loading %s: resource data missing", id)
 			}
@@ -105,6 +139,40 @@ type jexObject struct {
 	Data     []byte
 	ParentID string
 	ModTime  time.Time
+	// Metadata holds the properties of notes which are exposed through
+	// notedb.Metadata. The tags are filled in by newJoplinFS.
+	Metadata notedb.Metadata
+	// NoteID and TagID are the properties of note_tag objects, which attach
+	// tags to notes.
+	NoteID string
+	TagID  string
+
+	// loadData retrieves Data when the object is first opened, for resources
+	// which are not loaded with the rest of the objects. Size holds the size
+	// of the data until then.
+	loadData func() ([]byte, error)
+	Size     int
+	loadOnce sync.Once
+	loadErr  error
+}
+
+// load retrieves the data of the object, if it has not been loaded yet.
+func (o *jexObject) load() error {
+	o.loadOnce.Do(func() {
+		if o.loadData != nil {
+			o.Data, o.loadErr = o.loadData()
+		}
+	})
+	return o.loadErr
+}
+
+// size returns the size of the data of the object, even if it has not been
+// loaded yet.
+func (o *jexObject) size() int {
+	if o.Data == nil {
+		return o.Size
+	}
+	return len(o.Data)
 }
 
 func newjexObject(rawObject string) (*jexObject, error) {
@@ -158,8 +226,22 @@ func newjexObject(rawObject string) (*jexObject, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid user_updated_time: %w", err)
 	}
+	ret.NoteID = props["note_id"]
+	ret.TagID = props["tag_id"]
+	if ret.Type == TypeNote {
+		ret.Metadata.Updated = ret.ModTime
+		if created, ok := props["user_created_time"]; ok {
+			ret.Metadata.Created, err = time.Parse("2006-01-02T15:04:05Z07:00", created)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user_created_time: %w", err)
+			}
+		}
+		ret.Metadata.Author = props["author"]
+		ret.Metadata.SourceURL = props["source_url"]
+		ret.Metadata.Location = parseLocation(props["latitude"], props["longitude"], props["altitude"])
+	}
 
-	if lineEnd != 0 {
+	if lineEnd > 0 {
 		ret.Title = rawObject[0:strings.IndexByte(rawObject, '\n')]
 		if len(ret.Title)+1 < lineEnd {
 			// Extract the body if present
@@ -170,20 +252,165 @@ func newjexObject(rawObject string) (*jexObject, error) {
 	return ret, nil
 }
 
+// encode serializes the object into the format read by newjexObject. Resource
+// data is not included, since it is stored separately in the archive.
+func (o *jexObject) encode() []byte {
+	timestamp := o.ModTime.UTC().Format(jexTimeFormat)
+	var props [][2]string
+	switch o.Type {
+	case TypeNote:
+		created := timestamp
+		if !o.Metadata.Created.IsZero() {
+			created = o.Metadata.Created.UTC().Format(jexTimeFormat)
+		}
+		var location notedb.Location
+		if o.Metadata.Location != nil {
+			location = *o.Metadata.Location
+		}
+		props = [][2]string{
+			{"id", o.ID},
+			{"parent_id", o.ParentID},
+			{"created_time", created},
+			{"updated_time", timestamp},
+			{"is_conflict", "0"},
+			{"latitude", strconv.FormatFloat(location.Latitude, 'f', 8, 64)},
+			{"longitude", strconv.FormatFloat(location.Longitude, 'f', 8, 64)},
+			{"altitude", strconv.FormatFloat(location.Altitude, 'f', 4, 64)},
+			{"author", o.Metadata.Author},
+			{"source_url", o.Metadata.SourceURL},
+			{"is_todo", "0"},
+			{"todo_due", "0"},
+			{"todo_completed", "0"},
+			{"source", "pilikino"},
+			{"source_application", "pilikino"},
+			{"order", "0"},
+			{"user_created_time", created},
+			{"user_updated_time", timestamp},
+			{"encryption_cipher_text", ""},
+			{"encryption_applied", "0"},
+			{"markup_language", "1"},
+			{"is_shared", "0"},
+		}
+	case TypeFolder:
+		props = [][2]string{
+			{"id", o.ID},
+			{"created_time", timestamp},
+			{"updated_time", timestamp},
+			{"user_created_time", timestamp},
+			{"user_updated_time", timestamp},
+			{"encryption_cipher_text", ""},
+			{"encryption_applied", "0"},
+			{"parent_id", o.ParentID},
+			{"is_shared", "0"},
+		}
+	case TypeResource:
+		ext := resourceExtension(o)
+		props = [][2]string{
+			{"id", o.ID},
+			{"mime", mime.TypeByExtension("." + ext)},
+			{"filename", ""},
+			{"created_time", timestamp},
+			{"updated_time", timestamp},
+			{"user_created_time", timestamp},
+			{"user_updated_time", timestamp},
+			{"file_extension", ext},
+			{"encryption_cipher_text", ""},
+			{"encryption_applied", "0"},
+			{"encryption_blob_encrypted", "0"},
+			{"size", strconv.Itoa(len(o.Data))},
+			{"is_shared", "0"},
+		}
+	case TypeTag:
+		props = [][2]string{
+			{"id", o.ID},
+			{"created_time", timestamp},
+			{"updated_time", timestamp},
+			{"user_created_time", timestamp},
+			{"user_updated_time", timestamp},
+			{"encryption_cipher_text", ""},
+			{"encryption_applied", "0"},
+			{"is_shared", "0"},
+			{"parent_id", ""},
+		}
+	case TypeNoteTag:
+		props = [][2]string{
+			{"id", o.ID},
+			{"note_id", o.NoteID},
+			{"tag_id", o.TagID},
+			{"created_time", timestamp},
+			{"updated_time", timestamp},
+			{"user_created_time", timestamp},
+			{"user_updated_time", timestamp},
+			{"encryption_cipher_text", ""},
+			{"encryption_applied", "0"},
+			{"is_shared", "0"},
+		}
+	}
+	props = append(props, [2]string{"type_", strconv.Itoa(o.Type)})
+
+	var buf bytes.Buffer
+	if o.Type != TypeNoteTag {
+		// Note tags have no title, so they consist only of properties.
+		buf.WriteString(o.Title)
+		buf.WriteString("\n\n")
+	}
+	if o.Type != TypeResource && len(o.Data) > 0 {
+		buf.Write(o.Data)
+		buf.WriteString("\n\n")
+	}
+	for i, prop := range props {
+		if i != 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(prop[0])
+		buf.WriteString(": ")
+		buf.WriteString(prop[1])
+	}
+	return buf.Bytes()
+}
+
+// parseLocation converts the coordinates of a note into a location. Joplin
+// uses zero for notes which have no location.
+func parseLocation(latitude, longitude, altitude string) *notedb.Location {
+	var location notedb.Location
+	location.Latitude, _ = strconv.ParseFloat(latitude, 64)
+	location.Longitude, _ = strconv.ParseFloat(longitude, 64)
+	location.Altitude, _ = strconv.ParseFloat(altitude, 64)
+	if location.Latitude == 0 && location.Longitude == 0 {
+		return nil
+	}
+	return &location
+}
+
+// resourceExtension returns the file extension of the resource, without the
+// leading dot.
+func resourceExtension(o *jexObject) string {
+	return strings.TrimPrefix(filepath.Ext(o.Title), ".")
+}
+
 func init() {
 	notedb.RegisterFormat(notedb.FormatDescription{
-		ID:            "joplin-export",
-		Description:   "Joplin export (JEX)",
-		Documentation: `This is the official export format for Joplin.`,
-		Open:          OpenDatabase,
-		Detect:        Detect,
+		ID:          "joplin-export",
+		Description: "Joplin export (JEX)",
+		Documentation: `This is the official export format for Joplin. The title, tags,
+creation time, author, source URL, and location of each note are available
+as its metadata.
+
+If the JEX file does not exist, it is opened for writing and the archive is
+produced once the conversion finishes. Directories containing notes become
+notebooks, and all other files become resources. The metadata of each note is
+kept, and its tags become Joplin tags.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
 	})
 }
 
 // OpenDatabase is the entrypoint for the Joplin JEX format.
 func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
 	file, err := os.Open(dbURL.Path)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return newWriter(dbURL.Path), nil
+	} else if err != nil {
 		return nil, err
 	}
 	defer file.Close()
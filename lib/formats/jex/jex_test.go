@@ -0,0 +1,76 @@
+package jex
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	jexPath := filepath.Join(t.TempDir(), "out.jex")
+	dbURL := &url.URL{Scheme: "joplin-export", Path: jexPath}
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Notebook/images", 0777))
+	notedbtest.WriteFile(t, db, "Notebook/First.md", "# First\n\nSee [second](../Second.md#top) and ![img](images/a.png).\n")
+	notedbtest.WriteFile(t, db, "Notebook/images/a.png", "PNG")
+	f, err := fs.Create(db, "Second.md")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("---\ntitle: Second?\n---\n\nBack to [first](Notebook/First.md).\n"))
+	require.NoError(t, err)
+	metadata := notedb.Metadata{
+		Title:     "Second?",
+		Tags:      []string{"ideas", "work"},
+		Created:   time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC),
+		Author:    "Alice",
+		SourceURL: "https://example.com/",
+		Location:  &notedb.Location{Latitude: 35.6895, Longitude: 139.6917, Altitude: 40},
+	}
+	require.NoError(t, notedb.WriteMetadata(f.(notedb.Note), metadata))
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Chtimes(db, "Second.md", modTime, modTime))
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	db, err = OpenDatabase(dbURL)
+	require.NoError(t, err)
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notebook",
+		"Notebook/First.md",
+		"Second.md",
+		"_resources",
+		"_resources/a.png",
+	}, paths)
+
+	require.Equal(t, "# First\n\nSee [second](../Second.md#top) and ![img](../_resources/a.png).\n", notedbtest.RenderNote(t, db, "Notebook/First.md"))
+	require.Equal(t, "Back to [first](Notebook/First.md).\n", notedbtest.RenderNote(t, db, "Second.md"))
+
+	info, err := fs.Stat(db, "Second.md")
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(modTime))
+	note, err := db.Open("Second.md")
+	require.NoError(t, err)
+	metadata.Updated = modTime
+	readMetadata, err := note.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, metadata, readMetadata)
+
+	data, err := fs.ReadFile(db, "_resources/a.png")
+	require.NoError(t, err)
+	require.Equal(t, "PNG", string(data))
+}
@@ -0,0 +1,104 @@
+package jex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+)
+
+var rawObjectName = regexp.MustCompile(`^[0-9a-f]{32}\.md$`)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "joplin-raw",
+		Description: "Joplin RAW export directory",
+		Documentation: `This is the RAW directory export format for Joplin. It contains
+the same objects as a JEX file, but as a directory of files instead of an
+archive. Files which are not Joplin objects or resources are skipped.`,
+		Open:   OpenRawDatabase,
+		Detect: DetectRaw,
+	})
+}
+
+// OpenRawDatabase is the entrypoint for the Joplin RAW format.
+func OpenRawDatabase(dbURL *url.URL) (notedb.Database, error) {
+	jex, err := newRawJEX(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newJoplinFS(jex)
+}
+
+// DetectRaw determines if the URL is likely to be a Joplin RAW export. RAW
+// exports are ordinary directories with no distinguishing name, so the
+// directory is checked for the resources folder and Joplin object files.
+func DetectRaw(dbURL *url.URL) notedb.DetectResult {
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	hasResources, hasObjects := false, false
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() == "resources" {
+			hasResources = true
+		} else if rawObjectName.MatchString(entry.Name()) {
+			hasObjects = true
+		}
+	}
+	if hasResources && hasObjects {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+func newRawJEX(dir string) (*JEX, error) {
+	files := newJEXFiles()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Only the objects and resources are loaded, and other files, such as
+	// .DS_Store, are skipped.
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() == "resources" {
+			if err := addRawDir(files, dir, "resources"); err != nil {
+				return nil, err
+			}
+		} else if entry.Type().IsRegular() && rawObjectName.MatchString(entry.Name()) {
+			if err := addRawFile(files, dir, entry.Name()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files.load()
+}
+
+func addRawDir(files *jexFiles, root string, name string) error {
+	entries, err := os.ReadDir(filepath.Join(root, name))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := addRawFile(files, root, name+"/"+entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addRawFile(files *jexFiles, root string, name string) error {
+	data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return fmt.Errorf("while reading %s: %w", name, err)
+	}
+	return files.add(name, data)
+}
@@ -0,0 +1,52 @@
+package jex
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaw(t *testing.T) {
+	root := t.TempDir()
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	objects := []*jexObject{
+		{ID: "0123456789abcdef0123456789abcdef", Type: TypeFolder, Title: "Notebook", ModTime: modTime},
+		{ID: "fedcba9876543210fedcba9876543210", Type: TypeNote, Title: "Note", ParentID: "0123456789abcdef0123456789abcdef", Data: []byte("See ![img](:/00112233445566778899aabbccddeeff)."), ModTime: modTime},
+		{ID: "00112233445566778899aabbccddeeff", Type: TypeResource, Title: "a.png", ModTime: modTime},
+	}
+	for _, object := range objects {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, object.ID+".md"), object.encode(), 0666))
+	}
+	files := map[string]string{
+		"resources/00112233445566778899aabbccddeeff.png": "PNG",
+		"resources/.DS_Store":                            "",
+		".DS_Store":                                      "",
+		"notes.txt":                                      "",
+		"Stray/file.md":                                  "",
+	}
+	for name, data := range files {
+		hostPath := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(hostPath), 0777))
+		require.NoError(t, ioutil.WriteFile(hostPath, []byte(data), 0666))
+	}
+
+	dbURL := &url.URL{Scheme: "joplin-raw", Path: root}
+	require.Equal(t, notedb.DetectResultPositive, DetectRaw(dbURL))
+	db, err := OpenRawDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notebook",
+		"Notebook/Note.md",
+		"_resources",
+		"_resources/a.png",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "See ![img](../_resources/a.png).\n", notedbtest.RenderNote(t, db, "Notebook/Note.md"))
+}
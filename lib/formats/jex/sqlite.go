@@ -0,0 +1,194 @@
+//go:build cgo
+// +build cgo
+
+package jex
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteFilename = "database.sqlite"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "joplin-sqlite",
+		Description: "Joplin profile database",
+		Documentation: `This format reads the live database of a Joplin profile, which
+is usually located at ~/.config/joplin-desktop/database.sqlite. The path may
+point at either the database file or the profile directory. The database is
+opened read-only, and resources are read from the resources directory in the
+profile. Resources which have not been downloaded by Joplin are skipped.
+
+This format requires cgo, and is not available in builds without it.`,
+		Open:   OpenSQLiteDatabase,
+		Detect: DetectSQLite,
+	})
+}
+
+// OpenSQLiteDatabase is the entrypoint for the Joplin SQLite format.
+func OpenSQLiteDatabase(dbURL *url.URL) (notedb.Database, error) {
+	dbPath := dbURL.Path
+	if info, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		dbPath = filepath.Join(dbPath, sqliteFilename)
+	}
+	jex, err := loadSQLite(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return newJoplinFS(jex)
+}
+
+// DetectSQLite determines if the URL is likely to be a Joplin profile, which
+// is either the database file itself or a directory which contains it.
+func DetectSQLite(dbURL *url.URL) notedb.DetectResult {
+	dbPath := dbURL.Path
+	if filepath.Base(dbPath) != sqliteFilename {
+		dbPath = filepath.Join(dbPath, sqliteFilename)
+	}
+	if info, err := os.Stat(dbPath); err == nil && info.Mode().IsRegular() {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+// loadSQLite reads all of the folders, notes, and resources from the Joplin
+// database at the given path into memory.
+func loadSQLite(dbPath string) (*JEX, error) {
+	// Opening a database which doesn't exist would create it.
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	dsn := (&url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ret := &JEX{}
+	queries := []struct {
+		objectType int
+		query      string
+	}{
+		{TypeFolder, "SELECT id, title, '', parent_id, user_updated_time FROM folders"},
+		{TypeNote, "SELECT id, title, body, parent_id, user_updated_time FROM notes WHERE is_conflict = 0"},
+		{TypeResource, "SELECT id, title, file_extension, '', user_updated_time FROM resources"},
+		{TypeTag, "SELECT id, title, '', '', user_updated_time FROM tags"},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q.query)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", dbPath, err)
+		}
+		for rows.Next() {
+			var id, title, body, parentID string
+			var modTime int64
+			if err := rows.Scan(&id, &title, &body, &parentID, &modTime); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("while reading %s: %w", dbPath, err)
+			}
+			object := &jexObject{
+				ID:       id,
+				Type:     q.objectType,
+				Title:    title,
+				ParentID: parentID,
+				ModTime:  time.Unix(0, modTime*int64(time.Millisecond)),
+			}
+			if q.objectType == TypeResource {
+				object.Data, err = readResource(dbPath, id, body)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("while loading %s: %w", id, err)
+				}
+			} else if body != "" {
+				object.Data = []byte(body)
+			}
+			ret.objects = append(ret.objects, object)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", dbPath, err)
+		}
+	}
+	if err := loadSQLiteMetadata(db, ret); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbPath, err)
+	}
+	return ret, nil
+}
+
+// loadSQLiteMetadata fills in the metadata of the notes and adds the note_tag
+// objects which attach tags to them.
+func loadSQLiteMetadata(db *sql.DB, jex *JEX) error {
+	notes := map[string]*jexObject{}
+	for _, object := range jex.objects {
+		if object.Type == TypeNote {
+			notes[object.ID] = object
+		}
+	}
+	rows, err := db.Query("SELECT id, user_created_time, user_updated_time, author, source_url, latitude, longitude, altitude FROM notes WHERE is_conflict = 0")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, author, sourceURL, latitude, longitude, altitude string
+		var created, updated int64
+		if err := rows.Scan(&id, &created, &updated, &author, &sourceURL, &latitude, &longitude, &altitude); err != nil {
+			rows.Close()
+			return err
+		}
+		if note, ok := notes[id]; ok {
+			note.Metadata = notedb.Metadata{
+				Created:   time.Unix(0, created*int64(time.Millisecond)),
+				Updated:   time.Unix(0, updated*int64(time.Millisecond)),
+				Author:    author,
+				SourceURL: sourceURL,
+				Location:  parseLocation(latitude, longitude, altitude),
+			}
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT id, note_id, tag_id, user_updated_time FROM note_tags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		object := &jexObject{Type: TypeNoteTag}
+		var modTime int64
+		if err := rows.Scan(&object.ID, &object.NoteID, &object.TagID, &modTime); err != nil {
+			return err
+		}
+		object.ModTime = time.Unix(0, modTime*int64(time.Millisecond))
+		jex.objects = append(jex.objects, object)
+	}
+	return rows.Err()
+}
+
+// readResource loads the data of the resource from the resources directory
+// next to the database.
+func readResource(dbPath, id, ext string) ([]byte, error) {
+	name := id
+	if ext != "" {
+		name = name + "." + ext
+	}
+	return ioutil.ReadFile(filepath.Join(filepath.Dir(dbPath), "resources", name))
+}
@@ -0,0 +1,75 @@
+//go:build cgo
+// +build cgo
+
+package jex
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	"github.com/stretchr/testify/require"
+)
+
+// writeProfile creates a Joplin profile holding a notebook with one note,
+// which has a tag and an image.
+func writeProfile(t *testing.T) string {
+	root := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(root, sqliteFilename))
+	require.NoError(t, err)
+	defer db.Close()
+	statements := []string{
+		"CREATE TABLE folders (id TEXT, title TEXT, parent_id TEXT, user_updated_time INT)",
+		"CREATE TABLE notes (id TEXT, title TEXT, body TEXT, parent_id TEXT, is_conflict INT, user_created_time INT, user_updated_time INT, author TEXT, source_url TEXT, latitude NUMERIC, longitude NUMERIC, altitude NUMERIC)",
+		"CREATE TABLE resources (id TEXT, title TEXT, file_extension TEXT, user_updated_time INT)",
+		"CREATE TABLE tags (id TEXT, title TEXT, user_updated_time INT)",
+		"CREATE TABLE note_tags (id TEXT, note_id TEXT, tag_id TEXT, user_updated_time INT)",
+		"INSERT INTO folders VALUES ('f1', 'Notebook', '', 1622548800000)",
+		"INSERT INTO notes VALUES ('n1', 'First', 'See ![img](:/r1).', 'f1', 0, 1619856000000, 1622548800000, 'Alice', '', '0.00000000', '0.00000000', '0.0000')",
+		"INSERT INTO notes VALUES ('n2', 'Conflict', 'Old', 'f1', 1, 1622548800000, 1622548800000, '', '', 0, 0, 0)",
+		"INSERT INTO resources VALUES ('r1', 'a.png', 'png', 1622548800000)",
+		"INSERT INTO resources VALUES ('r2', 'missing.png', 'png', 1622548800000)",
+		"INSERT INTO tags VALUES ('t1', 'work', 1622548800000)",
+		"INSERT INTO note_tags VALUES ('nt1', 'n1', 't1', 1622548800000)",
+	}
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		require.NoError(t, err, statement)
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(root, "resources"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "resources", "r1.png"), []byte("PNG"), 0666))
+	return root
+}
+
+func TestSQLite(t *testing.T) {
+	root := writeProfile(t)
+	require.Equal(t, notedb.DetectResultPositive, DetectSQLite(&url.URL{Path: root}))
+	require.Equal(t, notedb.DetectResultNegative, DetectSQLite(&url.URL{Path: filepath.Join(t.TempDir(), "joplin")}))
+
+	db, err := OpenSQLiteDatabase(&url.URL{Scheme: "joplin-sqlite", Path: root})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notebook",
+		"Notebook/First.md",
+		"_resources",
+		"_resources/a.png",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "See ![img](../_resources/a.png).\n", notedbtest.RenderNote(t, db, "Notebook/First.md"))
+
+	f, err := db.Open("Notebook/First.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, "First", metadata.Title)
+	require.Equal(t, []string{"work"}, metadata.Tags)
+	require.Equal(t, "Alice", metadata.Author)
+	require.True(t, metadata.Created.Equal(time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC)))
+	require.Nil(t, metadata.Location)
+}
@@ -0,0 +1,232 @@
+package jex
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+// Writer is a database which holds the written notes in memory and produces a
+// JEX file when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+func newWriter(path string) *Writer {
+	return &Writer{mem.New(), path}
+}
+
+// Close writes the JEX file. Any links which cannot be resolved are reported
+// as errors, but do not prevent the file from being written.
+func (w *Writer) Close() error {
+	objects, buildErr := buildObjects(w.Database)
+	file, err := os.Create(w.path)
+	if err != nil {
+		return multierr.Append(buildErr, err)
+	}
+	defer file.Close()
+
+	archive := tar.NewWriter(file)
+	for _, object := range objects {
+		data := object.encode()
+		if err := writeTarFile(archive, object.ID+".md", object.ModTime, data); err != nil {
+			return multierr.Append(buildErr, err)
+		}
+		if object.Type == TypeResource {
+			name := "resources/" + object.ID
+			if ext := resourceExtension(object); ext != "" {
+				name = name + "." + ext
+			}
+			if err := writeTarFile(archive, name, object.ModTime, object.Data); err != nil {
+				return multierr.Append(buildErr, err)
+			}
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return multierr.Append(buildErr, err)
+	}
+	return multierr.Append(buildErr, file.Close())
+}
+
+func writeTarFile(archive *tar.Writer, name string, modTime time.Time, data []byte) error {
+	err := archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(data)
+	return err
+}
+
+// buildObjects converts the directory tree into Joplin objects. Directories
+// which contain notes become folders, notes become notes, and all other files
+// become resources. Directories which contain no notes are not represented in
+// the output, but the files inside them are.
+func buildObjects(db fs.FS) ([]*jexObject, error) {
+	type walkedFile struct {
+		path    string
+		isDir   bool
+		modTime time.Time
+	}
+	var files []walkedFile
+	hasNotes := map[string]bool{}
+	err := fs.WalkDir(db, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, walkedFile{p, d.IsDir(), info.ModTime()})
+		if !d.IsDir() && strings.HasSuffix(p, ".md") {
+			for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+				hasNotes[dir] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*jexObject
+	idLookup := map[string]string{}
+	folderLookup := map[string]string{".": ""}
+	notePaths := map[*jexObject]string{}
+	for _, file := range files {
+		parentID := folderLookup[path.Dir(file.path)]
+		name := path.Base(file.path)
+		if file.isDir {
+			folderLookup[file.path] = parentID
+			if hasNotes[file.path] {
+				folder := &jexObject{ID: newID(), Type: TypeFolder, Title: name, ParentID: parentID, ModTime: file.modTime}
+				objects = append(objects, folder)
+				folderLookup[file.path] = folder.ID
+			}
+			continue
+		}
+
+		data, err := fs.ReadFile(db, file.path)
+		if err != nil {
+			return nil, err
+		}
+		object := &jexObject{ID: newID(), Type: TypeResource, Title: name, Data: data, ModTime: file.modTime}
+		if strings.HasSuffix(name, ".md") {
+			object.Type = TypeNote
+			object.Title = strings.TrimSuffix(name, ".md")
+			object.ParentID = parentID
+			object.Metadata, err = readMetadata(db, file.path)
+			if err != nil {
+				return nil, err
+			}
+			if object.Metadata.Title != "" {
+				object.Title = object.Metadata.Title
+			}
+			if !object.Metadata.IsZero() {
+				// The metadata is stored in the fields of the note, so the
+				// front matter it came from is left out of the body.
+				object.Data = parser.StripFrontMatter(object.Data)
+			}
+			notePaths[object] = file.path
+		}
+		idLookup[file.path] = object.ID
+		objects = append(objects, object)
+	}
+
+	// Each distinct tag becomes a tag object, which is attached to the notes
+	// using note_tag objects.
+	tagIDs := map[string]string{}
+	for _, object := range objects {
+		if object.Type != TypeNote {
+			continue
+		}
+		for _, tag := range object.Metadata.Tags {
+			tagID, ok := tagIDs[tag]
+			if !ok {
+				tagID = newID()
+				tagIDs[tag] = tagID
+				objects = append(objects, &jexObject{ID: tagID, Type: TypeTag, Title: tag, ModTime: object.ModTime})
+			}
+			objects = append(objects, &jexObject{ID: newID(), Type: TypeNoteTag, NoteID: object.ID, TagID: tagID, ModTime: object.ModTime})
+		}
+	}
+
+	for _, object := range objects {
+		if object.Type != TypeNote {
+			continue
+		}
+		data, linkErr := rewriteLinks(notePaths[object], object.Data, idLookup)
+		if linkErr != nil {
+			for _, linkErr := range multierr.Errors(linkErr) {
+				err = multierr.Append(err, &fs.PathError{Op: "convert", Path: notePaths[object], Err: linkErr})
+			}
+		}
+		object.Data = bytes.TrimRight(data, "\n")
+	}
+	return objects, err
+}
+
+// readMetadata returns the metadata of the note at the path.
+func readMetadata(db fs.FS, name string) (notedb.Metadata, error) {
+	f, err := db.Open(name)
+	if err != nil {
+		return notedb.Metadata{}, err
+	}
+	defer f.Close()
+	note, ok := f.(notedb.Note)
+	if !ok {
+		return notedb.Metadata{}, nil
+	}
+	return note.Metadata()
+}
+
+// rewriteLinks converts relative links in the note into Joplin's `:/id` form.
+// The returned data is unchanged if there are no links to rewrite.
+func rewriteLinks(notePath string, data []byte, idLookup map[string]string) ([]byte, error) {
+	return notedb.RewriteLinks(data, func(dest []byte) ([]byte, error) {
+		target, fragment, ok := notedb.LocalLink(notePath, dest)
+		if !ok {
+			return dest, nil
+		}
+		id, ok := idLookup[target]
+		if !ok {
+			return dest, fmt.Errorf("dead link: %s", dest)
+		}
+		link := ":/" + id
+		if fragment != "" {
+			link = link + "#" + fragment
+		}
+		return []byte(link), nil
+	})
+}
+
+// newID generates a random Joplin object ID.
+func newID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
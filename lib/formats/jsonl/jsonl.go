@@ -0,0 +1,242 @@
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "jsonl",
+		Description: "JSON Lines dump",
+		Documentation: `This format stores the entire database in a single JSON Lines file, which
+is easy to process with tools like jq. Paths ending with .jsonl are detected
+automatically. If the file does not exist, it is created; an existing file can
+only be read.
+
+Each line is a record for one file, in the order that the files appear in the
+database, with the following fields:
+
+  path      the path of the file in the database
+  isNote    true for notes, false for attachments
+  modTime   the modification time, in RFC 3339 format
+  metadata  the metadata of a note, if there is any
+  markdown  the Markdown source of a note
+  data      the contents of an attachment, encoded with base64
+
+The metadata object has the fields title, tags, created, updated, author,
+sourceURL, location (with latitude, longitude, and altitude), and extra, and
+fields which are empty are left out.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// Record is a single line of the file.
+type Record struct {
+	Path     string    `json:"path"`
+	IsNote   bool      `json:"isNote"`
+	ModTime  time.Time `json:"modTime"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+	Markdown string    `json:"markdown,omitempty"`
+	Data     []byte    `json:"data,omitempty"`
+}
+
+// Metadata is the JSON form of notedb.Metadata.
+type Metadata struct {
+	Title     string                 `json:"title,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
+	Created   *time.Time             `json:"created,omitempty"`
+	Updated   *time.Time             `json:"updated,omitempty"`
+	Author    string                 `json:"author,omitempty"`
+	SourceURL string                 `json:"sourceURL,omitempty"`
+	Location  *Location              `json:"location,omitempty"`
+	Extra     map[string]interface{} `json:"extra,omitempty"`
+}
+
+// Location is the JSON form of notedb.Location.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// newMetadata converts the metadata into its JSON form. It returns nil if no
+// metadata is set.
+func newMetadata(m notedb.Metadata) *Metadata {
+	if m.IsZero() {
+		return nil
+	}
+	ret := &Metadata{
+		Title:     m.Title,
+		Tags:      m.Tags,
+		Author:    m.Author,
+		SourceURL: m.SourceURL,
+		Extra:     m.Extra,
+	}
+	if !m.Created.IsZero() {
+		ret.Created = &m.Created
+	}
+	if !m.Updated.IsZero() {
+		ret.Updated = &m.Updated
+	}
+	if m.Location != nil {
+		ret.Location = &Location{m.Location.Latitude, m.Location.Longitude, m.Location.Altitude}
+	}
+	return ret
+}
+
+// Metadata converts the JSON form back into a notedb.Metadata.
+func (m *Metadata) Metadata() notedb.Metadata {
+	ret := notedb.Metadata{
+		Title:     m.Title,
+		Tags:      m.Tags,
+		Author:    m.Author,
+		SourceURL: m.SourceURL,
+		Extra:     m.Extra,
+	}
+	if m.Created != nil {
+		ret.Created = *m.Created
+	}
+	if m.Updated != nil {
+		ret.Updated = *m.Updated
+	}
+	if m.Location != nil {
+		ret.Location = &notedb.Location{Latitude: m.Location.Latitude, Longitude: m.Location.Longitude, Altitude: m.Location.Altitude}
+	}
+	return ret
+}
+
+// Writer is a database which holds the written files in memory and produces
+// the file when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+// OpenDatabase is the entrypoint for the JSON Lines format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	file, err := os.Open(dbURL.Path)
+	if os.IsNotExist(err) {
+		return &Writer{mem.New(), dbURL.Path}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	db, err := readRecords(file)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a JSON Lines file.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if strings.HasSuffix(dbURL.Path, ".jsonl") {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+func readRecords(r io.Reader) (*mem.Database, error) {
+	db := mem.New()
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var record Record
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		data := record.Data
+		if record.IsNote {
+			data = []byte(record.Markdown)
+		}
+		if err := db.WriteFile(record.Path, data, record.ModTime); err != nil {
+			return nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		if record.Metadata != nil {
+			if err := db.SetMetadata(record.Path, record.Metadata.Metadata()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
+
+// Close writes the file.
+func (w *Writer) Close() error {
+	file, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	var errs error
+	err = fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		record, err := w.record(p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			return nil
+		}
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+	if err := buf.Flush(); err != nil {
+		return multierr.Append(errs, err)
+	}
+	return multierr.Append(errs, file.Close())
+}
+
+func (w *Writer) record(p string) (*Record, error) {
+	f, err := w.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	note := f.(notedb.Note)
+	record := &Record{Path: p, IsNote: note.IsNote(), ModTime: info.ModTime()}
+	if record.IsNote {
+		metadata, err := note.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		record.Metadata = newMetadata(metadata)
+		data := note.Data()
+		if record.Metadata != nil {
+			// The metadata has its own field, so the front matter it came
+			// from is left out of the Markdown.
+			data = parser.StripFrontMatter(data)
+		}
+		record.Markdown = string(data)
+	} else {
+		record.Data = note.Data()
+	}
+	return record, nil
+}
@@ -0,0 +1,56 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dump.jsonl")
+	dbURL, err := notedb.ResolveURL(file)
+	require.NoError(t, err)
+	require.Equal(t, "jsonl", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Folder", 0777))
+	notedbtest.WriteFileAt(t, db, "Folder/Note.md", "# Note\n\n<b>Bold</b> & ![img](../image.png)\n", modTime)
+	notedbtest.WriteFileAt(t, db, "image.png", "\x89PNG", modTime)
+	metadata := notedb.Metadata{Tags: []string{"work"}, Created: modTime, Extra: map[string]interface{}{"source": "camera"}}
+	require.NoError(t, db.(*Writer).SetMetadata("Folder/Note.md", metadata))
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, `{"path":"Folder/Note.md","isNote":true,"modTime":"2021-06-01T12:00:00Z",`+
+		`"metadata":{"tags":["work"],"created":"2021-06-01T12:00:00Z","extra":{"source":"camera"}},`+
+		`"markdown":"# Note\n\n<b>Bold</b> & ![img](../image.png)\n"}`+"\n"+
+		`{"path":"image.png","isNote":false,"modTime":"2021-06-01T12:00:00Z","data":"iVBORw=="}`+"\n",
+		string(data))
+
+	db, err = OpenDatabase(dbURL)
+	require.NoError(t, err)
+	note, err := fs.ReadFile(db, "Folder/Note.md")
+	require.NoError(t, err)
+	require.Equal(t, "# Note\n\n<b>Bold</b> & ![img](../image.png)\n", string(note))
+	f, err := db.Open("Folder/Note.md")
+	require.NoError(t, err)
+	readMetadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, metadata, readMetadata)
+	info, err := fs.Stat(db, "image.png")
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(info.ModTime()))
+	image, err := fs.ReadFile(db, "image.png")
+	require.NoError(t, err)
+	require.Equal(t, "\x89PNG", string(image))
+	_, err = fs.OpenFile(db, "Other.md", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	require.ErrorIs(t, err, fs.ErrPermission)
+}
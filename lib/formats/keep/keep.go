@@ -0,0 +1,328 @@
+package keep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// errMissingAttachment is returned when a file attached to a note is not in
+// the export.
+var errMissingAttachment = errors.New("missing attachment")
+
+const (
+	resourcesFolder = "_resources"
+	archivedFolder  = "Archived"
+	trashFolder     = "Trash"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "google-keep",
+		Description: "Google Keep (Google Takeout)",
+		Documentation: `This is the Google Takeout export of Google Keep. The path may point at
+the Keep directory, or at a directory containing it, such as an extracted
+Takeout directory.
+
+Each note is named after its title. Checklists become task lists, and images
+are placed in a _resources directory and linked from the end of the note.
+Attachments which are missing from the export are reported as errors when the
+note is read. Archived notes are placed in an Archived directory and trashed
+notes in a Trash directory. The modification time of each note is the time it
+was last edited. The title, labels, and creation time of each note are
+available as its metadata, with its color and whether it is pinned in the
+extra metadata.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+type keepNote struct {
+	Title                   string `json:"title"`
+	TextContent             string `json:"textContent"`
+	Color                   string `json:"color"`
+	IsTrashed               bool   `json:"isTrashed"`
+	IsPinned                bool   `json:"isPinned"`
+	IsArchived              bool   `json:"isArchived"`
+	UserEditedTimestampUsec int64  `json:"userEditedTimestampUsec"`
+	CreatedTimestampUsec    int64  `json:"createdTimestampUsec"`
+	ListContent             []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Attachments []struct {
+		FilePath string `json:"filePath"`
+		MimeType string `json:"mimetype"`
+	} `json:"attachments"`
+	Annotations []struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	} `json:"annotations"`
+}
+
+// OpenDatabase is the entrypoint for the Google Keep format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	dir, ok := findKeepDir(dbURL.Path)
+	if !ok {
+		return nil, fmt.Errorf("%s: no Google Keep notes found", dbURL.Path)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{
+		db:            &Database{mem.New(), map[string]error{}},
+		dir:           dir,
+		usedNames:     map[string]map[string]bool{"": {}, archivedFolder: {}, trashFolder: {}},
+		usedResources: map[string]bool{},
+		resources:     map[string]string{},
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var note keepNote
+		if err := json.Unmarshal(data, &note); err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", entry.Name(), err)
+		}
+		if note.UserEditedTimestampUsec == 0 {
+			// Takeout includes other JSON files, which are not notes.
+			continue
+		}
+		if err := l.addNote(entry.Name(), &note); err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", entry.Name(), err)
+		}
+	}
+	l.db.SetReadOnly()
+	return l.db, nil
+}
+
+// Database is a Google Keep export, which has been loaded into memory.
+type Database struct {
+	*mem.Database
+	// errs holds the attachments which could not be found for each note,
+	// which are reported when the note is parsed.
+	errs map[string]error
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &keepFile{f.(mem.File), db.errs[name]}, nil
+}
+
+type keepFile struct {
+	mem.File
+	err error
+}
+
+var _ notedb.Note = (*keepFile)(nil)
+var _ fs.ReadDirFile = (*keepFile)(nil)
+
+func (f *keepFile) ParseAST() (ast.Node, error) {
+	doc, err := f.File.ParseAST()
+	return doc, multierr.Append(f.err, err)
+}
+
+// Detect determines if the URL is likely to be a Google Keep export.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if _, ok := findKeepDir(dbURL.Path); ok {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+// findKeepDir locates the directory containing the notes.
+func findKeepDir(root string) (string, bool) {
+	for _, dir := range []string{root, filepath.Join(root, "Keep"), filepath.Join(root, "Takeout", "Keep")} {
+		if filepath.Base(dir) != "Keep" {
+			continue
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(matches) > 0 {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// loader holds the state used while reading the notes.
+type loader struct {
+	db            *Database
+	dir           string
+	usedNames     map[string]map[string]bool
+	usedResources map[string]bool
+	// resources maps the attachments which have been read to their paths in
+	// the database.
+	resources map[string]string
+}
+
+func (l *loader) addNote(fileName string, note *keepNote) error {
+	folder := ""
+	if note.IsTrashed {
+		folder = trashFolder
+	} else if note.IsArchived {
+		folder = archivedFolder
+	}
+	title := note.Title
+	if title == "" {
+		title = strings.SplitN(strings.TrimSpace(note.TextContent), "\n", 2)[0]
+	}
+	if title == "" && len(note.ListContent) > 0 {
+		title = note.ListContent[0].Text
+	}
+	name := notedb.EscapeName(title)
+	if name == "" {
+		name = strings.TrimSuffix(fileName, ".json")
+	}
+	notePath := path.Join(folder, notedb.UniqueName(name+".md", l.usedNames[folder]))
+	modTime := fromMicros(note.UserEditedTimestampUsec)
+
+	var sb strings.Builder
+	sb.WriteString(parser.EscapeText(note.TextContent))
+	if len(note.ListContent) > 0 {
+		writeSeparator(&sb)
+		for _, item := range note.ListContent {
+			checkbox := "[ ]"
+			if item.IsChecked {
+				checkbox = "[x]"
+			}
+			text := strings.Join(strings.Fields(item.Text), " ")
+			fmt.Fprintf(&sb, "- %s %s\n", checkbox, parser.EscapeText(text))
+		}
+	}
+	for _, attachment := range note.Attachments {
+		resource, err := l.addResource(attachment.FilePath, modTime)
+		if errors.Is(err, errMissingAttachment) {
+			// The rest of the note is still usable, so the problem is
+			// reported when the note is parsed.
+			l.db.errs[notePath] = multierr.Append(l.db.errs[notePath], err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		link := resource
+		if folder != "" {
+			link = "../" + resource
+		}
+		writeSeparator(&sb)
+		fmt.Fprintf(&sb, "![](%s)\n", (&url.URL{Path: link}).String())
+	}
+	for _, annotation := range note.Annotations {
+		if annotation.URL == "" {
+			continue
+		}
+		label := annotation.Title
+		if label == "" {
+			label = annotation.URL
+		}
+		writeSeparator(&sb)
+		fmt.Fprintf(&sb, "[%s](%s)\n", parser.EscapeText(strings.Join(strings.Fields(label), " ")), annotation.URL)
+	}
+
+	if err := l.db.WriteFile(notePath, []byte(sb.String()), modTime); err != nil {
+		return err
+	}
+	var tags []string
+	for _, label := range note.Labels {
+		tags = append(tags, label.Name)
+	}
+	sort.Strings(tags)
+	metadata := notedb.Metadata{
+		Title:   note.Title,
+		Tags:    tags,
+		Created: fromMicros(note.CreatedTimestampUsec),
+		Updated: modTime,
+		Extra:   map[string]interface{}{},
+	}
+	if note.Color != "" && note.Color != "DEFAULT" {
+		metadata.Extra["color"] = note.Color
+	}
+	if note.IsPinned {
+		metadata.Extra["pinned"] = true
+	}
+	return l.db.SetMetadata(notePath, metadata)
+}
+
+// addResource copies the attachment into the database, if it has not been
+// already, and returns its path.
+func (l *loader) addResource(name string, modTime time.Time) (string, error) {
+	source, ok := findAttachment(l.dir, name)
+	if !ok {
+		return "", fmt.Errorf("%w %s", errMissingAttachment, name)
+	}
+	if resource, ok := l.resources[source]; ok {
+		return resource, nil
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	resource := path.Join(resourcesFolder, notedb.UniqueName(filepath.Base(source), l.usedResources))
+	if err := l.db.WriteFile(resource, data, modTime); err != nil {
+		return "", err
+	}
+	l.resources[source] = resource
+	return resource, nil
+}
+
+// writeSeparator ends the current paragraph of the note, if there is one.
+func writeSeparator(sb *strings.Builder) {
+	text := sb.String()
+	switch {
+	case text == "":
+	case strings.HasSuffix(text, "\n\n"):
+	case strings.HasSuffix(text, "\n"):
+		sb.WriteString("\n")
+	default:
+		sb.WriteString("\n\n")
+	}
+}
+
+// findAttachment locates the file for an attachment. Takeout sometimes
+// records a different extension for images than the file actually has, so
+// any file with the same name is accepted.
+func findAttachment(dir, name string) (string, bool) {
+	exact := filepath.Join(dir, filepath.Base(name))
+	if _, err := os.Stat(exact); err == nil {
+		return exact, true
+	}
+	stem := strings.TrimSuffix(exact, filepath.Ext(exact))
+	matches, _ := filepath.Glob(stem + ".*")
+	for _, match := range matches {
+		if filepath.Ext(match) != ".json" && filepath.Ext(match) != ".html" {
+			return match, true
+		}
+	}
+	return "", false
+}
+
+func fromMicros(usec int64) time.Time {
+	if usec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, usec*int64(time.Microsecond)).UTC()
+}
@@ -0,0 +1,80 @@
+package keep
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Takeout", "Keep")
+	require.NoError(t, os.MkdirAll(dir, 0777))
+	files := map[string]string{
+		"Shopping.json": `{"title": "Shopping", "userEditedTimestampUsec": 1622548800000000,
+			"listContent": [{"text": "Milk", "isChecked": false}, {"text": "Eggs", "isChecked": true}],
+			"labels": [{"name": "home"}], "color": "YELLOW",
+			"attachments": [{"filePath": "photo.jpeg", "mimetype": "image/jpeg"}]}`,
+		"Shopping.html": "<html></html>",
+		"photo.jpg":     "jpg",
+		"Old idea.json": `{"title": "", "textContent": "Old idea\nDetails", "isArchived": true,
+			"userEditedTimestampUsec": 1622548800000000,
+			"attachments": [{"filePath": "photo.jpg", "mimetype": "image/jpeg"}]}`,
+		"Todo.json": `{"title": "Todo", "textContent": "# not a *heading*", "userEditedTimestampUsec": 1622548800000000,
+			"listContent": [{"text": "[link]", "isChecked": false}],
+			"annotations": [{"title": "a_b", "url": "https://example.com/"}],
+			"attachments": [{"filePath": "missing.png", "mimetype": "image/png"}]}`,
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	dbURL, err := notedb.ResolveURL(root)
+	require.NoError(t, err)
+	require.Equal(t, "google-keep", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".", "Archived", "Archived/Old idea.md", "Shopping.md", "Todo.md",
+		"_resources", "_resources/photo.jpg",
+	}, paths)
+
+	data, err := fs.ReadFile(db, "Shopping.md")
+	require.NoError(t, err)
+	require.Equal(t, "- [ ] Milk\n- [x] Eggs\n\n![](_resources/photo.jpg)\n", string(data))
+	data, err = fs.ReadFile(db, "Archived/Old idea.md")
+	require.NoError(t, err)
+	require.Equal(t, "Old idea\nDetails\n\n![](../_resources/photo.jpg)\n", string(data))
+
+	// Text is escaped, and missing attachments are reported when the note is
+	// parsed.
+	data, err = fs.ReadFile(db, "Todo.md")
+	require.NoError(t, err)
+	require.Equal(t, "\\# not a \\*heading\\*\n\n- [ ] \\[link\\]\n\n[a\\_b](https://example.com/)\n", string(data))
+	f, err := db.Open("Todo.md")
+	require.NoError(t, err)
+	_, err = f.(notedb.Note).ParseAST()
+	require.EqualError(t, err, "missing attachment missing.png")
+
+	info, err := fs.Stat(db, "Shopping.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), info.ModTime())
+	f, err = db.Open("Shopping.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"home"}, metadata.Tags)
+	require.Equal(t, "YELLOW", metadata.Extra["color"])
+}
@@ -0,0 +1,92 @@
+package logseq
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTitleFormat is the format Logseq uses for the titles of journal
+	// pages when the graph does not configure one.
+	defaultTitleFormat = "MMM do, yyyy"
+	// journalFileLayout is the name of journal files in the journals
+	// directory, as a Go time layout.
+	journalFileLayout = "2006_01_02"
+	// journalNoteLayout is the name of journal notes in the database.
+	journalNoteLayout = "2006-01-02"
+)
+
+var titleFormatPattern = regexp.MustCompile(`:journal/page-title-format\s+"([^"]*)"`)
+
+// readTitleFormat finds the journal title format in the contents of
+// config.edn.
+func readTitleFormat(config []byte) string {
+	if m := titleFormatPattern.FindSubmatch(config); m != nil && len(m[1]) > 0 {
+		return string(m[1])
+	}
+	return defaultTitleFormat
+}
+
+// titleTokens are the parts of a date format understood by formatTitle,
+// longest first. Logseq uses the date-fns format syntax.
+var titleTokens = []string{
+	"yyyy", "yy", "MMMM", "MMM", "MM", "M", "do", "dd", "d", "EEEE", "EEE", "E",
+}
+
+// formatTitle formats the date as the title of a journal page.
+func formatTitle(date time.Time, format string) string {
+	var sb strings.Builder
+outer:
+	for len(format) > 0 {
+		for _, token := range titleTokens {
+			if !strings.HasPrefix(format, token) {
+				continue
+			}
+			switch token {
+			case "yyyy":
+				sb.WriteString(date.Format("2006"))
+			case "yy":
+				sb.WriteString(date.Format("06"))
+			case "MMMM":
+				sb.WriteString(date.Format("January"))
+			case "MMM":
+				sb.WriteString(date.Format("Jan"))
+			case "MM":
+				sb.WriteString(date.Format("01"))
+			case "M":
+				sb.WriteString(date.Format("1"))
+			case "do":
+				sb.WriteString(ordinal(date.Day()))
+			case "dd":
+				sb.WriteString(date.Format("02"))
+			case "d":
+				sb.WriteString(date.Format("2"))
+			case "EEEE":
+				sb.WriteString(date.Format("Monday"))
+			case "EEE", "E":
+				sb.WriteString(date.Format("Mon"))
+			}
+			format = format[len(token):]
+			continue outer
+		}
+		sb.WriteByte(format[0])
+		format = format[1:]
+	}
+	return sb.String()
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
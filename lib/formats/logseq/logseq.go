@@ -0,0 +1,386 @@
+package logseq
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+const (
+	// configFile is the file which marks a directory as a Logseq graph.
+	configFile  = "logseq/config.edn"
+	pagesDir    = "pages"
+	journalsDir = "journals"
+	assetsDir   = "assets"
+	// maxRefDepth limits how many block references inside of referenced
+	// blocks are resolved, since blocks may refer to each other.
+	maxRefDepth = 5
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "logseq",
+		Description: "Logseq graph",
+		Documentation: `This format corresponds to a Logseq graph, which is a directory with
+pages, journals, and assets subdirectories and a logseq/config.edn file. Only
+Markdown pages are read.
+
+Pages are named after their titles, with namespaces like "a/b" becoming
+directories, and journal pages become notes named after their dates in the
+journals directory. [[Page]] references are converted into links, or into
+plain text when the page has no file, ((block)) references and embeds are
+replaced with the text of the block, and block properties such as id:: are
+removed. The title and tags of each
+page are available as its metadata, with the other page properties in the
+extra metadata.
+
+If the path does not exist, a new graph is created once the conversion
+finishes; an existing graph can only be read. Each paragraph, heading, or
+other block of the notes becomes a block in the outline, and nested lists
+become nested blocks. Links between notes become page references, and
+attachments are placed in the assets directory. Notes in the journals
+directory which are named after a date, like journals/2021-06-01.md, become
+journal pages. The tags of each note become a tags:: page property.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// pageProps holds the properties of a page which determine its name and
+// metadata.
+type pageProps struct {
+	// Title is the title of the page, which is how other pages refer to it.
+	Title   string
+	Aliases []string
+	Tags    []string
+	// Journal is the date of a journal page, and zero for other pages.
+	Journal time.Time
+	// Properties holds all of the page properties, in order.
+	Properties []Property
+}
+
+var (
+	blockRefPattern  = regexp.MustCompile(`\{\{embed \(\(([0-9a-fA-F-]{36})\)\)\}\}|\(\(([0-9a-fA-F-]{36})\)\)`)
+	pageEmbedPattern = regexp.MustCompile(`\{\{embed (\[\[[^\]]+\]\])\}\}`)
+	// aliasPattern matches the destination of a [label]([[Page]]) link, which
+	// must be wrapped in angle brackets if the title contains spaces.
+	aliasPattern = regexp.MustCompile(`\]\((\[\[[^\]]+\]\])\)`)
+)
+
+// Database is a database loaded from a Logseq graph.
+type Database struct {
+	*mem.Database
+	// sources maps the path of each note to the path in the graph which it
+	// was created from. Links in the note are relative to this path.
+	sources map[string]string
+	// paths maps the paths in the graph to the paths in the database.
+	paths map[string]string
+	// titles maps the lowercase titles and aliases of the pages to their
+	// paths in the graph.
+	titles map[string]string
+	// blocks maps the lowercase ids of blocks to their text.
+	blocks map[string]string
+	// errs holds the problems encountered while converting each note, which
+	// are reported when the note is parsed.
+	errs map[string]error
+}
+
+// OpenDatabase is the entrypoint for the Logseq format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	info, err := os.Stat(dbURL.Path)
+	if os.IsNotExist(err) {
+		return newWriter(dbURL.Path), nil
+	} else if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a Logseq graph", dbURL.Path)
+	}
+	titleFormat := defaultTitleFormat
+	config, err := os.ReadFile(filepath.Join(dbURL.Path, filepath.FromSlash(configFile)))
+	if err == nil {
+		titleFormat = readTitleFormat(config)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db := &Database{
+		Database: mem.New(),
+		sources:  map[string]string{},
+		paths:    map[string]string{},
+		titles:   map[string]string{},
+		blocks:   map[string]string{},
+		errs:     map[string]error{},
+	}
+	if err := db.load(fs.DirFS(dbURL.Path), titleFormat); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a Logseq graph.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	info, err := os.Stat(filepath.Join(dbURL.Path, filepath.FromSlash(configFile)))
+	if err == nil && !info.IsDir() {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+// page is a page which has been read from the graph but not yet added to the
+// database.
+type page struct {
+	source  string
+	outline *outline
+	modTime time.Time
+	props   *pageProps
+}
+
+func (db *Database) load(graph fs.FS, titleFormat string) error {
+	used := map[string]bool{}
+	var pages []*page
+	for _, dir := range []string{assetsDir, pagesDir, journalsDir} {
+		err := fs.WalkDir(graph, dir, func(p string, d fs.DirEntry, err error) error {
+			if p == dir && os.IsNotExist(err) {
+				return nil
+			} else if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+				return err
+			}
+			data, err := fs.ReadFile(graph, p)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if dir == assetsDir {
+				used[strings.ToLower(p)] = true
+				db.paths[p] = p
+				return db.WriteFile(p, data, info.ModTime())
+			} else if path.Ext(p) != ".md" {
+				return nil
+			}
+			pg := &page{p, parseOutline(data), info.ModTime(), nil}
+			pg.props = pageProperties(pg, dir == journalsDir, titleFormat)
+			pages = append(pages, pg)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pg := range pages {
+		var name string
+		if !pg.props.Journal.IsZero() {
+			name = path.Join(journalsDir, pg.props.Journal.Format(journalNoteLayout)+".md")
+		} else {
+			parts := strings.Split(pg.props.Title, "/")
+			for i, part := range parts {
+				if parts[i] = notedb.EscapeName(part); parts[i] == "" {
+					parts[i] = "Untitled"
+				}
+			}
+			name = strings.Join(parts, "/") + ".md"
+		}
+		name = notedb.UniqueName(name, used)
+		db.sources[name] = pg.source
+		db.paths[pg.source] = name
+		for _, title := range append([]string{pg.props.Title}, pg.props.Aliases...) {
+			if _, ok := db.titles[strings.ToLower(title)]; !ok {
+				db.titles[strings.ToLower(title)] = pg.source
+			}
+		}
+		for id, text := range pg.outline.blocks {
+			db.blocks[id] = text
+		}
+	}
+
+	for _, pg := range pages {
+		name := db.paths[pg.source]
+		text := pageEmbedPattern.ReplaceAllString(strings.Join(pg.outline.lines, "\n"), "$1")
+		text = aliasPattern.ReplaceAllString(text, "](<$1>)")
+		text, err := db.resolveBlockRefs(text, 0)
+		if err != nil {
+			db.errs[name] = err
+		}
+		if err := db.WriteFile(name, []byte(text), pg.modTime); err != nil {
+			return err
+		}
+		metadata := notedb.Metadata{
+			Title:   pg.props.Title,
+			Tags:    pg.props.Tags,
+			Updated: pg.modTime,
+			Extra:   map[string]interface{}{},
+		}
+		for _, prop := range pg.props.Properties {
+			if prop.Key != "title" && prop.Key != "tags" {
+				metadata.Extra[prop.Key] = prop.Value
+			}
+		}
+		if err := db.SetMetadata(name, metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageProperties determines the title and other properties of the page.
+func pageProperties(pg *page, journal bool, titleFormat string) *pageProps {
+	props := &pageProps{
+		Title:      pg.outline.property("title"),
+		Aliases:    splitPageList(pg.outline.property("alias")),
+		Tags:       splitPageList(pg.outline.property("tags")),
+		Properties: pg.outline.props,
+	}
+	stem := strings.TrimSuffix(path.Base(pg.source), ".md")
+	if journal {
+		if date, err := time.Parse(journalFileLayout, stem); err == nil {
+			props.Journal = date
+			if props.Title == "" {
+				props.Title = formatTitle(date, titleFormat)
+			}
+		}
+	}
+	if props.Title == "" {
+		props.Title = decodeFileName(stem)
+	}
+	return props
+}
+
+// decodeFileName converts the name of a page file into the title of the
+// page. Logseq writes namespace separators as "___" and escapes other
+// reserved characters with URL encoding.
+func decodeFileName(stem string) string {
+	stem = strings.ReplaceAll(stem, "___", "/")
+	if decoded, err := url.PathUnescape(stem); err == nil {
+		return decoded
+	}
+	return stem
+}
+
+// resolveBlockRefs replaces the block references and block embeds in the
+// text with the text of the blocks.
+func (db *Database) resolveBlockRefs(text string, depth int) (string, error) {
+	var err error
+	text = blockRefPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := blockRefPattern.FindStringSubmatch(match)
+		id := m[1] + m[2]
+		found, ok := db.blocks[strings.ToLower(id)]
+		if !ok {
+			err = multierr.Append(err, fmt.Errorf("missing block reference: ((%s))", id))
+			return match
+		}
+		if depth < maxRefDepth {
+			var refErr error
+			found, refErr = db.resolveBlockRefs(found, depth+1)
+			err = multierr.Append(err, refErr)
+		}
+		return found
+	})
+	return text, err
+}
+
+// pageReference returns the title of the page which a destination such as
+// [[Page]] refers to.
+func pageReference(dest []byte) (string, bool) {
+	title := string(dest)
+	if !strings.HasPrefix(title, "[[") || !strings.HasSuffix(title, "]]") {
+		return "", false
+	}
+	return title[2 : len(title)-2], true
+}
+
+// unlinkMissingPages replaces the links to pages which have no file with
+// their labels. Logseq creates a page for every reference, so these are
+// not dead links, but there is no note for them to point to.
+func (db *Database) unlinkMissingPages(doc ast.Node) {
+	var links []*ast.Link
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*ast.Link); ok && entering {
+			if title, ok := pageReference(link.Destination); ok {
+				if _, ok := db.titles[strings.ToLower(title)]; !ok {
+					links = append(links, link)
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, link := range links {
+		parent := link.Parent()
+		for child := link.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			parent.InsertBefore(parent, link, child)
+			child = next
+		}
+		parent.RemoveChild(parent, link)
+	}
+}
+
+// resolveLink converts a link in the note into a link to the corresponding
+// file in the database. Links which do not point to a file in the graph are
+// not modified. Logseq also allows [label]([[Page]]) links.
+func (db *Database) resolveLink(notePath string, dest []byte) ([]byte, error) {
+	source := db.sources[notePath]
+	if title, ok := pageReference(dest); ok {
+		target, ok := db.titles[strings.ToLower(title)]
+		if !ok {
+			return dest, nil
+		}
+		return notedb.RelativeLink(notePath, db.paths[target], ""), nil
+	}
+	target, fragment, ok := notedb.LocalLink(source, dest)
+	if !ok {
+		return dest, nil
+	}
+	found, ok := db.paths[target]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return notedb.RelativeLink(notePath, found, fragment), nil
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &pageFile{f.(mem.File), db, name}, nil
+}
+
+type pageFile struct {
+	mem.File
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*pageFile)(nil)
+var _ fs.ReadDirFile = (*pageFile)(nil)
+
+func (f *pageFile) ParseAST() (ast.Node, error) {
+	err := f.db.errs[f.path]
+	doc, parseErr := parser.Parse(f.Data(), parser.WikiLinks(func(target string) []byte {
+		return []byte("[[" + target + "]]")
+	}))
+	if parseErr != nil {
+		return nil, multierr.Append(err, parseErr)
+	}
+	f.db.unlinkMissingPages(doc)
+	return doc, multierr.Append(err, notedb.MapLinks(doc, func(dest []byte) ([]byte, error) {
+		return f.db.resolveLink(f.path, dest)
+	}))
+}
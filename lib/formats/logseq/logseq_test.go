@@ -0,0 +1,89 @@
+package logseq
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func writeGraph(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		dest := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0777))
+		require.NoError(t, os.WriteFile(dest, []byte(data), 0666))
+	}
+	return root
+}
+
+func TestRead(t *testing.T) {
+	root := writeGraph(t, map[string]string{
+		"logseq/config.edn": "{:journal/page-title-format \"MMM do, yyyy\"}\n",
+		"pages/Project___Alpha.md": "alias:: Alpha\ntags:: [[work]], planning\n\n" +
+			"- Goals\n\t- Ship it\n\t  id:: 64a0b1c2-0000-4000-8000-000000000001\n\t  collapsed:: true\n",
+		"pages/Home.md": "- See [[alpha]] and ![pic](../assets/pic.png)\n" +
+			"- Next: ((64a0b1c2-0000-4000-8000-000000000001))\n",
+		"journals/2021_06_01.md": "- Met about [the project]([[Project/Alpha]]) and [it]([[Project Beta]]) on [[Jun 1st, 2021]] with [[Carol]]\n",
+		"assets/pic.png":         "png",
+		"pages/Project Beta.md":  "- Beta\n",
+	})
+	dbURL, err := notedb.ResolveURL(root)
+	require.NoError(t, err)
+	require.Equal(t, "logseq", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".", "Home.md", "Project", "Project/Alpha.md", "Project Beta.md", "assets", "assets/pic.png",
+		"journals", "journals/2021-06-01.md",
+	}, notedbtest.ListPaths(t, db))
+
+	require.Equal(t, "- Goals\n  - Ship it\n", notedbtest.RenderNote(t, db, "Project/Alpha.md"))
+	require.Equal(t, "- See [alpha](Project/Alpha.md) and ![pic](assets/pic.png)\n- Next: Ship it\n",
+		notedbtest.RenderNote(t, db, "Home.md"))
+	require.Equal(t, "- Met about [the project](../Project/Alpha.md) and [it](../Project%20Beta.md) on [Jun 1st, 2021](2021-06-01.md) with Carol\n",
+		notedbtest.RenderNote(t, db, "journals/2021-06-01.md"))
+
+	f, err := db.Open("Project/Alpha.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, "Project/Alpha", metadata.Title)
+	require.Equal(t, []string{"work", "planning"}, metadata.Tags)
+	require.Equal(t, "Alpha", metadata.Extra["alias"])
+}
+
+func TestWriter(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "graph")
+	db, err := OpenDatabase(&url.URL{Scheme: "logseq", Path: root})
+	require.NoError(t, err)
+	require.NoError(t, fs.MkdirAll(db, "Project", 0777))
+	require.NoError(t, fs.MkdirAll(db, "journals", 0777))
+	notedbtest.WriteFile(t, db, "Project/Alpha.md", "# Alpha\n\nSome text.\n\n- One\n  - Nested\n- Two\n")
+	notedbtest.WriteFile(t, db, "journals/2021-06-01.md", "Worked on [Alpha](../Project/Alpha.md).\n\n![pic](../pic.png)\n")
+	notedbtest.WriteFile(t, db, "pic.png", "png")
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	data, err := os.ReadFile(filepath.Join(root, "pages", "Project___Alpha.md"))
+	require.NoError(t, err)
+	require.Equal(t, "- # Alpha\n- Some text.\n- One\n\t- Nested\n- Two\n", string(data))
+	data, err = os.ReadFile(filepath.Join(root, "journals", "2021_06_01.md"))
+	require.NoError(t, err)
+	require.Equal(t, "- Worked on [Alpha]([[Project/Alpha]]).\n- ![pic](../assets/pic.png)\n", string(data))
+	_, err = os.Stat(filepath.Join(root, "assets", "pic.png"))
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(root, "logseq", "config.edn"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), ":file/name-format :triple-lowbar")
+
+	// The graph which was written can be read back.
+	read, err := OpenDatabase(&url.URL{Scheme: "logseq", Path: root})
+	require.NoError(t, err)
+	require.Equal(t, "- Worked on [Alpha](../Project/Alpha.md).\n- ![pic](../assets/pic.png)\n",
+		notedbtest.RenderNote(t, read, "journals/2021-06-01.md"))
+}
@@ -0,0 +1,131 @@
+package logseq
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// propertyPattern matches a property line, like "tags:: a, b".
+	propertyPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_-]*):: ?(.*)$`)
+	// bulletPattern matches the first line of a block.
+	bulletPattern = regexp.MustCompile(`^([\t ]*)-(?: (.*))?$`)
+)
+
+// Property is a single property of a page.
+type Property struct {
+	Key   string
+	Value string
+}
+
+// outline is a page from the graph with its properties separated from its
+// content.
+type outline struct {
+	// props are the properties of the page.
+	props []Property
+	// lines is the content of the page, without any block properties.
+	lines []string
+	// blocks maps the ids of the blocks in the page to their text.
+	blocks map[string]string
+}
+
+// parseOutline reads a page. Page properties are either the first lines of
+// the file, or the first block if it contains nothing but properties. Block
+// properties are the lines following the first line of a block; they are
+// removed, but the ids are recorded so that references to the blocks can be
+// resolved.
+func parseOutline(data []byte) *outline {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	o := &outline{blocks: map[string]string{}}
+	i := 0
+	for ; i < len(lines); i++ {
+		m := propertyPattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		o.props = append(o.props, Property{m[1], strings.TrimSpace(m[2])})
+	}
+	if i == 0 && len(lines) > 0 {
+		if m := bulletPattern.FindStringSubmatch(lines[0]); m != nil && m[1] == "" {
+			if p := propertyPattern.FindStringSubmatch(m[2]); p != nil {
+				props := []Property{{p[1], strings.TrimSpace(p[2])}}
+				j := 1
+				for ; j < len(lines) && isIndented(lines[j]); j++ {
+					q := propertyPattern.FindStringSubmatch(strings.TrimSpace(lines[j]))
+					if q == nil {
+						break
+					}
+					props = append(props, Property{q[1], strings.TrimSpace(q[2])})
+				}
+				if j == len(lines) || strings.TrimSpace(lines[j]) == "" || bulletPattern.MatchString(lines[j]) {
+					o.props = props
+					i = j
+				}
+			}
+		}
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+
+	var text, id string
+	finish := func() {
+		if id != "" {
+			o.blocks[strings.ToLower(id)] = text
+		}
+		text, id = "", ""
+	}
+	inBlock, inFence := false, false
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		content := strings.TrimSpace(line)
+		if m := bulletPattern.FindStringSubmatch(line); m != nil && !inFence {
+			finish()
+			inBlock = true
+			text = strings.TrimSpace(m[2])
+			content = text
+		} else if inBlock && !inFence {
+			if p := propertyPattern.FindStringSubmatch(content); p != nil {
+				if strings.ToLower(p[1]) == "id" {
+					id = strings.TrimSpace(p[2])
+				}
+				continue
+			}
+		}
+		if strings.HasPrefix(content, "```") {
+			inFence = !inFence
+		}
+		o.lines = append(o.lines, line)
+	}
+	finish()
+	return o
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// property returns the value of the named property.
+func (o *outline) property(key string) string {
+	for _, p := range o.props {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// splitPageList splits the value of a property which holds a list of pages,
+// like "tags:: [[a b]], c, #d".
+func splitPageList(value string) []string {
+	var ret []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		item = strings.TrimPrefix(item, "#")
+		item = strings.TrimSuffix(strings.TrimPrefix(item, "[["), "]]")
+		if item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,237 @@
+package logseq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// configEDN is the contents of logseq/config.edn for the graphs which are
+// written. Journal pages are titled with their dates, which matches the
+// names of journal notes in the database, and file names use "___" as the
+// namespace separator, as fileNameReplacer does.
+const configEDN = `{:preferred-format :markdown
+ :file/name-format :triple-lowbar
+ :journal/page-title-format "yyyy-MM-dd"}
+`
+
+// writtenTitleFormat is the journal title format used by configEDN.
+const writtenTitleFormat = "yyyy-MM-dd"
+
+var journalNotePattern = regexp.MustCompile(`^journals/(\d{4}-\d{2}-\d{2})\.md$`)
+
+// fileNameReplacer escapes a page title for use as a file name, reversing
+// decodeFileName.
+var fileNameReplacer = strings.NewReplacer("%", "%25", "/", "___")
+
+// Writer is a database which holds the written notes in memory and produces
+// a Logseq graph when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+func newWriter(path string) *Writer {
+	return &Writer{mem.New(), path}
+}
+
+// target is the location in the graph of a file from the database.
+type target struct {
+	// file is the path in the graph.
+	file string
+	// title is the title of the page, and empty for assets.
+	title string
+}
+
+// Close writes the graph.
+func (w *Writer) Close() error {
+	targets := map[string]target{}
+	usedAssets := map[string]bool{}
+	var order []string
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		order = append(order, p)
+		if !strings.HasSuffix(p, ".md") {
+			targets[p] = target{path.Join(assetsDir, notedb.UniqueName(path.Base(p), usedAssets)), ""}
+			return nil
+		}
+		if m := journalNotePattern.FindStringSubmatch(p); m != nil {
+			if date, err := time.Parse(journalNoteLayout, m[1]); err == nil {
+				file := path.Join(journalsDir, date.Format(journalFileLayout)+".md")
+				targets[p] = target{file, formatTitle(date, writtenTitleFormat)}
+				return nil
+			}
+		}
+		title := strings.TrimSuffix(p, ".md")
+		targets[p] = target{path.Join(pagesDir, fileNameReplacer.Replace(title)+".md"), title}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, p := range order {
+		t := targets[p]
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if t.title != "" {
+			var convertErr error
+			data, convertErr = writeOutline(p, data, targets)
+			for _, err := range multierr.Errors(convertErr) {
+				errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: err})
+			}
+			if metadata, err := w.Metadata(p); err == nil && len(metadata.Tags) > 0 {
+				data = append([]byte("tags:: "+strings.Join(metadata.Tags, ", ")+"\n"), data...)
+			}
+		}
+		errs = multierr.Append(errs, mem.WriteHostFile(w.path, t.file, data, w.ModTime(p)))
+	}
+	return multierr.Append(errs, mem.WriteHostFile(w.path, configFile, []byte(configEDN), time.Now()))
+}
+
+// writeOutline converts the note into an outline. Links to other notes are
+// replaced with page references, and links to attachments are updated to
+// point into the assets directory.
+func writeOutline(notePath string, data []byte, targets map[string]target) ([]byte, error) {
+	doc, err := parser.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	file := targets[notePath].file
+
+	type replacement struct {
+		node ast.Node
+		text string
+	}
+	var replacements []replacement
+	replaceLink := func(n ast.Node, dest []byte) []byte {
+		source, _, ok := notedb.LocalLink(notePath, dest)
+		if !ok {
+			return dest
+		}
+		t, ok := targets[source]
+		if !ok {
+			err = multierr.Append(err, fmt.Errorf("dead link: %s", dest))
+			return dest
+		}
+		if t.title == "" {
+			return []byte(notedb.RelativePath(path.Dir(file), t.file))
+		}
+		if label := string(n.Text(data)); label == "" || label == t.title {
+			replacements = append(replacements, replacement{n, "[[" + t.title + "]]"})
+		} else {
+			replacements = append(replacements, replacement{n, "[" + label + "]([[" + t.title + "]])"})
+		}
+		return dest
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = replaceLink(n, n.Destination)
+		case *ast.Image:
+			n.Destination = replaceLink(n, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+	if walkErr != nil {
+		return nil, multierr.Append(err, walkErr)
+	}
+	for _, r := range replacements {
+		parent := r.node.Parent()
+		parent.ReplaceChild(parent, r.node, ast.NewString([]byte(r.text)))
+	}
+
+	var buf bytes.Buffer
+	err = multierr.Append(err, writeBlocks(&buf, data, doc, 0))
+	return buf.Bytes(), err
+}
+
+// writeBlocks writes each child of the node as a block at the given depth.
+func writeBlocks(buf *bytes.Buffer, source []byte, parent ast.Node, depth int) error {
+	var err error
+	for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+		if list, ok := child.(*ast.List); ok {
+			err = multierr.Append(err, writeList(buf, source, list, depth))
+		} else {
+			err = multierr.Append(err, writeBlock(buf, source, []ast.Node{child}, depth))
+		}
+	}
+	return err
+}
+
+// writeList writes each item of the list as a block at the given depth. The
+// content of the item before the first nested list is the text of the block,
+// and everything after it becomes children of the block.
+func writeList(buf *bytes.Buffer, source []byte, list *ast.List, depth int) error {
+	var err error
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		var content, children []ast.Node
+		for c := item.FirstChild(); c != nil; c = c.NextSibling() {
+			if _, ok := c.(*ast.List); ok || len(children) > 0 {
+				children = append(children, c)
+			} else {
+				content = append(content, c)
+			}
+		}
+		err = multierr.Append(err, writeBlock(buf, source, content, depth))
+		for _, c := range children {
+			if nested, ok := c.(*ast.List); ok {
+				err = multierr.Append(err, writeList(buf, source, nested, depth+1))
+			} else {
+				err = multierr.Append(err, writeBlock(buf, source, []ast.Node{c}, depth+1))
+			}
+		}
+	}
+	return err
+}
+
+// writeBlock renders the nodes as the content of a single block.
+func writeBlock(buf *bytes.Buffer, source []byte, nodes []ast.Node, depth int) error {
+	var content bytes.Buffer
+	var err error
+	for i, n := range nodes {
+		var rendered bytes.Buffer
+		err = multierr.Append(err, renderer.NewRenderer().Render(&rendered, source, n))
+		if i > 0 {
+			content.WriteString("\n\n")
+		}
+		content.Write(bytes.Trim(rendered.Bytes(), "\n"))
+	}
+	indent := strings.Repeat("\t", depth)
+	for i, line := range strings.Split(content.String(), "\n") {
+		switch {
+		case i == 0 && line == "":
+			buf.WriteString(indent + "-\n")
+		case i == 0:
+			buf.WriteString(indent + "- " + line + "\n")
+		case line == "":
+			buf.WriteString("\n")
+		default:
+			buf.WriteString(indent + "  " + line + "\n")
+		}
+	}
+	return err
+}
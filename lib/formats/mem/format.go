@@ -0,0 +1,68 @@
+package mem
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "mem",
+		Description: "In-memory database",
+		Documentation: `This format holds the database in memory, and it is discarded when the
+program exits. A URL like mem://name refers to the same database every time
+it is opened by the program, which allows the output of one operation to be
+used by the next. The URL mem:// creates a new, empty database.`,
+		Open: OpenDatabase,
+	})
+}
+
+var named = struct {
+	sync.Mutex
+	dbs map[string]*Database
+}{dbs: map[string]*Database{}}
+
+// OpenDatabase is the entrypoint for the mem format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	if dbURL.Host == "" {
+		return New(), nil
+	}
+	named.Lock()
+	defer named.Unlock()
+	db, ok := named.dbs[dbURL.Host]
+	if !ok {
+		db = New()
+		named.dbs[dbURL.Host] = db
+	}
+	return db, nil
+}
+
+// Register makes the database available at the URL mem://name, replacing any
+// database which was previously there.
+func Register(name string, db *Database) {
+	named.Lock()
+	defer named.Unlock()
+	named.dbs[name] = db
+}
+
+// FromMap creates a database holding the given files, which are keyed by
+// path. Parent directories are created as needed, and all files have the
+// given modification time.
+func FromMap(files map[string]string, modTime time.Time) (*Database, error) {
+	db := New()
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := db.WriteFile(p, []byte(files[p]), modTime); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
@@ -0,0 +1,426 @@
+// Package mem provides a note database which is held entirely in memory. It
+// is used by formats which need to assemble a directory tree before it can be
+// read, or which write their output only once all of the files are known. It
+// is also available as the mem format, which is useful for tests.
+package mem
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+)
+
+// Database is an in-memory note database.
+type Database struct {
+	mu       sync.Mutex
+	root     *entry
+	readOnly bool
+}
+
+var _ fs.MkdirAllFS = (*Database)(nil)
+var _ fs.OpenFileFS = (*Database)(nil)
+var _ fs.ChtimesFS = (*Database)(nil)
+
+// New creates an empty database.
+func New() *Database {
+	return &Database{root: newDir("", time.Now())}
+}
+
+// SetReadOnly prevents the files of the database from being changed through
+// the file system interface, so that opening a file for writing, creating a
+// directory, or changing a modification time fails with fs.ErrPermission.
+// Formats which load a database into memory to read it use this, since
+// anything written to it would be discarded. The methods which formats use to
+// fill the database, such as WriteFile, are not affected.
+func (db *Database) SetReadOnly() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readOnly = true
+}
+
+// writeFlags are the flags to OpenFile which allow the file to be changed.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+type entry struct {
+	name     string
+	modTime  time.Time
+	data     []byte
+	items    map[string]*entry
+	metadata notedb.Metadata
+}
+
+func newDir(name string, modTime time.Time) *entry {
+	return &entry{name: name, modTime: modTime, items: map[string]*entry{}}
+}
+
+func (e *entry) isDir() bool { return e.items != nil }
+
+func (e *entry) isNote() bool { return !e.isDir() && strings.HasSuffix(e.name, ".md") }
+
+// sortedItems returns the items of the directory ordered by name.
+func (e *entry) sortedItems() []*entry {
+	ret := make([]*entry, 0, len(e.items))
+	for _, item := range e.items {
+		ret = append(ret, item)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+func (e *entry) info() *noteInfo {
+	mode := fs.FileMode(0644)
+	if e.isDir() {
+		mode = fs.ModeDir | 0755
+	}
+	return &noteInfo{e.name, int64(len(e.data)), mode, e.modTime, e.isNote()}
+}
+
+func splitPath(op, name string) ([]string, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, nil
+	}
+	return strings.Split(name, "/"), nil
+}
+
+// lookup finds the entry at the given path. The caller must hold the lock.
+func (db *Database) lookup(op, name string) (*entry, error) {
+	components, err := splitPath(op, name)
+	if err != nil {
+		return nil, err
+	}
+	e := db.root
+	for _, component := range components {
+		if !e.isDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+		}
+		next, ok := e.items[component]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		e = next
+	}
+	return e, nil
+}
+
+// mkdirAll creates the directory and its parents. The caller must hold the
+// lock.
+func (db *Database) mkdirAll(name string) (*entry, error) {
+	components, err := splitPath("mkdir", name)
+	if err != nil {
+		return nil, err
+	}
+	e := db.root
+	for _, component := range components {
+		next, ok := e.items[component]
+		if !ok {
+			next = newDir(component, time.Now())
+			e.items[component] = next
+		} else if !next.isDir() {
+			return nil, &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+		e = next
+	}
+	return e, nil
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	return db.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile satisfies fs.OpenFileFS.
+func (db *Database) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.readOnly && flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	e, err := db.lookup("open", name)
+	if err != nil && flag&os.O_CREATE != 0 {
+		dir, base := path.Split(name)
+		parentPath := "."
+		if dir != "" {
+			parentPath = strings.TrimSuffix(dir, "/")
+		}
+		parent, err := db.lookup("open", parentPath)
+		if err != nil {
+			return nil, err
+		}
+		if !parent.isDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		e = &entry{name: base, modTime: time.Now(), data: []byte{}}
+		parent.items[base] = e
+	} else if err != nil {
+		return nil, err
+	} else if flag&os.O_TRUNC != 0 && !e.isDir() {
+		e.data = []byte{}
+	}
+	return &handle{e, db, 0, nil}, nil
+}
+
+// MkdirAll satisfies fs.MkdirAllFS.
+func (db *Database) MkdirAll(name string, perm fs.FileMode) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.readOnly {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+	}
+	_, err := db.mkdirAll(name)
+	return err
+}
+
+// Chtimes satisfies fs.ChtimesFS.
+func (db *Database) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.readOnly {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+	}
+	e, err := db.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	e.modTime = mtime
+	return nil
+}
+
+// WriteFile creates or replaces the file at the given path, creating any
+// parent directories which do not exist.
+func (db *Database) WriteFile(name string, data []byte, modTime time.Time) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	dir, base := path.Split(name)
+	parent := db.root
+	if dir != "" {
+		var err error
+		parent, err = db.mkdirAll(strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return err
+		}
+	}
+	if existing, ok := parent.items[base]; ok && existing.isDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	parent.items[base] = &entry{name: base, modTime: modTime, data: data}
+	return nil
+}
+
+// SetMetadata sets the metadata of the note, which is returned by the
+// Metadata method of its handles.
+func (db *Database) SetMetadata(name string, metadata notedb.Metadata) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.lookup("setmetadata", name)
+	if err != nil {
+		return err
+	}
+	e.metadata = copyMetadata(metadata)
+	return nil
+}
+
+// Metadata returns the metadata of the note. Writers use this to retrieve the
+// metadata which was written with the note.
+func (db *Database) Metadata(name string) (notedb.Metadata, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.lookup("metadata", name)
+	if err != nil {
+		return notedb.Metadata{}, err
+	}
+	return copyMetadata(e.metadata), nil
+}
+
+// copyMetadata returns a copy of the metadata with its own tags, location,
+// and extra metadata, so that the metadata held by the database cannot be
+// changed through the copy. The values of the extra metadata are shared.
+func copyMetadata(metadata notedb.Metadata) notedb.Metadata {
+	if metadata.Tags != nil {
+		metadata.Tags = append(make([]string, 0, len(metadata.Tags)), metadata.Tags...)
+	}
+	if metadata.Location != nil {
+		location := *metadata.Location
+		metadata.Location = &location
+	}
+	if metadata.Extra != nil {
+		extra := make(map[string]interface{}, len(metadata.Extra))
+		for k, v := range metadata.Extra {
+			extra[k] = v
+		}
+		metadata.Extra = extra
+	}
+	return metadata
+}
+
+// ModTime returns the modification time of the file, or the current time if
+// it does not exist.
+func (db *Database) ModTime(name string) time.Time {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.lookup("stat", name)
+	if err != nil {
+		return time.Now()
+	}
+	return e.modTime
+}
+
+// WriteHostFile writes a file below the root directory on the host, creating
+// any parent directories which do not exist, and sets its modification time.
+// Writers use this to produce their output from the files in a Database.
+func WriteHostFile(root, name string, data []byte, modTime time.Time) error {
+	dest := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dest, data, 0666); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, time.Now(), modTime)
+}
+
+// File is the set of interfaces implemented by the files of a Database which
+// formats wrapping a Database expose to readers.
+type File interface {
+	notedb.Note
+	fs.ReadDirFile
+}
+
+type handle struct {
+	*entry
+	db     *Database
+	cursor int
+	// items holds the directory entries which have not been returned by
+	// ReadDir yet, once it has been called.
+	items []*entry
+}
+
+var _ fs.ReadDirFile = (*handle)(nil)
+var _ fs.WriteFile = (*handle)(nil)
+var _ notedb.Note = (*handle)(nil)
+var _ notedb.WriteMetadataNote = (*handle)(nil)
+
+func (h *handle) Stat() (fs.FileInfo, error) {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	return h.info(), nil
+}
+
+func (h *handle) Read(ret []byte) (int, error) {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	if h.isDir() {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrInvalid}
+	}
+	if h.cursor >= len(h.data) {
+		return 0, io.EOF
+	}
+	count := copy(ret, h.data[h.cursor:])
+	h.cursor += count
+	return count, nil
+}
+
+func (h *handle) Write(p []byte) (int, error) {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	if h.db.readOnly {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrPermission}
+	} else if h.isDir() {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrInvalid}
+	}
+	h.data = append(h.data, p...)
+	h.modTime = time.Now()
+	return len(p), nil
+}
+
+func (h *handle) Close() error {
+	return nil
+}
+
+func (h *handle) ReadDir(n int) ([]fs.DirEntry, error) {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	if !h.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: h.name, Err: fs.ErrInvalid}
+	}
+	if h.items == nil {
+		h.items = h.sortedItems()
+	}
+	count := len(h.items)
+	if n > 0 && n < count {
+		count = n
+	} else if n > 0 && count == 0 {
+		return nil, io.EOF
+	}
+	ret := make([]fs.DirEntry, count)
+	for i, item := range h.items[:count] {
+		ret[i] = item.info()
+	}
+	h.items = h.items[count:]
+	return ret, nil
+}
+
+func (h *handle) IsNote() bool {
+	return h.isNote()
+}
+
+func (h *handle) ParseAST() (ast.Node, error) {
+	return parser.Parse(h.Data())
+}
+
+func (h *handle) Data() []byte {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	return h.data
+}
+
+func (h *handle) Metadata() (notedb.Metadata, error) {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	return copyMetadata(h.metadata), nil
+}
+
+func (h *handle) WriteMetadata(metadata notedb.Metadata) error {
+	h.db.mu.Lock()
+	defer h.db.mu.Unlock()
+	if h.db.readOnly {
+		return &fs.PathError{Op: "writemetadata", Path: h.name, Err: fs.ErrPermission}
+	}
+	h.metadata = copyMetadata(metadata)
+	return nil
+}
+
+type noteInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	isNote  bool
+}
+
+var _ notedb.NoteInfo = (*noteInfo)(nil)
+
+func (i *noteInfo) Name() string               { return i.name }
+func (i *noteInfo) Size() int64                { return i.size }
+func (i *noteInfo) Mode() fs.FileMode          { return i.mode }
+func (i *noteInfo) ModTime() time.Time         { return i.modTime }
+func (i *noteInfo) IsDir() bool                { return i.mode&fs.ModeDir != 0 }
+func (i *noteInfo) Sys() interface{}           { return nil }
+func (i *noteInfo) Type() fs.FileMode          { return i.mode.Type() }
+func (i *noteInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *noteInfo) IsNote() bool               { return i.isNote }
@@ -0,0 +1,101 @@
+package mem
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	seed, err := FromMap(map[string]string{
+		"Notebook/First.md": "# First\n",
+		"Notebook/a.png":    "PNG",
+		"Second.md":         "Second\n",
+	}, modTime)
+	require.NoError(t, err)
+	Register("test-database", seed)
+
+	db, err := notedb.OpenDatabase(&url.URL{Scheme: "mem", Host: "test-database"})
+	require.NoError(t, err)
+	require.Same(t, seed, db)
+
+	f, err := db.Open("Notebook/First.md")
+	require.NoError(t, err)
+	note, ok := f.(notedb.Note)
+	require.True(t, ok)
+	require.True(t, note.IsNote())
+	_, err = note.ParseAST()
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(modTime))
+
+	dst, err := fs.OpenFile(db, "Notebook/Third.md", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	require.NoError(t, err)
+	_, err = dst.(fs.WriteFile).Write([]byte("Third\n"))
+	require.NoError(t, err)
+	require.NoError(t, notedb.WriteMetadata(dst.(notedb.Note), notedb.Metadata{Tags: []string{"todo"}}))
+	require.NoError(t, dst.Close())
+	metadata, err := db.(*Database).Metadata("Notebook/Third.md")
+	require.NoError(t, err)
+	require.Equal(t, []string{"todo"}, metadata.Tags)
+	// The metadata which is returned is a copy.
+	metadata.Tags[0] = "changed"
+	metadata, err = dst.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"todo"}, metadata.Tags)
+	require.NoError(t, fs.Chtimes(db, "Notebook/Third.md", modTime, modTime))
+	require.NoError(t, fs.MkdirAll(db, "Empty/Nested", 0777))
+
+	dir, err := db.Open("Notebook")
+	require.NoError(t, err)
+	entries, err := dir.(fs.ReadDirFile).ReadDir(2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "First.md", entries[0].Name())
+	require.Equal(t, "Third.md", entries[1].Name())
+	entries, err = dir.(fs.ReadDirFile).ReadDir(2)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	_, err = dir.(fs.ReadDirFile).ReadDir(2)
+	require.Equal(t, io.EOF, err)
+
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Empty",
+		"Empty/Nested",
+		"Notebook",
+		"Notebook/First.md",
+		"Notebook/Third.md",
+		"Notebook/a.png",
+		"Second.md",
+	}, paths)
+
+	seed.SetReadOnly()
+	_, err = fs.OpenFile(db, "Second.md", os.O_WRONLY|os.O_TRUNC, 0666)
+	require.ErrorIs(t, err, fs.ErrPermission)
+	require.ErrorIs(t, fs.MkdirAll(db, "Other", 0777), fs.ErrPermission)
+	require.ErrorIs(t, fs.Chtimes(db, "Second.md", modTime, modTime), fs.ErrPermission)
+	data, err := fs.ReadFile(db, "Second.md")
+	require.NoError(t, err)
+	require.Equal(t, "Second\n", string(data))
+
+	empty, err := notedb.OpenDatabase(&url.URL{Scheme: "mem"})
+	require.NoError(t, err)
+	_, err = fs.Stat(empty, "Second.md")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
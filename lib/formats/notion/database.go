@@ -0,0 +1,177 @@
+package notion
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"gopkg.in/yaml.v2"
+)
+
+// table is a Notion database, exported as a CSV file. The first column holds
+// the title of each row.
+type table struct {
+	header []string
+	rows   [][]string
+	// pages holds the pages in the export which hold the content of each
+	// row, indexed by title.
+	pages map[string][]exportFile
+}
+
+// readTable parses the CSV file and locates the pages for its rows, which are
+// in a directory with the same name as the CSV file.
+func readTable(f exportFile, pages []exportFile) (*table, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(f.data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	t := &table{pages: map[string][]exportFile{}}
+	if len(records) > 0 {
+		t.header, t.rows = records[0], records[1:]
+	}
+	rowDir, _, _ := splitID(f.name)
+	for _, page := range pages {
+		if path.Dir(page.name) != rowDir {
+			continue
+		}
+		if _, title, ok := splitID(page.name); ok {
+			t.pages[title] = append(t.pages[title], page)
+		}
+	}
+	return t, nil
+}
+
+// page removes and returns the page for the row with the given title.
+func (t *table) page(title string) (exportFile, bool) {
+	found := t.pages[title]
+	if len(found) == 0 {
+		return exportFile{}, false
+	}
+	t.pages[title] = found[1:]
+	return found[0], true
+}
+
+// addTable adds a note holding the database as a Markdown table. The title of
+// each row links to the page for the row.
+func (db *Database) addTable(f exportFile, pages []exportFile) error {
+	t, err := readTable(f, pages)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if len(t.header) > 0 {
+		writeTableRow(&buf, t.header, len(t.header))
+		buf.WriteString("|")
+		for range t.header {
+			buf.WriteString(" --- |")
+		}
+		buf.WriteString("\n")
+	}
+	base := path.Dir(f.name)
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escapeCell(cell)
+		}
+		if len(row) > 0 {
+			if page, ok := t.page(row[0]); ok {
+				rel := strings.TrimPrefix(page.name, base+"/")
+				cells[0] = fmt.Sprintf("[%s](%s)", cells[0], (&url.URL{Path: rel}).String())
+			}
+		}
+		writeTableRow(&buf, cells, len(t.header))
+	}
+	return db.addNote(db.names.file(f.name), f.name, buf.Bytes(), f.modTime)
+}
+
+func writeTableRow(buf *bytes.Buffer, cells []string, width int) {
+	buf.WriteString("|")
+	for i := 0; i < width || i < len(cells); i++ {
+		buf.WriteString(" ")
+		if i < len(cells) {
+			buf.WriteString(cells[i])
+		}
+		buf.WriteString(" |")
+	}
+	buf.WriteString("\n")
+}
+
+var cellReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"|", "\\|",
+	"\r\n", " ",
+	"\n", " ",
+)
+
+func escapeCell(cell string) string {
+	return cellReplacer.Replace(cell)
+}
+
+// addRows adds a note for each row of the database, with the columns in the
+// front matter. Pages which are used for rows are removed from the returned
+// list of pages.
+func (db *Database) addRows(f exportFile, pages []exportFile) ([]exportFile, error) {
+	t, err := readTable(f, pages)
+	if err != nil {
+		return nil, err
+	}
+	rowDir, _, _ := splitID(f.name)
+	dir := db.names.dir(rowDir)
+	db.names.paths[f.name] = dir
+
+	used := map[string]bool{}
+	for _, row := range t.rows {
+		var title string
+		if len(row) > 0 {
+			title = row[0]
+		}
+		front := yaml.MapSlice{}
+		for i := 1; i < len(row) && i < len(t.header); i++ {
+			if row[i] != "" {
+				front = append(front, yaml.MapItem{Key: t.header[i], Value: row[i]})
+			}
+		}
+		var buf bytes.Buffer
+		if len(front) > 0 {
+			data, err := yaml.Marshal(front)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("---\n")
+			buf.Write(data)
+			buf.WriteString("---\n\n")
+		}
+
+		if page, ok := t.page(title); ok {
+			used[page.name] = true
+			buf.Write(page.data)
+			if err := db.addNote(db.names.file(page.name), page.name, buf.Bytes(), page.modTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name := notedb.EscapeName(title)
+		if name == "" {
+			name = "Untitled"
+		}
+		fmt.Fprintf(&buf, "# %s\n", title)
+		if err := db.addNote(path.Join(dir, db.names.unique(dir, name+".md")), f.name, buf.Bytes(), f.modTime); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining := pages[:0]
+	for _, page := range pages {
+		if !used[page.name] {
+			remaining = append(remaining, page)
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,150 @@
+package notion
+
+import (
+	"path"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+)
+
+// splitID parses the name of a file in the export. The key is the path of the
+// file without the extension or the _all suffix, which is also the path of the
+// directory holding the page's children, and title is the name of the page.
+func splitID(p string) (key, title string, ok bool) {
+	stem := strings.TrimSuffix(path.Base(p), path.Ext(p))
+	m := idPattern.FindStringSubmatch(stem)
+	if m == nil {
+		return "", "", false
+	}
+	return path.Join(path.Dir(p), strings.TrimSuffix(stem, m[3])), m[1], true
+}
+
+// namer assigns names in the database to the paths in the export. Pages with
+// the same title in the same directory have their IDs appended, like
+// deduplicateNames in the JEX format, and a page keeps the same name for its
+// note, its directory of children, and its database.
+type namer struct {
+	// paths maps the paths in the export to the paths in the database.
+	paths map[string]string
+	// stems maps the IDs of pages to their names, without extension.
+	stems map[string]string
+	// used holds the names used in each directory of the database.
+	used map[string]map[string]bool
+	// titles holds the IDs of the pages with each title, keyed by the
+	// directory in the export and the title.
+	titles map[string]map[string]bool
+}
+
+func newNamer() *namer {
+	return &namer{
+		paths:  map[string]string{},
+		stems:  map[string]string{},
+		used:   map[string]map[string]bool{},
+		titles: map[string]map[string]bool{},
+	}
+}
+
+// count records the titles of the pages which a file in the export belongs
+// to. All files must be counted before any names are allocated.
+func (n *namer) count(p string) {
+	stem := strings.TrimSuffix(path.Base(p), path.Ext(p))
+	for ; p != "."; p, stem = path.Dir(p), path.Base(path.Dir(p)) {
+		m := idPattern.FindStringSubmatch(stem)
+		if m == nil {
+			continue
+		}
+		key := titleKey(path.Dir(p), m[1])
+		if n.titles[key] == nil {
+			n.titles[key] = map[string]bool{}
+		}
+		n.titles[key][m[2]] = true
+	}
+}
+
+// titleKey identifies a title in a directory of the export.
+func titleKey(dir, title string) string {
+	return path.Join(dir, strings.ToLower(pageTitle(title)))
+}
+
+// pageTitle converts the title of a page into its name in the database.
+func pageTitle(title string) string {
+	if title = notedb.EscapeName(title); title == "" {
+		title = "Untitled"
+	}
+	return title
+}
+
+// lookup returns the path in the database of a file or directory in the
+// export.
+func (n *namer) lookup(p string) (string, bool) {
+	found, ok := n.paths[p]
+	return found, ok
+}
+
+// file returns the path in the database of a file in the export. Databases
+// are named as the note which holds the table.
+func (n *namer) file(p string) string {
+	if found, ok := n.paths[p]; ok {
+		return found
+	}
+	ext := path.Ext(p)
+	stem := strings.TrimSuffix(path.Base(p), ext)
+	if ext == ".csv" && idPattern.MatchString(stem) {
+		ext = ".md"
+	}
+	dir := n.dir(path.Dir(p))
+	name := path.Join(dir, n.name(path.Dir(p), dir, stem, ext))
+	n.paths[p] = name
+	return name
+}
+
+// dir returns the path in the database of a directory in the export.
+func (n *namer) dir(p string) string {
+	if p == "." {
+		return p
+	}
+	if found, ok := n.paths[p]; ok {
+		return found
+	}
+	dir := n.dir(path.Dir(p))
+	name := path.Join(dir, n.name(path.Dir(p), dir, path.Base(p), ""))
+	n.paths[p] = name
+	return name
+}
+
+// name allocates a name in the given database directory for the stem from the
+// given directory of the export.
+func (n *namer) name(exportDir, dir, stem, ext string) string {
+	used, ok := n.used[dir]
+	if !ok {
+		used = map[string]bool{}
+		n.used[dir] = used
+	}
+	m := idPattern.FindStringSubmatch(stem)
+	if m == nil {
+		return notedb.UniqueName(stem+ext, used)
+	}
+	if found, ok := n.stems[m[2]]; ok {
+		return found + ext
+	}
+	// The note and the directory of children need to be available together.
+	candidate := pageTitle(m[1])
+	if len(n.titles[titleKey(exportDir, m[1])]) > 1 || used[strings.ToLower(candidate)] || used[strings.ToLower(candidate+".md")] {
+		candidate = candidate + "-" + m[2]
+	}
+	used[strings.ToLower(candidate)] = true
+	used[strings.ToLower(candidate+".md")] = true
+	n.stems[m[2]] = candidate
+	return candidate + ext
+}
+
+// unique allocates a name in the given database directory for a file which is
+// not in the export.
+func (n *namer) unique(dir, name string) string {
+	used, ok := n.used[dir]
+	if !ok {
+		used = map[string]bool{}
+		n.used[dir] = used
+	}
+	return notedb.UniqueName(name, used)
+}
@@ -0,0 +1,270 @@
+package notion
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "notion-export",
+		Description: "Notion export (Markdown & CSV)",
+		Documentation: `This is the "Markdown & CSV" export format for Notion, which is a zip
+file. The zip file is read directly and does not need to be extracted.
+
+Notion adds a unique ID to the name of every page, which is removed, except
+for pages with the same name in the same directory, which keep the ID after a
+dash. Links between pages are updated to match.
+
+Notion databases are exported as CSV files. By default, each database becomes
+a note containing a Markdown table. Add ?databases=rows to the URL to instead
+create one note per row, with the columns of the row as front matter.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// idPattern matches the ID which Notion appends to file names. Some versions
+// of Notion also export a second CSV for each database, with an _all suffix.
+var idPattern = regexp.MustCompile(`^(.*?) ?([0-9a-f]{32})(_all)?$`)
+
+// Database is a read-only database loaded from a Notion export.
+type Database struct {
+	*mem.Database
+	// sources maps the path of each note to the path in the export which it
+	// was created from. Links in the note are relative to this path.
+	sources map[string]string
+	// names maps the paths in the export to the paths in the database.
+	names *namer
+}
+
+// OpenDatabase is the entrypoint for the Notion format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	var rows bool
+	switch mode := dbURL.Query().Get("databases"); mode {
+	case "", "table":
+	case "rows":
+		rows = true
+	default:
+		return nil, fmt.Errorf("unknown databases mode %#v", mode)
+	}
+
+	archive, err := zip.OpenReader(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	files, err := readZip(&archive.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{
+		Database: mem.New(),
+		sources:  map[string]string{},
+		names:    newNamer(),
+	}
+	if err := db.load(files, rows); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a Notion export, by looking
+// for the IDs in the names of the files in the zip.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if !strings.HasSuffix(dbURL.Path, ".zip") {
+		return notedb.DetectResultNegative
+	}
+	archive, err := zip.OpenReader(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if ext := path.Ext(f.Name); ext == ".md" || ext == ".csv" || ext == ".zip" {
+			if idPattern.MatchString(strings.TrimSuffix(path.Base(f.Name), ext)) {
+				return notedb.DetectResultPositive
+			}
+		}
+	}
+	return notedb.DetectResultNegative
+}
+
+type exportFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+// readZip reads all of the files in the zip. Large exports are split into
+// several zip files which are stored inside of the main one, so those are
+// read as well.
+func readZip(archive *zip.Reader) ([]exportFile, error) {
+	var files []exportFile
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !fs.ValidPath(f.Name) {
+			return nil, fmt.Errorf("invalid file name in zip: %#v", f.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", f.Name, err)
+		}
+		if path.Ext(f.Name) == ".zip" {
+			nested, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return nil, fmt.Errorf("while reading %s: %w", f.Name, err)
+			}
+			nestedFiles, err := readZip(nested)
+			if err != nil {
+				return nil, fmt.Errorf("while reading %s: %w", f.Name, err)
+			}
+			files = append(files, nestedFiles...)
+			continue
+		}
+		files = append(files, exportFile{f.Name, data, f.Modified})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+func (db *Database) load(files []exportFile, rows bool) error {
+	// Allocate names for the pages first, so that they get the names without
+	// numbers in preference to attachments.
+	for _, f := range files {
+		db.names.count(f.name)
+	}
+	for _, f := range files {
+		if _, _, ok := splitID(f.name); ok {
+			db.names.file(f.name)
+		}
+	}
+
+	tables := map[string]exportFile{}
+	var pages []exportFile
+	for _, f := range files {
+		switch path.Ext(f.name) {
+		case ".csv":
+			stem, _, ok := splitID(f.name)
+			if !ok {
+				break
+			}
+			// Prefer the _all variant, which includes every row.
+			if existing, found := tables[stem]; !found || len(f.name) > len(existing.name) {
+				tables[stem] = f
+			}
+			continue
+		case ".md":
+			pages = append(pages, f)
+			continue
+		}
+		if err := db.WriteFile(db.names.file(f.name), f.data, f.modTime); err != nil {
+			return err
+		}
+	}
+
+	tableNames := make([]string, 0, len(tables))
+	for stem := range tables {
+		tableNames = append(tableNames, stem)
+	}
+	sort.Strings(tableNames)
+	for _, stem := range tableNames {
+		var err error
+		if rows {
+			pages, err = db.addRows(tables[stem], pages)
+		} else {
+			err = db.addTable(tables[stem], pages)
+		}
+		if err != nil {
+			return fmt.Errorf("while reading %s: %w", tables[stem].name, err)
+		}
+	}
+
+	for _, f := range pages {
+		if err := db.addNote(db.names.file(f.name), f.name, f.data, f.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNote adds a note to the database. The source is the path in the export
+// which links in the note are relative to.
+func (db *Database) addNote(name, source string, data []byte, modTime time.Time) error {
+	if err := db.WriteFile(name, data, modTime); err != nil {
+		return err
+	}
+	db.sources[name] = source
+	return nil
+}
+
+// resolveLink converts a link in the note into a link to the corresponding
+// file in the database. Links which do not point to a file in the export are
+// not modified.
+func (db *Database) resolveLink(notePath string, dest []byte) ([]byte, error) {
+	source, ok := db.sources[notePath]
+	if !ok {
+		return dest, nil
+	}
+	target, fragment, ok := notedb.LocalLink(source, dest)
+	if !ok {
+		return dest, nil
+	}
+	found, ok := db.names.lookup(target)
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return notedb.RelativeLink(notePath, found, fragment), nil
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &notionFile{f.(mem.File), db, name}, nil
+}
+
+type notionFile struct {
+	mem.File
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*notionFile)(nil)
+var _ fs.ReadDirFile = (*notionFile)(nil)
+
+func (f *notionFile) ParseAST() (ast.Node, error) {
+	doc, err := parser.Parse(f.Data())
+	if err != nil {
+		return nil, err
+	}
+	return doc, multierr.Append(err, notedb.MapLinks(doc, func(dest []byte) ([]byte, error) {
+		return f.db.resolveLink(f.path, dest)
+	}))
+}
@@ -0,0 +1,120 @@
+package notion
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	idA = "0123456789abcdef0123456789abcdef"
+	idB = "11111111111111111111111111111111"
+	idC = "22222222222222222222222222222222"
+	idD = "33333333333333333333333333333333"
+	idE = "44444444444444444444444444444444"
+	idF = "55555555555555555555555555555555"
+)
+
+func writeZip(t *testing.T, files map[string]string) string {
+	zipPath := filepath.Join(t.TempDir(), "export.zip")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(zipPath, buf.Bytes(), 0644))
+	return zipPath
+}
+
+var exportFiles = map[string]string{
+	"Home " + idA + ".md":                          "# Home\n\nSee [Child](Home%20" + idA + "/Child%20" + idB + ".md) and [Tasks](Tasks%20" + idC + ".csv).\n",
+	"Home " + idA + "/Child " + idB + ".md":        "# Child\n\n![](Child%20" + idB + "/image.png)\n",
+	"Home " + idA + "/Child " + idB + "/image.png": "PNG",
+	"Tasks " + idC + ".csv":                        "\xef\xbb\xbfName,Status\nWrite,Done\nRead,\n",
+	"Tasks " + idC + "/Write " + idD + ".md":       "# Write\n\nStatus: Done\n",
+	"Other " + idE + ".md":                         "# Other\n",
+	"Other " + idE + "/Other " + idF + ".md":       "# Nested Other\n",
+}
+
+func TestReadTable(t *testing.T) {
+	db, err := OpenDatabase(&url.URL{Scheme: "notion-export", Path: writeZip(t, exportFiles)})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Home",
+		"Home/Child",
+		"Home/Child/image.png",
+		"Home/Child.md",
+		"Home.md",
+		"Other",
+		"Other/Other.md",
+		"Other.md",
+		"Tasks",
+		"Tasks/Write.md",
+		"Tasks.md",
+	}, notedbtest.ListPaths(t, db))
+
+	require.Equal(t, "# Home\n\nSee [Child](Home/Child.md) and [Tasks](Tasks.md).\n", notedbtest.RenderNote(t, db, "Home.md"))
+	require.Equal(t, "# Child\n\n![](Child/image.png)\n", notedbtest.RenderNote(t, db, "Home/Child.md"))
+	require.Equal(t, "| Name                    | Status |\n"+
+		"|-------------------------|--------|\n"+
+		"| [Write](Tasks/Write.md) | Done   |\n"+
+		"| Read                    |        |\n", notedbtest.RenderNote(t, db, "Tasks.md"))
+}
+
+func TestReadRows(t *testing.T) {
+	db, err := OpenDatabase(&url.URL{Scheme: "notion-export", Path: writeZip(t, exportFiles), RawQuery: "databases=rows"})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Home",
+		"Home/Child",
+		"Home/Child/image.png",
+		"Home/Child.md",
+		"Home.md",
+		"Other",
+		"Other/Other.md",
+		"Other.md",
+		"Tasks",
+		"Tasks/Read.md",
+		"Tasks/Write.md",
+	}, notedbtest.ListPaths(t, db))
+
+	require.Equal(t, "# Home\n\nSee [Child](Home/Child.md) and [Tasks](Tasks).\n", notedbtest.RenderNote(t, db, "Home.md"))
+	data, err := fs.ReadFile(db, "Tasks/Write.md")
+	require.NoError(t, err)
+	require.Equal(t, "---\nStatus: Done\n---\n\n# Write\n\nStatus: Done\n", string(data))
+	data, err = fs.ReadFile(db, "Tasks/Read.md")
+	require.NoError(t, err)
+	require.Equal(t, "# Read\n", string(data))
+}
+
+func TestDuplicateTitles(t *testing.T) {
+	db, err := OpenDatabase(&url.URL{Scheme: "notion-export", Path: writeZip(t, map[string]string{
+		"Notes " + idA + ".md":                   "See [the other](Notes%20" + idB + ".md).\n",
+		"Notes " + idA + "/Child " + idC + ".md": "Child\n",
+		"Notes " + idB + ".md":                   "Other\n",
+		"Single " + idD + ".md":                  "Single\n",
+	})})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notes-" + idA,
+		"Notes-" + idA + "/Child.md",
+		"Notes-" + idA + ".md",
+		"Notes-" + idB + ".md",
+		"Single.md",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "See [the other](Notes-"+idB+".md).\n", notedbtest.RenderNote(t, db, "Notes-"+idA+".md"))
+}
@@ -0,0 +1,245 @@
+package obsidian
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/file"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// configDir is the directory which marks a directory as an Obsidian vault.
+const configDir = ".obsidian"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "obsidian",
+		Description: "Obsidian vault",
+		Documentation: `This format corresponds to an Obsidian vault, which is a directory
+of Markdown files containing a .obsidian directory.
+
+When reading, [[Wiki Links]] and ![[embeds]] are resolved the same way
+Obsidian does, and are converted into standard Markdown links. When writing,
+links between files in the vault are converted into wikilinks, and
+attachments are moved to the attachment folder configured in
+.obsidian/app.json once the conversion finishes.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// Database is an Obsidian vault. It behaves like the file format, except for
+// the handling of links and attachments.
+type Database struct {
+	*file.Database
+	root string
+
+	mu      sync.Mutex
+	index   *vaultIndex
+	written map[string]bool
+}
+
+var _ fs.MkdirAllFS = (*Database)(nil)
+var _ fs.OpenFileFS = (*Database)(nil)
+var _ fs.ChtimesFS = (*Database)(nil)
+
+// OpenDatabase is the entrypoint for the Obsidian format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	db, err := file.OpenDatabase(dbURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{
+		Database: db.(*file.Database),
+		root:     dbURL.Path,
+		written:  map[string]bool{},
+	}, nil
+}
+
+// Detect determines if the URL is likely to be an Obsidian vault, by looking
+// for the configuration directory inside of it.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	info, err := os.Stat(filepath.Join(dbURL.Path, configDir))
+	if err == nil && info.IsDir() {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+func (db *Database) Open(path string) (fs.File, error) {
+	return db.OpenFile(path, os.O_RDONLY, 0)
+}
+
+func (db *Database) OpenFile(path string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		db.mu.Lock()
+		db.written[path] = true
+		db.index = nil
+		db.mu.Unlock()
+	}
+	f, err := db.Database.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &vaultFile{f.(fileHandle), db, path}, nil
+}
+
+func (db *Database) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return db.Database.Chtimes(name, atime, mtime)
+}
+
+// getIndex returns the index of the files in the vault, building it if
+// necessary.
+func (db *Database) getIndex() (*vaultIndex, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.index == nil {
+		index, err := buildIndex(db.Database)
+		if err != nil {
+			return nil, err
+		}
+		db.index = index
+	}
+	return db.index, nil
+}
+
+// isHidden reports whether the file is ignored by Obsidian. This includes the
+// configuration directory and the trash.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// vaultIndex records the locations of all of the files in the vault, so that
+// wikilinks can be resolved.
+type vaultIndex struct {
+	paths  map[string]bool
+	byName map[string][]string
+}
+
+func buildIndex(fsys fs.FS) (*vaultIndex, error) {
+	index := &vaultIndex{map[string]bool{}, map[string][]string{}}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != "." && isHidden(d.Name()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			index.paths[p] = true
+			index.byName[d.Name()] = append(index.byName[d.Name()], p)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// find locates the file referred to by the wikilink target, which has no
+// fragment. Obsidian allows the target to omit the .md extension of notes and
+// any leading directories, preferring the shortest path when ambiguous.
+func (i *vaultIndex) find(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	candidates := []string{name + ".md", name}
+	for _, candidate := range candidates {
+		if i.paths[candidate] {
+			return candidate, true
+		}
+	}
+	for _, candidate := range candidates {
+		best := ""
+		for _, p := range i.byName[path.Base(candidate)] {
+			if !strings.HasSuffix(p, "/"+candidate) {
+				continue
+			}
+			if best == "" || len(p) < len(best) {
+				best = p
+			}
+		}
+		if best != "" {
+			return best, true
+		}
+	}
+	return "", false
+}
+
+// linkName returns the shortest wikilink target which unambiguously refers
+// to the given file.
+func (i *vaultIndex) linkName(p string) string {
+	name := p
+	if len(i.byName[path.Base(p)]) == 1 {
+		name = path.Base(p)
+	}
+	return strings.TrimSuffix(name, ".md")
+}
+
+// fileHandle is the set of interfaces implemented by files in the file
+// format.
+type fileHandle interface {
+	notedb.WriteMetadataNote
+	fs.ReadDirFile
+	fs.WriteFile
+}
+
+type vaultFile struct {
+	fileHandle
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*vaultFile)(nil)
+var _ fs.ReadDirFile = (*vaultFile)(nil)
+var _ fs.WriteFile = (*vaultFile)(nil)
+
+func (f *vaultFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.fileHandle.ReadDir(n)
+	if err != nil {
+		return nil, err
+	}
+	ret := entries[:0]
+	for _, entry := range entries {
+		if !isHidden(entry.Name()) {
+			ret = append(ret, entry)
+		}
+	}
+	return ret, nil
+}
+
+func (f *vaultFile) ParseAST() (ast.Node, error) {
+	index, err := f.db.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(target string) []byte {
+		name, fragment := target, ""
+		if idx := strings.IndexByte(target, '#'); idx != -1 {
+			name, fragment = target[:idx], target[idx+1:]
+		}
+		if name == "" {
+			return []byte((&url.URL{Fragment: fragment}).String())
+		}
+		found, ok := index.find(name)
+		if !ok {
+			err = multierr.Append(err, fmt.Errorf("dead link: [[%s]]", target))
+			found = path.Join(path.Dir(f.path), name)
+			if path.Ext(found) == "" {
+				found = found + ".md"
+			}
+		}
+		return notedb.RelativeLink(f.path, found, fragment)
+	}
+	doc, parseErr := parser.Parse(f.Data(), parser.WikiLinks(resolve))
+	return doc, multierr.Append(err, parseErr)
+}
@@ -0,0 +1,88 @@
+package obsidian
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func writeVault(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		hostPath := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(hostPath), 0777))
+		require.NoError(t, ioutil.WriteFile(hostPath, []byte(data), 0666))
+	}
+	return root
+}
+
+func TestRead(t *testing.T) {
+	root := writeVault(t, map[string]string{
+		".obsidian/app.json": "{}",
+		"Home.md":            "See [[Other]], [[Sub/Other#Top|here]] and ![[pic.png]].\n",
+		"Sub/Other.md":       "Other\n",
+		"Files/pic.png":      "PNG",
+	})
+	dbURL := &url.URL{Scheme: "obsidian", Path: root}
+	require.Equal(t, notedb.DetectResultPositive, Detect(dbURL))
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	entries, err := fs.ReadDir(db, ".")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"Files", "Home.md", "Sub"}, names)
+
+	f, err := db.Open("Home.md")
+	require.NoError(t, err)
+	note := f.(notedb.Note)
+	doc, err := note.ParseAST()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, renderer.NewRenderer().Render(&buf, note.Data(), doc))
+	require.Equal(t, "See [Other](Sub/Other.md), [here](Sub/Other.md#Top) and ![pic.png](Files/pic.png).\n", buf.String())
+}
+
+func TestWrite(t *testing.T) {
+	root := writeVault(t, map[string]string{
+		".obsidian/app.json": `{"attachmentFolderPath": "attachments"}`,
+	})
+	db, err := OpenDatabase(&url.URL{Scheme: "obsidian", Path: root})
+	require.NoError(t, err)
+
+	require.NoError(t, fs.MkdirAll(db, "Sub/_resources", 0777))
+	for name, data := range map[string]string{
+		"Sub/Note.md":            "See [Home](../Home.md) and ![image](_resources/pic.png).\n",
+		"Sub/_resources/pic.png": "PNG",
+		"Home.md":                "Back to [the note](Sub/Note.md#Top) or [**the** note](Sub/Note.md).\n",
+	} {
+		f, err := fs.Create(db, name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "Sub", "Note.md"))
+	require.NoError(t, err)
+	require.Equal(t, "See [[Home]] and ![[pic.png]].\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(root, "Home.md"))
+	require.NoError(t, err)
+	require.Equal(t, "Back to [[Note#Top|the note]] or [[Note|**the** note]].\n", string(data))
+	_, err = os.Stat(filepath.Join(root, "attachments", "pic.png"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(root, "Sub", "_resources"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,268 @@
+package obsidian
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// appConfig is the subset of .obsidian/app.json used by Pilikino.
+type appConfig struct {
+	AttachmentFolderPath string `json:"attachmentFolderPath"`
+}
+
+func readAppConfig(root string) (*appConfig, error) {
+	config := &appConfig{}
+	data, err := ioutil.ReadFile(filepath.Join(root, configDir, "app.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid app.json: %w", err)
+	}
+	return config, nil
+}
+
+// attachmentDir returns the directory that Obsidian would place a new
+// attachment in, given the directory of the note it was added to.
+func (c *appConfig) attachmentDir(noteDir string) string {
+	folder := c.AttachmentFolderPath
+	switch {
+	case folder == "" || folder == "/":
+		return "."
+	case folder == "." || folder == "./":
+		return noteDir
+	case strings.HasPrefix(folder, "./"):
+		return path.Join(noteDir, folder[2:])
+	default:
+		return path.Clean(strings.TrimPrefix(folder, "/"))
+	}
+}
+
+// Close finishes writing to the vault. The attachments which were written are
+// moved into the configured attachment folder, and links in the notes which
+// were written are converted to wikilinks.
+func (db *Database) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.written) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(db.root, configDir), 0777); err != nil {
+		return err
+	}
+	config, err := readAppConfig(db.root)
+	if err != nil {
+		return err
+	}
+
+	var notes, attachments []string
+	for p := range db.written {
+		if strings.HasSuffix(p, ".md") {
+			notes = append(notes, p)
+		} else {
+			attachments = append(attachments, p)
+		}
+	}
+	sort.Strings(notes)
+	sort.Strings(attachments)
+
+	// Find the first note which references each attachment, which determines
+	// where the attachment belongs.
+	isAttachment := map[string]bool{}
+	for _, p := range attachments {
+		isAttachment[p] = true
+	}
+	referrers := map[string]string{}
+	for _, notePath := range notes {
+		data, readErr := fs.ReadFile(db.Database, notePath)
+		if readErr != nil {
+			err = multierr.Append(err, readErr)
+			continue
+		}
+		doc, _ := parser.Parse(data)
+		walkLocalLinks(doc, notePath, func(n ast.Node, target, fragment string) {
+			if _, ok := referrers[target]; !ok && isAttachment[target] {
+				referrers[target] = notePath
+			}
+		})
+	}
+
+	moved := map[string]string{}
+	for _, p := range attachments {
+		noteDir := path.Dir(p)
+		if referrer, ok := referrers[p]; ok {
+			noteDir = path.Dir(referrer)
+		}
+		newPath, moveErr := db.moveAttachment(p, config.attachmentDir(noteDir))
+		if moveErr != nil {
+			err = multierr.Append(err, moveErr)
+			continue
+		}
+		moved[p] = newPath
+	}
+
+	index, indexErr := buildIndex(db.Database)
+	if indexErr != nil {
+		return multierr.Append(err, indexErr)
+	}
+	db.index = index
+	for _, notePath := range notes {
+		err = multierr.Append(err, db.writeWikiLinks(notePath, index, moved))
+	}
+	db.written = map[string]bool{}
+	return err
+}
+
+// moveAttachment moves the file into the given directory, choosing a new name
+// if there is a conflict. The new path is returned.
+func (db *Database) moveAttachment(p string, dir string) (string, error) {
+	if path.Dir(p) == dir {
+		return p, nil
+	}
+	entries, err := os.ReadDir(db.hostPath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	used := map[string]bool{}
+	for _, entry := range entries {
+		used[strings.ToLower(entry.Name())] = true
+	}
+	newPath := path.Join(dir, notedb.UniqueName(path.Base(p), used))
+	if err := os.MkdirAll(db.hostPath(dir), 0777); err != nil {
+		return "", err
+	}
+	if err := os.Rename(db.hostPath(p), db.hostPath(newPath)); err != nil {
+		return "", err
+	}
+	// Clean up any directories which are now empty.
+	for parent := path.Dir(p); parent != "."; parent = path.Dir(parent) {
+		if os.Remove(db.hostPath(parent)) != nil {
+			break
+		}
+	}
+	return newPath, nil
+}
+
+func (db *Database) hostPath(p string) string {
+	return filepath.Join(db.root, filepath.FromSlash(p))
+}
+
+// writeWikiLinks rewrites the links in the note which point to other files in
+// the vault into wikilinks. The modification time of the note is preserved.
+func (db *Database) writeWikiLinks(notePath string, index *vaultIndex, moved map[string]string) error {
+	hostPath := db.hostPath(notePath)
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(hostPath)
+	if err != nil {
+		return err
+	}
+	doc, err := parser.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	type replacement struct {
+		node ast.Node
+		text string
+	}
+	var replacements []replacement
+	walkLocalLinks(doc, notePath, func(n ast.Node, target, fragment string) {
+		if newPath, ok := moved[target]; ok {
+			target = newPath
+		}
+		if !index.paths[target] {
+			return
+		}
+		name := index.linkName(target)
+		if fragment != "" {
+			name = name + "#" + fragment
+		}
+		if _, ok := n.(*ast.Image); ok {
+			replacements = append(replacements, replacement{n, "![[" + name + "]]"})
+			return
+		}
+		label, err := renderLabel(n, data)
+		if err != nil || strings.ContainsAny(label, "[]|\n") {
+			// The label cannot be expressed in a wikilink, so the Markdown
+			// link is kept.
+			return
+		}
+		if label == "" || label == name {
+			replacements = append(replacements, replacement{n, "[[" + name + "]]"})
+		} else {
+			replacements = append(replacements, replacement{n, "[[" + name + "|" + label + "]]"})
+		}
+	})
+	if len(replacements) == 0 {
+		return nil
+	}
+	for _, r := range replacements {
+		parent := r.node.Parent()
+		parent.ReplaceChild(parent, r.node, ast.NewString([]byte(r.text)))
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.NewRenderer().Render(&buf, data, doc); err != nil {
+		return &fs.PathError{Op: "write", Path: notePath, Err: err}
+	}
+	if err := ioutil.WriteFile(hostPath, buf.Bytes(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(hostPath, info.ModTime(), info.ModTime())
+}
+
+// renderLabel renders the children of the link back into Markdown, so that
+// any formatting in its label is kept.
+func renderLabel(link ast.Node, data []byte) (string, error) {
+	var buf bytes.Buffer
+	r := renderer.NewRenderer()
+	for c := link.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.Render(&buf, data, c); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// walkLocalLinks calls fn for every link and image in the document which
+// refers to a file in the vault. The target is the path of the file in the
+// vault.
+func walkLocalLinks(doc ast.Node, notePath string, fn func(n ast.Node, target, fragment string)) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest []byte
+		switch link := n.(type) {
+		case *ast.Link:
+			dest = link.Destination
+		case *ast.Image:
+			dest = link.Destination
+		default:
+			return ast.WalkContinue, nil
+		}
+		if target, fragment, ok := notedb.LocalLink(notePath, dest); ok {
+			fn(n, target, fragment)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
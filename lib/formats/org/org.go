@@ -0,0 +1,239 @@
+package org
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/orgmode"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "org",
+		Description: "Directory of Org mode files",
+		Documentation: `This format corresponds to a directory of Org mode files. Each .org file
+becomes a note with the same name and a .md extension, and other files are
+copied as attachments. Headings, lists, tables, links, source blocks, and
+emphasis are converted into Markdown. Tags on headings become #tags, and the
+property drawers of headings become heading attributes, like {#id KEY=value}.
+Headings nested more than six levels deep become bold text, which is reported
+as an error. Other drawers and comments are removed. The #+TITLE:, #+FILETAGS:,
+#+DATE:, and #+AUTHOR: settings of each file are available as its metadata,
+with the other settings and the properties at the start of the file in the
+extra metadata.
+
+If the path does not exist, a new directory is created once the conversion
+finishes, and each note is written as an Org file with a .org extension. An
+existing directory can only be read. Links between notes are updated to point
+at the .org files. The title, tags, creation time, and author of each note
+are written as settings.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// Database is a database loaded from a directory of Org files.
+type Database struct {
+	*mem.Database
+	// sources maps the path of each note to the path in the directory which
+	// it was created from.
+	sources map[string]string
+	// paths maps the paths in the directory to the paths in the database.
+	paths map[string]string
+}
+
+// OpenDatabase is the entrypoint for the Org format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	info, err := os.Stat(dbURL.Path)
+	if os.IsNotExist(err) {
+		return newWriter(dbURL.Path), nil
+	} else if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dbURL.Path)
+	}
+	db := &Database{
+		Database: mem.New(),
+		sources:  map[string]string{},
+		paths:    map[string]string{},
+	}
+	if err := db.load(fs.DirFS(dbURL.Path)); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a directory of Org files. Org
+// files appear in many other kinds of directories, so the directory must hold
+// Org files and no Markdown files at its top level.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	found := false
+	for _, entry := range entries {
+		switch path.Ext(entry.Name()) {
+		case ".org":
+			found = found || !entry.IsDir()
+		case ".md":
+			return notedb.DetectResultNegative
+		}
+	}
+	if found {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+func (db *Database) load(dir fs.FS) error {
+	used := map[string]bool{}
+	var orgFiles []string
+	err := fs.WalkDir(dir, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if p != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if d.IsDir() {
+			return nil
+		}
+		// Org files are added after the other files, so that they are
+		// renamed if they collide with an existing Markdown file.
+		if path.Ext(p) == ".org" {
+			orgFiles = append(orgFiles, p)
+			return nil
+		}
+		used[strings.ToLower(p)] = true
+		_, err = db.copyFile(dir, p, p)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range orgFiles {
+		name := notedb.UniqueName(strings.TrimSuffix(p, ".org")+".md", used)
+		data, err := db.copyFile(dir, p, name)
+		if err != nil {
+			return err
+		}
+		if err := db.SetMetadata(name, keywordMetadata(orgmode.Keywords(data))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orgTimeLayouts are the forms of the timestamps accepted in #+DATE:, without
+// the surrounding brackets.
+var orgTimeLayouts = []string{"2006-01-02 Mon 15:04", "2006-01-02 Mon", "2006-01-02"}
+
+// keywordMetadata converts the keywords at the start of a file into its
+// metadata.
+func keywordMetadata(keywords []orgmode.Keyword) notedb.Metadata {
+	metadata := notedb.Metadata{Extra: map[string]interface{}{}}
+	for _, kw := range keywords {
+		switch strings.ToUpper(kw.Key) {
+		case "TITLE":
+			metadata.Title = kw.Value
+		case "AUTHOR":
+			metadata.Author = kw.Value
+		case "FILETAGS":
+			metadata.Tags = strings.FieldsFunc(kw.Value, func(r rune) bool {
+				return r == ':' || r == ' '
+			})
+		case "DATE":
+			value := strings.Trim(kw.Value, "<>[]")
+			for _, layout := range orgTimeLayouts {
+				if t, err := time.Parse(layout, value); err == nil {
+					metadata.Created = t
+					break
+				}
+			}
+			if metadata.Created.IsZero() {
+				metadata.Extra[kw.Key] = kw.Value
+			}
+		default:
+			metadata.Extra[kw.Key] = kw.Value
+		}
+	}
+	return metadata
+}
+
+// copyFile copies a file from the directory into the database, returning its
+// contents.
+func (db *Database) copyFile(dir fs.FS, source, name string) ([]byte, error) {
+	data, err := fs.ReadFile(dir, source)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(dir, source)
+	if err != nil {
+		return nil, err
+	}
+	db.sources[name] = source
+	db.paths[source] = name
+	return data, db.WriteFile(name, data, info.ModTime())
+}
+
+// resolveLink converts a link in the note into a link to the corresponding
+// file in the database. Links which do not point into the directory are not
+// modified.
+func (db *Database) resolveLink(notePath string, dest []byte) ([]byte, error) {
+	target, fragment, ok := notedb.LocalLink(db.sources[notePath], dest)
+	if !ok {
+		return dest, nil
+	}
+	found, ok := db.paths[target]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return notedb.RelativeLink(notePath, found, fragment), nil
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &orgFile{f.(mem.File), db, name}, nil
+}
+
+type orgFile struct {
+	mem.File
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*orgFile)(nil)
+var _ fs.ReadDirFile = (*orgFile)(nil)
+
+func (f *orgFile) ParseAST() (ast.Node, error) {
+	var doc ast.Node
+	var err error
+	if path.Ext(f.db.sources[f.path]) == ".org" {
+		doc, err = orgmode.Parse(f.Data())
+	} else {
+		doc, err = parser.Parse(f.Data())
+	}
+	if doc == nil {
+		return nil, err
+	}
+	return doc, multierr.Append(err, notedb.MapLinks(doc, func(dest []byte) ([]byte, error) {
+		return f.db.resolveLink(f.path, dest)
+	}))
+}
@@ -0,0 +1,137 @@
+package org
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const projectOrg = `#+TITLE: Project
+:PROPERTIES:
+:ID: 1234
+:END:
+
+* TODO Plan the work :work:urgent:
+:PROPERTIES:
+:CREATED: [2021-06-01]
+:END:
+Some *bold*, /italic/, =code= and +struck+ text
+across two lines, with \(x^2\).
+
+- First [[file:notes/ideas.org][ideas]]
+  - Nested
+- [ ] Second
+
+1. One
+2. Two
+
+** Table
+| Name | Count |
+|------+-------|
+| a    | 1     |
+
+#+BEGIN_SRC go
+fmt.Println("hi")
+,* not a heading
+#+END_SRC
+
+#+BEGIN_QUOTE
+Quoted [[https://example.com][site]].
+#+END_QUOTE
+
+[[./pic.png]]
+# A comment
+`
+
+func writeDir(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		dest := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0777))
+		require.NoError(t, os.WriteFile(dest, []byte(data), 0666))
+	}
+	return root
+}
+
+func TestRead(t *testing.T) {
+	root := writeDir(t, map[string]string{
+		"project.org":     projectOrg,
+		"notes/ideas.org": "See [[file:../project.org::*Table][the table]].\n",
+		"pic.png":         "png",
+	})
+	dbURL, err := notedb.ResolveURL(root)
+	require.NoError(t, err)
+	require.Equal(t, "org", dbURL.Scheme)
+	mixed := writeDir(t, map[string]string{"README.org": "* Readme\n", "notes.md": "# Notes\n"})
+	require.Equal(t, notedb.DetectResultNegative, Detect(&url.URL{Path: mixed}))
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	require.Equal(t, "# TODO Plan the work #work #urgent {CREATED=\"[2021-06-01]\"}\n\n"+
+		"Some **bold**, *italic*, `code` and ~~struck~~ text across two lines, with $x^2$.\n\n"+
+		"- First [ideas](notes/ideas.md)\n  - Nested\n- [ ] Second\n\n"+
+		"1. One\n2. Two\n\n"+
+		"## Table\n\n"+
+		"| Name | Count |\n|------|-------|\n| a    | 1     |\n\n"+
+		"```go\nfmt.Println(\"hi\")\n* not a heading\n```\n\n"+
+		"> Quoted [site](https://example.com).\n\n"+
+		"![./pic.png](pic.png)\n",
+		notedbtest.RenderNote(t, db, "project.md"))
+	require.Equal(t, "See [the table](../project.md#Table).\n", notedbtest.RenderNote(t, db, "notes/ideas.md"))
+
+	f, err := db.Open("project.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, notedb.Metadata{Title: "Project", Extra: map[string]interface{}{"ID": "1234"}}, metadata)
+}
+
+func TestWriter(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "org")
+	db, err := OpenDatabase(&url.URL{Scheme: "org", Path: root})
+	require.NoError(t, err)
+	require.NoError(t, fs.MkdirAll(db, "notes", 0777))
+	notedbtest.WriteFile(t, db, "Project.md", "# Plan #work\n\nSee [ideas](notes/Ideas.md) and *this*.\n\n"+
+		"- One\n  - Nested\n- Two\n\n| A | B |\n| - | - |\n| 1 | 2 |\n\n```sh\necho hi\n```\n\n![pic](pic.png)\n")
+	f, err := fs.Create(db, "notes/Ideas.md")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("Back to [project](../Project.md#plan).\n"))
+	require.NoError(t, err)
+	created := time.Date(2021, 6, 1, 9, 30, 0, 0, time.UTC)
+	metadata := notedb.Metadata{Title: "Ideas", Tags: []string{"work", "new ideas"}, Created: created}
+	require.NoError(t, notedb.WriteMetadata(f.(notedb.Note), metadata))
+	require.NoError(t, f.Close())
+	notedbtest.WriteFile(t, db, "pic.png", "png")
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	data, err := os.ReadFile(filepath.Join(root, "Project.org"))
+	require.NoError(t, err)
+	require.Equal(t, "* Plan :work:\n\nSee [[file:notes/Ideas.org][ideas]] and /this/.\n\n"+
+		"- One\n  - Nested\n- Two\n\n| A | B |\n|---+---|\n| 1 | 2 |\n\n"+
+		"#+BEGIN_SRC sh\necho hi\n#+END_SRC\n\n[[file:pic.png]]\n", string(data))
+	data, err = os.ReadFile(filepath.Join(root, "notes", "Ideas.org"))
+	require.NoError(t, err)
+	require.Equal(t, "#+TITLE: Ideas\n#+DATE: [2021-06-01 Tue 09:30]\n#+FILETAGS: :work:new_ideas:\n\n"+
+		"Back to [[file:../Project.org::#plan][project]].\n", string(data))
+	_, err = os.Stat(filepath.Join(root, "pic.png"))
+	require.NoError(t, err)
+
+	// The directory which was written can be read back.
+	read, err := OpenDatabase(&url.URL{Scheme: "org", Path: root})
+	require.NoError(t, err)
+	require.Equal(t, "Back to [project](../Project.md#plan).\n", notedbtest.RenderNote(t, read, "notes/Ideas.md"))
+	note, err := read.Open("notes/Ideas.md")
+	require.NoError(t, err)
+	metadata.Tags = []string{"work", "new_ideas"}
+	metadata.Extra = map[string]interface{}{}
+	readMetadata, err := note.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, metadata, readMetadata)
+}
@@ -0,0 +1,132 @@
+package org
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/orgmode"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// Writer is a database which holds the written notes in memory and produces
+// a directory of Org files when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+func newWriter(path string) *Writer {
+	return &Writer{mem.New(), path}
+}
+
+// Close writes the directory.
+func (w *Writer) Close() error {
+	var errs error
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file := p
+		if path.Ext(p) == ".md" {
+			file = orgName(p)
+			var convertErr error
+			data, convertErr = convert(p, data)
+			for _, err := range multierr.Errors(convertErr) {
+				errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: err})
+			}
+			metadata, err := w.Metadata(p)
+			if err != nil {
+				return err
+			}
+			data = append(metadataKeywords(metadata), data...)
+		}
+		errs = multierr.Append(errs, mem.WriteHostFile(w.path, file, data, info.ModTime()))
+		return nil
+	})
+	return multierr.Append(errs, err)
+}
+
+// metadataKeywords returns the settings which hold the metadata of a note, to
+// be placed at the start of its Org file.
+func metadataKeywords(metadata notedb.Metadata) []byte {
+	var buf bytes.Buffer
+	if metadata.Title != "" {
+		fmt.Fprintf(&buf, "#+TITLE: %s\n", metadata.Title)
+	}
+	if metadata.Author != "" {
+		fmt.Fprintf(&buf, "#+AUTHOR: %s\n", metadata.Author)
+	}
+	if !metadata.Created.IsZero() {
+		fmt.Fprintf(&buf, "#+DATE: [%s]\n", metadata.Created.Format(orgTimeLayouts[0]))
+	}
+	if len(metadata.Tags) > 0 {
+		tags := make([]string, len(metadata.Tags))
+		for i, tag := range metadata.Tags {
+			tags[i] = strings.ReplaceAll(tag, " ", "_")
+		}
+		fmt.Fprintf(&buf, "#+FILETAGS: :%s:\n", strings.Join(tags, ":"))
+	}
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// orgName returns the name of the Org file for the note.
+func orgName(notePath string) string {
+	return strings.TrimSuffix(notePath, ".md") + ".org"
+}
+
+// convert converts the note into an Org document. Links to other notes are
+// updated to point at their Org files.
+func convert(notePath string, data []byte) ([]byte, error) {
+	doc, err := parser.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*ast.Link); ok && entering {
+			link.Destination = orgLink(link.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	var buf bytes.Buffer
+	err = orgmode.NewRenderer().Render(&buf, data, doc)
+	if err != nil {
+		err = fmt.Errorf("while rendering: %w", err)
+	}
+	return buf.Bytes(), err
+}
+
+// orgLink rewrites a relative link to a note to point at its Org file.
+func orgLink(dest []byte) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || path.Ext(u.Path) != ".md" {
+		return dest
+	}
+	u.Path = orgName(u.Path)
+	return []byte(u.String())
+}
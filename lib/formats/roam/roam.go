@@ -0,0 +1,291 @@
+package roam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// maxRefDepth limits how many block references inside of referenced blocks
+// are resolved, since blocks may refer to each other.
+const maxRefDepth = 5
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "roam-json",
+		Description: "Roam Research JSON export",
+		Documentation: `This is the JSON export format for Roam Research, which is a single
+.json file containing every page in the graph.
+
+Each page becomes a note named after its title, and the blocks of the page
+become nested lists. [[Page]] links are converted into links to the notes,
+((block)) references and embeds are replaced with the text of the block, and
+TODO and DONE markers become task list items. The modification time of each
+note is the last time that the page or any of its blocks was edited. The
+title and creation time of each page are available as its metadata, and the
+uid is stored in the extra metadata.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+type block struct {
+	String     string  `json:"string"`
+	UID        string  `json:"uid"`
+	Heading    int     `json:"heading"`
+	CreateTime int64   `json:"create-time"`
+	EditTime   int64   `json:"edit-time"`
+	Children   []block `json:"children"`
+}
+
+type page struct {
+	Title      string  `json:"title"`
+	UID        string  `json:"uid"`
+	CreateTime int64   `json:"create-time"`
+	EditTime   int64   `json:"edit-time"`
+	Children   []block `json:"children"`
+}
+
+var (
+	blockRefPattern = regexp.MustCompile(`\{\{(?:\[\[)?embed(?:\]\])?: *\(\(([\w-]+)\)\)\}\}|\(\(([\w-]+)\)\)`)
+	todoPattern     = regexp.MustCompile(`^\{\{(?:\[\[)?(TODO|DONE)(?:\]\])?\}\} *`)
+	italicPattern   = regexp.MustCompile(`__([^_\n]+)__`)
+	// aliasPattern matches the destination of a [label]([[Page]]) link, which
+	// must be wrapped in angle brackets if the title contains spaces.
+	aliasPattern = regexp.MustCompile(`\]\((\[\[[^\]]+\]\])\)`)
+)
+
+// Database is a read-only database loaded from a Roam JSON export.
+type Database struct {
+	*mem.Database
+	// titles maps the lowercase titles of the pages to the paths of their
+	// notes.
+	titles map[string]string
+	// blocks maps the uids of blocks to their text.
+	blocks map[string]string
+	// errs holds the problems encountered while converting each note, which
+	// are reported when the note is parsed.
+	errs map[string]error
+}
+
+// OpenDatabase is the entrypoint for the Roam JSON format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	data, err := os.ReadFile(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	var pages []page
+	if err := json.Unmarshal(data, &pages); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db := &Database{
+		Database: mem.New(),
+		titles:   map[string]string{},
+		blocks:   map[string]string{},
+		errs:     map[string]error{},
+	}
+	if err := db.load(pages, info.ModTime()); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a Roam JSON export. Only the
+// start of the file is examined, which must be a list of pages with titles.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if !strings.HasSuffix(dbURL.Path, ".json") {
+		return notedb.DetectResultNegative
+	}
+	if first, keys := notedb.SniffJSON(dbURL.Path); first != '[' || !keys["title"] {
+		return notedb.DetectResultNegative
+	}
+	return notedb.DetectResultPositive
+}
+
+func (db *Database) load(pages []page, exportTime time.Time) error {
+	used := map[string]bool{}
+	names := make([]string, len(pages))
+	for i, p := range pages {
+		name := notedb.EscapeName(p.Title)
+		if name == "" {
+			name = "Untitled"
+		}
+		names[i] = notedb.UniqueName(name+".md", used)
+		if _, ok := db.titles[strings.ToLower(p.Title)]; !ok {
+			db.titles[strings.ToLower(p.Title)] = names[i]
+		}
+		db.indexBlocks(p.Children)
+	}
+
+	for i, p := range pages {
+		var sb strings.Builder
+		var err error
+		updated := fromMillis(p.EditTime)
+		db.writeBlocks(&sb, p.Children, 0, &updated, &err)
+		if updated.IsZero() {
+			updated = fromMillis(p.CreateTime)
+		}
+		if updated.IsZero() {
+			updated = exportTime
+		}
+		if err != nil {
+			db.errs[names[i]] = err
+		}
+		if err := db.WriteFile(names[i], []byte(sb.String()), updated); err != nil {
+			return err
+		}
+		metadata := notedb.Metadata{
+			Title:   p.Title,
+			Created: fromMillis(p.CreateTime),
+			Updated: updated,
+			Extra:   map[string]interface{}{"uid": p.UID},
+		}
+		if err := db.SetMetadata(names[i], metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) indexBlocks(blocks []block) {
+	for _, b := range blocks {
+		db.blocks[b.UID] = b.String
+		db.indexBlocks(b.Children)
+	}
+}
+
+// writeBlocks writes the blocks as a nested list, and updates the
+// modification time to the most recent edit of any of the blocks.
+func (db *Database) writeBlocks(sb *strings.Builder, blocks []block, depth int, updated *time.Time, err *error) {
+	indent := strings.Repeat("  ", depth)
+	for _, b := range blocks {
+		if edited := fromMillis(b.EditTime); edited.After(*updated) {
+			*updated = edited
+		}
+		text, refErr := db.convertText(b.String, 0)
+		*err = multierr.Append(*err, refErr)
+		if m := todoPattern.FindStringSubmatch(text); m != nil {
+			checkbox := "[ ] "
+			if m[1] == "DONE" {
+				checkbox = "[x] "
+			}
+			text = checkbox + text[len(m[0]):]
+		}
+		if b.Heading > 0 {
+			text = strings.Repeat("#", b.Heading) + " " + text
+		}
+		for i, line := range strings.Split(text, "\n") {
+			switch {
+			case i == 0 && line == "":
+				sb.WriteString(indent + "-\n")
+			case i == 0:
+				sb.WriteString(indent + "- " + line + "\n")
+			case line == "":
+				sb.WriteString("\n")
+			default:
+				sb.WriteString(indent + "  " + line + "\n")
+			}
+		}
+		db.writeBlocks(sb, b.Children, depth+1, updated, err)
+	}
+}
+
+// convertText replaces the block references in the text with the text of the
+// blocks, and converts Roam's italics into Markdown.
+func (db *Database) convertText(text string, depth int) (string, error) {
+	var err error
+	text = blockRefPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := blockRefPattern.FindStringSubmatch(match)
+		uid := m[1] + m[2]
+		found, ok := db.blocks[uid]
+		if !ok {
+			err = multierr.Append(err, fmt.Errorf("missing block reference: ((%s))", uid))
+			return match
+		}
+		if depth < maxRefDepth {
+			var refErr error
+			found, refErr = db.convertText(found, depth+1)
+			err = multierr.Append(err, refErr)
+		}
+		return found
+	})
+	if depth == 0 {
+		text = italicPattern.ReplaceAllString(text, "*$1*")
+		text = aliasPattern.ReplaceAllString(text, "](<$1>)")
+	}
+	return text, err
+}
+
+// resolveLink converts a page link into a link to the note. Roam writes page
+// links as [[Page]] or [label]([[Page]]).
+func (db *Database) resolveLink(dest []byte) ([]byte, error) {
+	title := string(dest)
+	if !strings.HasPrefix(title, "[[") || !strings.HasSuffix(title, "]]") {
+		return dest, nil
+	}
+	name, ok := db.titles[strings.ToLower(title[2:len(title)-2])]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return []byte((&url.URL{Path: name}).String()), nil
+}
+
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &pageFile{f.(mem.File), db, name}, nil
+}
+
+type pageFile struct {
+	mem.File
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*pageFile)(nil)
+var _ fs.ReadDirFile = (*pageFile)(nil)
+
+func (f *pageFile) ParseAST() (ast.Node, error) {
+	err := f.db.errs[f.path]
+	doc, parseErr := parser.Parse(f.Data(), parser.WikiLinks(func(target string) []byte {
+		return []byte("[[" + target + "]]")
+	}))
+	if parseErr != nil {
+		return nil, multierr.Append(err, parseErr)
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*ast.Link); ok && entering {
+			var linkErr error
+			link.Destination, linkErr = f.db.resolveLink(link.Destination)
+			err = multierr.Append(err, linkErr)
+		}
+		return ast.WalkContinue, nil
+	})
+	return doc, multierr.Append(err, walkErr)
+}
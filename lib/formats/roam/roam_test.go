@@ -0,0 +1,66 @@
+package roam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const export = `[
+  {
+    "title": "Research: Ideas",
+    "uid": "page1",
+    "create-time": 1622505600000,
+    "children": [
+      {
+        "string": "Overview",
+        "uid": "blk1",
+        "heading": 2,
+        "edit-time": 1622592000000,
+        "children": [
+          {"string": "Read __papers__ on [[Daily Log]]", "uid": "blk2", "edit-time": 1622678400000},
+          {"string": "{{[[TODO]]}} Follow up", "uid": "blk3"}
+        ]
+      }
+    ]
+  },
+  {
+    "title": "Daily Log",
+    "uid": "page2",
+    "edit-time": 1622505600000,
+    "children": [
+      {"string": "Quoting ((blk2)), see [ideas]([[Research: Ideas]])", "uid": "blk4"}
+    ]
+  }
+]`
+
+func TestRead(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "roam.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(export), 0644))
+	dbURL, err := notedb.ResolveURL(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "roam-json", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	require.Equal(t, "- ## Overview\n  - Read *papers* on [Daily Log](Daily%20Log.md)\n  - [ ] Follow up\n",
+		notedbtest.RenderNote(t, db, "Research Ideas.md"))
+	require.Equal(t, "- Quoting Read *papers* on [Daily Log](Daily%20Log.md), see [ideas](Research%20Ideas.md)\n",
+		notedbtest.RenderNote(t, db, "Daily Log.md"))
+
+	info, err := fs.Stat(db, "Research Ideas.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 6, 3, 0, 0, 0, 0, time.UTC), info.ModTime())
+	f, err := db.Open("Research Ideas.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, "page1", metadata.Extra["uid"])
+	require.Equal(t, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), metadata.Created)
+}
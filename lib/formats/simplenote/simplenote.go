@@ -0,0 +1,110 @@
+package simplenote
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+)
+
+// trashFolder is the directory which trashed notes are placed in.
+const trashFolder = "Trash"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "simplenote",
+		Description: "Simplenote export",
+		Documentation: `This is the export format for Simplenote. The path should point at the
+notes.json file from the source directory of the export.
+
+Each note is named after the first line of its content, which Simplenote
+uses as the title. Trashed notes are placed in a Trash directory. The
+creation time and tags of each note are available as its metadata, with its
+ID and whether it is pinned in the extra metadata.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+type export struct {
+	ActiveNotes  []exportNote `json:"activeNotes"`
+	TrashedNotes []exportNote `json:"trashedNotes"`
+}
+
+type exportNote struct {
+	ID           string    `json:"id"`
+	Content      string    `json:"content"`
+	CreationDate time.Time `json:"creationDate"`
+	LastModified time.Time `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+	Pinned       bool      `json:"pinned"`
+}
+
+// OpenDatabase is the entrypoint for the Simplenote format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	data, err := os.ReadFile(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	var e export
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db := mem.New()
+	usedNames := map[string]bool{}
+	for _, note := range e.ActiveNotes {
+		if err := addNote(db, "", note, usedNames); err != nil {
+			return nil, err
+		}
+	}
+	usedNames = map[string]bool{}
+	for _, note := range e.TrashedNotes {
+		if err := addNote(db, trashFolder, note, usedNames); err != nil {
+			return nil, err
+		}
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a Simplenote export. Only the
+// start of the file is examined, which must hold the list of active notes.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if !strings.HasSuffix(dbURL.Path, ".json") {
+		return notedb.DetectResultNegative
+	}
+	if first, keys := notedb.SniffJSON(dbURL.Path); first != '{' || !keys["activeNotes"] {
+		return notedb.DetectResultNegative
+	}
+	return notedb.DetectResultPositive
+}
+
+func addNote(db *mem.Database, dir string, note exportNote, usedNames map[string]bool) error {
+	content := strings.ReplaceAll(note.Content, "\r\n", "\n")
+	// The first line is the title, which may be formatted as a heading.
+	title := strings.SplitN(strings.TrimSpace(content), "\n", 2)[0]
+	name := notedb.EscapeName(strings.TrimLeft(title, "# "))
+	if name == "" {
+		name = "Untitled"
+	}
+	name = path.Join(dir, notedb.UniqueName(name+".md", usedNames))
+	if err := db.WriteFile(name, []byte(content), note.LastModified); err != nil {
+		return err
+	}
+	metadata := notedb.Metadata{
+		Tags:    note.Tags,
+		Created: note.CreationDate,
+		Updated: note.LastModified,
+		Extra:   map[string]interface{}{"id": note.ID},
+	}
+	if note.Pinned {
+		metadata.Extra["pinned"] = true
+	}
+	return db.SetMetadata(name, metadata)
+}
@@ -0,0 +1,70 @@
+package simplenote
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const exportData = `{
+  "activeNotes": [
+    {
+      "id": "a1",
+      "content": "# Plans: 2021/22\r\nSome text",
+      "creationDate": "2021-06-01T12:00:00.000Z",
+      "lastModified": "2021-06-02T12:00:00.000Z",
+      "tags": ["work", "ideas"]
+    },
+    {
+      "id": "a2",
+      "content": "Plans 202122\nAnother",
+      "creationDate": "2021-06-01T12:00:00.000Z",
+      "lastModified": "2021-06-01T12:00:00.000Z"
+    }
+  ],
+  "trashedNotes": [
+    {
+      "id": "t1",
+      "content": "Old",
+      "creationDate": "2021-06-01T12:00:00.000Z",
+      "lastModified": "2021-06-01T12:00:00.000Z"
+    }
+  ]
+}`
+
+func TestRead(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "notes.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(exportData), 0644))
+	dbURL, err := notedb.ResolveURL(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "simplenote", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Plans 202122 2.md", "Plans 202122.md", "Trash", "Trash/Old.md"}, paths)
+
+	data, err := fs.ReadFile(db, "Plans 202122.md")
+	require.NoError(t, err)
+	require.Equal(t, "# Plans: 2021/22\nSome text", string(data))
+	info, err := fs.Stat(db, "Plans 202122.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC), info.ModTime())
+	f, err := db.Open("Plans 202122.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"work", "ideas"}, metadata.Tags)
+	require.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), metadata.Created)
+}
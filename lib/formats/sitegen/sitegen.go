@@ -0,0 +1,383 @@
+// Package sitegen writes notes into the source directories of static site
+// generators, such as Hugo and Jekyll.
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	mathjax "github.com/litao91/goldmark-mathjax"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v2"
+)
+
+// generator describes the layout expected by a static site generator.
+type generator struct {
+	// contentDir and staticDir are the directories, relative to the site,
+	// which hold the notes and attachments.
+	contentDir string
+	staticDir  string
+	// lastmodKey is the front matter key for the modification time.
+	lastmodKey string
+	// sections is set when every directory should get an _index.md.
+	sections bool
+	// noteLink and fileLink return the link destinations for a note and an
+	// attachment, given their paths relative to contentDir and staticDir.
+	noteLink func(p, fragment string) string
+	fileLink func(p string) string
+}
+
+var hugo = &generator{
+	contentDir: "content",
+	staticDir:  "static",
+	lastmodKey: "lastmod",
+	sections:   true,
+	noteLink: func(p, fragment string) string {
+		if fragment != "" {
+			p += "#" + fragment
+		}
+		return fmt.Sprintf(`{{< ref "/%s" >}}`, p)
+	},
+	fileLink: func(p string) string {
+		return (&url.URL{Path: "/" + p}).String()
+	},
+}
+
+var jekyll = &generator{
+	contentDir: ".",
+	staticDir:  "assets",
+	lastmodKey: "last_modified_at",
+	noteLink: func(p, fragment string) string {
+		link := liquidLink(p)
+		if fragment != "" {
+			link += "#" + fragment
+		}
+		return link
+	},
+	fileLink: func(p string) string {
+		return liquidLink("assets/" + p)
+	},
+}
+
+// liquidLink returns the Jekyll link tag for the path. Paths which contain
+// spaces or Liquid syntax are given as a quoted string inside of an output
+// tag, which the link tag renders before looking up the path.
+func liquidLink(p string) string {
+	if !strings.ContainsAny(p, " \t{}%'\"") {
+		return fmt.Sprintf("{%% link %s %%}", p)
+	}
+	quote := `"`
+	if strings.Contains(p, quote) {
+		quote = "'"
+	}
+	return fmt.Sprintf("{%% link {{ %s%s%s }} %%}", quote, p, quote)
+}
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "hugo",
+		Description: "Hugo site",
+		Documentation: `This format writes notes into the content directory of a Hugo site, for
+example hugo:///path/to/site. It can only be used as the destination of a
+conversion. Existing files in the site are never replaced.
+
+Each note gets YAML front matter with its title, date, lastmod, tags, and
+slug, taken from its metadata where possible, keeping any front matter which
+the note already has. Each directory becomes a section with an _index.md,
+links between notes use the ref shortcode, and attachments are moved to the
+static directory.`,
+		Open:   hugo.open,
+		Detect: Detect,
+	})
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "jekyll",
+		Description: "Jekyll site",
+		Documentation: `This format writes notes into a Jekyll site as pages, for example
+jekyll:///path/to/site. It can only be used as the destination of a
+conversion. Existing files in the site are never replaced.
+
+Each note gets YAML front matter with its title, date, last_modified_at, tags,
+and slug, taken from its metadata where possible, keeping any front matter
+which the note already has. Links between notes use the link tag, and
+attachments are moved to the assets directory. Paths containing spaces are
+given to the link tag as quoted strings, which requires Jekyll 4.`,
+		Open:   jekyll.open,
+		Detect: Detect,
+	})
+}
+
+// markdown parses notes along with any front matter that they already have.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(meta.Meta, mathjax.MathJax, extension.Table),
+)
+
+// Writer is a database which holds the written notes in memory and adds them
+// to the site when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+	gen  *generator
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+// open opens the site for writing. The site does not need to exist.
+func (gen *generator) open(dbURL *url.URL) (notedb.Database, error) {
+	if info, err := os.Stat(dbURL.Path); err == nil && !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dbURL.Path)
+	}
+	return &Writer{mem.New(), dbURL.Path, gen}, nil
+}
+
+// Detect always returns negative, since sites cannot be read.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	return notedb.DetectResultNegative
+}
+
+// Close writes the notes and attachments into the site.
+func (w *Writer) Close() error {
+	var notes, attachments, dirs []string
+	isNote := map[string]bool{}
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != "." {
+				dirs = append(dirs, p)
+			}
+		} else if path.Ext(p) == ".md" {
+			notes = append(notes, p)
+			isNote[p] = true
+		} else {
+			attachments = append(attachments, p)
+			isNote[p] = false
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, p := range notes {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		info, err := fs.Stat(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		metadata, err := w.Metadata(p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		converted, convertErr := w.gen.convert(p, data, info.ModTime(), metadata, isNote)
+		for _, err := range multierr.Errors(convertErr) {
+			errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: p, Err: err})
+		}
+		errs = multierr.Append(errs, w.writeFile(path.Join(w.gen.contentDir, p), converted, info.ModTime()))
+	}
+	for _, p := range attachments {
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		info, err := fs.Stat(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		errs = multierr.Append(errs, w.writeFile(path.Join(w.gen.staticDir, p), data, info.ModTime()))
+	}
+	if w.gen.sections {
+		for _, p := range dirs {
+			errs = multierr.Append(errs, w.writeSection(p))
+		}
+	}
+	return errs
+}
+
+// writeSection adds an _index.md for the directory, unless the site already
+// has one.
+func (w *Writer) writeSection(p string) error {
+	data, err := yaml.Marshal(yaml.MapSlice{{Key: "title", Value: path.Base(p)}})
+	if err != nil {
+		return err
+	}
+	err = w.writeFile(path.Join(w.gen.contentDir, p, "_index.md"), withFences(data), time.Now())
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeFile writes a new file to the site.
+func (w *Writer) writeFile(p string, data []byte, modTime time.Time) error {
+	dest := filepath.Join(w.path, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dest, time.Now(), modTime)
+}
+
+// withFences surrounds the YAML with the lines which mark it as front matter.
+func withFences(data []byte) []byte {
+	return append(append([]byte("---\n"), data...), "---\n"...)
+}
+
+// convert adds the front matter to the note and rewrites its links into the
+// style used by the generator.
+func (gen *generator) convert(notePath string, data []byte, modTime time.Time, metadata notedb.Metadata, isNote map[string]bool) ([]byte, error) {
+	context := parser.NewContext()
+	doc := markdown.Parser().Parse(text.NewReader(data), parser.WithContext(context))
+	existing, err := meta.TryGetItems(context)
+	if err != nil {
+		// goldmark-meta leaves invalid front matter in the document as a text
+		// block. It is left out of the note, and the error is reported.
+		doc.RemoveChild(doc, doc.FirstChild())
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var linkErr error
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination, linkErr = gen.rewriteLink(notePath, n.Destination, isNote)
+		case *ast.Image:
+			n.Destination, linkErr = gen.rewriteLink(notePath, n.Destination, isNote)
+		}
+		err = multierr.Append(err, linkErr)
+		return ast.WalkContinue, nil
+	})
+	if walkErr != nil {
+		return nil, multierr.Append(err, walkErr)
+	}
+
+	front, marshalErr := yaml.Marshal(gen.frontMatter(notePath, modTime, metadata, existing))
+	if marshalErr != nil {
+		return nil, multierr.Append(err, marshalErr)
+	}
+	buf := bytes.NewBuffer(withFences(front))
+	buf.WriteString("\n")
+	err = multierr.Append(err, renderer.NewRenderer().Render(buf, data, doc))
+	return buf.Bytes(), err
+}
+
+// frontMatter returns the front matter for the note. The title, date, and tags
+// come from the metadata of the note when it has them. Values which are
+// already in the front matter of the note are kept, and come after the
+// generated values.
+func (gen *generator) frontMatter(notePath string, modTime time.Time, metadata notedb.Metadata, existing yaml.MapSlice) yaml.MapSlice {
+	values := map[string]interface{}{}
+	for _, item := range existing {
+		if key, ok := item.Key.(string); ok {
+			values[key] = item.Value
+		}
+	}
+	title := metadata.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(notePath), ".md")
+	}
+	date := modTime.Format(time.RFC3339)
+	if created, ok := values["created"]; ok {
+		date = fmt.Sprint(created)
+	} else if !metadata.Created.IsZero() {
+		date = metadata.Created.Format(time.RFC3339)
+	}
+	tags := metadata.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	generated := yaml.MapSlice{
+		{Key: "title", Value: title},
+		{Key: "date", Value: date},
+		{Key: gen.lastmodKey, Value: modTime.Format(time.RFC3339)},
+		{Key: "tags", Value: tags},
+		{Key: "slug", Value: slugify(title)},
+	}
+	var front yaml.MapSlice
+	seen := map[interface{}]bool{}
+	for _, item := range generated {
+		if value, ok := values[item.Key.(string)]; ok {
+			item.Value = value
+		}
+		front = append(front, item)
+		seen[item.Key] = true
+	}
+	for _, item := range existing {
+		if !seen[item.Key] {
+			front = append(front, item)
+		}
+	}
+	return front
+}
+
+// rewriteLink converts a link to a note or attachment into the style used by
+// the generator.
+func (gen *generator) rewriteLink(notePath string, dest []byte, isNote map[string]bool) ([]byte, error) {
+	target, fragment, ok := notedb.LocalLink(notePath, dest)
+	if !ok {
+		return dest, nil
+	}
+	note, ok := isNote[target]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	} else if note {
+		return []byte(gen.noteLink(target, fragment)), nil
+	}
+	return []byte(gen.fileLink(target)), nil
+}
+
+// slugify converts a title into a lowercase slug, such as "my-first-note".
+func slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package sitegen
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, root, path string) string {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func writeNotes(t *testing.T, db notedb.Database, modTime time.Time) {
+	require.NoError(t, fs.MkdirAll(db, "Guides", 0777))
+	notedbtest.WriteFileAt(t, db, "Welcome Page.md", "---\ntags: [intro]\nauthor: Sam\n---\n\n# Welcome\n\n"+
+		"See [setup](Guides/Setup.md#install) and ![logo](logo.png).\n", modTime)
+	notedbtest.WriteFileAt(t, db, "Guides/Setup.md", "Back to [home](../Welcome%20Page.md).\n", modTime)
+	notedbtest.WriteFileAt(t, db, "logo.png", "png", modTime)
+}
+
+func TestHugo(t *testing.T) {
+	root := t.TempDir()
+	db, err := notedb.OpenDatabase(&url.URL{Scheme: "hugo", Path: root})
+	require.NoError(t, err)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	writeNotes(t, db, modTime)
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	require.Equal(t, "---\ntitle: Welcome Page\ndate: \"2021-06-01T12:00:00Z\"\nlastmod: \"2021-06-01T12:00:00Z\"\n"+
+		"tags:\n- intro\nslug: welcome-page\nauthor: Sam\n---\n\n# Welcome\n\n"+
+		`See [setup]({{< ref "/Guides/Setup.md#install" >}}) and ![logo](/logo.png).`+"\n",
+		readFile(t, root, "content/Welcome Page.md"))
+	require.Equal(t, "---\ntitle: Setup\ndate: \"2021-06-01T12:00:00Z\"\nlastmod: \"2021-06-01T12:00:00Z\"\n"+
+		"tags: []\nslug: setup\n---\n\n"+
+		`Back to [home]({{< ref "/Welcome Page.md" >}}).`+"\n",
+		readFile(t, root, "content/Guides/Setup.md"))
+	require.Equal(t, "---\ntitle: Guides\n---\n", readFile(t, root, "content/Guides/_index.md"))
+	require.Equal(t, "png", readFile(t, root, "static/logo.png"))
+	info, err := os.Stat(filepath.Join(root, "content", "Guides", "Setup.md"))
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(info.ModTime()))
+
+	// Existing files are never replaced.
+	db, err = notedb.OpenDatabase(&url.URL{Scheme: "hugo", Path: root})
+	require.NoError(t, err)
+	writeNotes(t, db, modTime)
+	require.Error(t, notedb.CloseDatabase(db))
+}
+
+func TestJekyll(t *testing.T) {
+	root := t.TempDir()
+	db, err := notedb.OpenDatabase(&url.URL{Scheme: "jekyll", Path: root})
+	require.NoError(t, err)
+	writeNotes(t, db, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	notedbtest.WriteFileAt(t, db, "Guides/Broken.md", "[gone](Missing.md)\n", time.Now())
+	notedbtest.WriteFileAt(t, db, "Invalid.md", "---\ntags: [\n---\n\nBody\n", time.Now())
+	require.EqualError(t, notedb.CloseDatabase(db), "convert Guides/Broken.md: dead link: Missing.md; "+
+		"convert Invalid.md: yaml: line 1: did not find expected node content")
+	require.Contains(t, readFile(t, root, "Invalid.md"), "slug: invalid\n---\n\nBody\n")
+
+	welcome := readFile(t, root, "Welcome Page.md")
+	require.Contains(t, welcome, "last_modified_at: \"2021-06-01T12:00:00Z\"\n")
+	require.Contains(t, welcome, "See [setup]({% link Guides/Setup.md %}#install) and ![logo]({% link assets/logo.png %}).\n")
+	require.Contains(t, readFile(t, root, "Guides/Setup.md"), `Back to [home]({% link {{ "Welcome Page.md" }} %}).`)
+	require.Equal(t, "png", readFile(t, root, "assets/logo.png"))
+	_, err = os.Stat(filepath.Join(root, "Guides", "_index.md"))
+	require.True(t, os.IsNotExist(err))
+}
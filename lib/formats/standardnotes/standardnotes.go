@@ -0,0 +1,174 @@
+package standardnotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+)
+
+// trashFolder is the directory which trashed notes are placed in.
+const trashFolder = "Trash"
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "standard-notes",
+		Description: "Standard Notes backup",
+		Documentation: `This is the decrypted backup format for Standard Notes, which is a JSON
+file usually named "Standard Notes Backup and Import File.txt". Encrypted
+backups cannot be read; export a decrypted backup instead.
+
+Each note is named after its title, or the first line of its text if it has
+no title. Trashed notes are placed in a Trash directory. The title, creation
+time, and tags of each note are available as its metadata, with its UUID and
+whether it is pinned or archived in the extra metadata.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+type backup struct {
+	Items []item `json:"items"`
+}
+
+type item struct {
+	UUID        string          `json:"uuid"`
+	ContentType string          `json:"content_type"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Deleted     bool            `json:"deleted"`
+	Content     json.RawMessage `json:"content"`
+}
+
+type itemContent struct {
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+	Trashed    bool        `json:"trashed"`
+	Archived   bool        `json:"archived"`
+	Pinned     bool        `json:"pinned"`
+	References []reference `json:"references"`
+	AppData    struct {
+		StandardNotes struct {
+			ClientUpdatedAt time.Time `json:"client_updated_at"`
+		} `json:"org.standardnotes.sn"`
+	} `json:"appData"`
+}
+
+type reference struct {
+	UUID        string `json:"uuid"`
+	ContentType string `json:"content_type"`
+}
+
+// OpenDatabase is the entrypoint for the Standard Notes format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	data, err := os.ReadFile(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	var b backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db := mem.New()
+	if err := load(db, b.Items); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a Standard Notes backup. Only
+// the start of the file is examined, which must hold items with content types.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	if ext := path.Ext(dbURL.Path); ext != ".txt" && ext != ".json" {
+		return notedb.DetectResultNegative
+	}
+	if first, keys := notedb.SniffJSON(dbURL.Path); first != '{' || !keys["items.content_type"] {
+		return notedb.DetectResultNegative
+	}
+	return notedb.DetectResultPositive
+}
+
+func load(db *mem.Database, items []item) error {
+	type note struct {
+		item    item
+		content itemContent
+	}
+	var notes []note
+	tags := map[string][]string{}
+	for _, it := range items {
+		if it.Deleted || (it.ContentType != "Note" && it.ContentType != "Tag") {
+			continue
+		}
+		var content itemContent
+		if err := json.Unmarshal(it.Content, &content); err != nil {
+			var encrypted string
+			if json.Unmarshal(it.Content, &encrypted) == nil {
+				return fmt.Errorf("backup is encrypted")
+			}
+			return fmt.Errorf("item %s: %w", it.UUID, err)
+		}
+		if it.ContentType == "Note" {
+			notes = append(notes, note{it, content})
+			continue
+		}
+		for _, ref := range content.References {
+			if ref.ContentType == "Note" {
+				tags[ref.UUID] = append(tags[ref.UUID], content.Title)
+			}
+		}
+	}
+
+	usedNames := map[string]map[string]bool{"": {}, trashFolder: {}}
+	for _, n := range notes {
+		title := n.content.Title
+		if title == "" {
+			title = strings.SplitN(strings.TrimSpace(n.content.Text), "\n", 2)[0]
+			title = strings.TrimLeft(title, "# ")
+		}
+		name := notedb.EscapeName(title)
+		if name == "" {
+			name = "Untitled"
+		}
+		dir := ""
+		if n.content.Trashed {
+			dir = trashFolder
+		}
+		name = path.Join(dir, notedb.UniqueName(name+".md", usedNames[dir]))
+
+		modTime := n.content.AppData.StandardNotes.ClientUpdatedAt
+		if modTime.IsZero() {
+			modTime = n.item.UpdatedAt
+		}
+		text := strings.ReplaceAll(n.content.Text, "\r\n", "\n")
+		if err := db.WriteFile(name, []byte(text), modTime); err != nil {
+			return err
+		}
+		noteTags := tags[n.item.UUID]
+		sort.Strings(noteTags)
+		metadata := notedb.Metadata{
+			Title:   n.content.Title,
+			Tags:    noteTags,
+			Created: n.item.CreatedAt,
+			Updated: modTime,
+			Extra:   map[string]interface{}{"uuid": n.item.UUID},
+		}
+		if n.content.Pinned {
+			metadata.Extra["pinned"] = true
+		}
+		if n.content.Archived {
+			metadata.Extra["archived"] = true
+		}
+		if err := db.SetMetadata(name, metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
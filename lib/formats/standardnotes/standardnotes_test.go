@@ -0,0 +1,82 @@
+package standardnotes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const backupData = `{
+  "version": "004",
+  "items": [
+    {
+      "uuid": "n1",
+      "content_type": "Note",
+      "created_at": "2021-06-01T12:00:00.000Z",
+      "updated_at": "2021-06-03T12:00:00.000Z",
+      "content": {
+        "title": "Meeting: notes",
+        "text": "Agenda",
+        "references": [],
+        "appData": {"org.standardnotes.sn": {"client_updated_at": "2021-06-02T12:00:00.000Z"}}
+      }
+    },
+    {
+      "uuid": "n2",
+      "content_type": "Note",
+      "created_at": "2021-06-01T12:00:00.000Z",
+      "updated_at": "2021-06-01T12:00:00.000Z",
+      "content": {"title": "", "text": "First line\nrest", "trashed": true, "references": []}
+    },
+    {
+      "uuid": "t1",
+      "content_type": "Tag",
+      "created_at": "2021-06-01T12:00:00.000Z",
+      "updated_at": "2021-06-01T12:00:00.000Z",
+      "content": {"title": "work", "references": [{"uuid": "n1", "content_type": "Note"}]}
+    },
+    {
+      "uuid": "x1",
+      "content_type": "SN|Component",
+      "created_at": "2021-06-01T12:00:00.000Z",
+      "updated_at": "2021-06-01T12:00:00.000Z",
+      "content": {"name": "Editor"}
+    }
+  ]
+}`
+
+func TestRead(t *testing.T) {
+	backupPath := filepath.Join(t.TempDir(), "Standard Notes Backup and Import File.txt")
+	require.NoError(t, os.WriteFile(backupPath, []byte(backupData), 0644))
+	dbURL, err := notedb.ResolveURL(backupPath)
+	require.NoError(t, err)
+	require.Equal(t, "standard-notes", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Meeting notes.md", "Trash", "Trash/First line.md"}, paths)
+
+	info, err := fs.Stat(db, "Meeting notes.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC), info.ModTime())
+	f, err := db.Open("Meeting notes.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"work"}, metadata.Tags)
+	data, err := fs.ReadFile(db, "Trash/First line.md")
+	require.NoError(t, err)
+	require.Equal(t, "First line\nrest", string(data))
+}
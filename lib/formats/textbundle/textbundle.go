@@ -0,0 +1,286 @@
+package textbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+)
+
+const (
+	bundleExt = ".textbundle"
+	packExt   = ".textpack"
+	bearExt   = ".bear2bk"
+	assetsDir = "assets"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "textbundle",
+		Description: "TextBundle, TextPack, and Bear backups",
+		Documentation: `This format corresponds to a directory of TextBundles, which are used
+by Bear, Ulysses, iA Writer, and others to exchange notes. The path may also
+point at a single .textbundle directory, a .textpack file, or a Bear
+.bear2bk backup.
+
+Each bundle becomes a single note, and the assets in the bundle are placed in
+a directory with the same name as the note. TextPack files, which are zipped
+bundles, are read the same way.
+
+If the path does not exist, a new directory of TextBundles is created; an
+existing one can only be read. The attachments linked from each note are
+copied into the assets of its bundle, and links to other notes point to
+their bundles.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// Database is a read-only database loaded from TextBundles.
+type Database struct {
+	*mem.Database
+	// assets maps the path of each note to the directory holding the assets
+	// of its bundle, which is empty if the bundle has no assets.
+	assets map[string]string
+}
+
+// OpenDatabase is the entrypoint for the TextBundle format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	info, err := os.Stat(dbURL.Path)
+	if os.IsNotExist(err) {
+		return newWriter(dbURL.Path), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	db := &Database{mem.New(), map[string]string{}}
+	name := filepath.Base(dbURL.Path)
+	switch {
+	case info.IsDir() && strings.HasSuffix(name, bundleExt):
+		err = db.loadBundle(fs.DirFS(dbURL.Path), ".", strings.TrimSuffix(name, bundleExt)+".md", map[string]bool{})
+	case info.IsDir():
+		err = db.loadDir(fs.DirFS(dbURL.Path), ".", ".")
+	case strings.HasSuffix(name, packExt):
+		var data []byte
+		if data, err = os.ReadFile(dbURL.Path); err == nil {
+			err = db.loadPack(data, strings.TrimSuffix(name, packExt)+".md", map[string]bool{})
+		}
+	default:
+		var archive *zip.ReadCloser
+		if archive, err = zip.OpenReader(dbURL.Path); err == nil {
+			defer archive.Close()
+			err = db.loadDir(&archive.Reader, ".", ".")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a TextBundle, or a directory
+// of them.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	switch path.Ext(dbURL.Path) {
+	case bundleExt, packExt, bearExt:
+		return notedb.DetectResultPositive
+	}
+	entries, err := os.ReadDir(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	for _, entry := range entries {
+		if ext := path.Ext(entry.Name()); ext == bundleExt || ext == packExt {
+			return notedb.DetectResultPositive
+		}
+	}
+	return notedb.DetectResultNegative
+}
+
+// loadDir adds the bundles and other files in the directory of the source to
+// the given directory of the database.
+func (db *Database) loadDir(fsys fs.FS, dir string, dest string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	usedNames := map[string]bool{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		usedNames[strings.ToLower(entry.Name())] = true
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		source := path.Join(dir, name)
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch {
+		case entry.IsDir() && strings.HasSuffix(name, bundleExt):
+			notePath := path.Join(dest, notedb.UniqueName(strings.TrimSuffix(name, bundleExt)+".md", usedNames))
+			err = db.loadBundle(fsys, source, notePath, usedNames)
+		case entry.IsDir():
+			err = db.loadDir(fsys, source, path.Join(dest, name))
+		case strings.HasSuffix(name, packExt):
+			var data []byte
+			if data, err = fs.ReadFile(fsys, source); err == nil {
+				notePath := path.Join(dest, notedb.UniqueName(strings.TrimSuffix(name, packExt)+".md", usedNames))
+				err = db.loadPack(data, notePath, usedNames)
+			}
+		default:
+			err = db.copyFile(fsys, source, path.Join(dest, name))
+		}
+		if err != nil {
+			return fmt.Errorf("while reading %s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// loadPack adds the bundle in the TextPack to the database.
+func (db *Database) loadPack(data []byte, notePath string, usedNames map[string]bool) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if _, err := findText(archive, "."); err == nil {
+		return db.loadBundle(archive, ".", notePath, usedNames)
+	}
+	entries, err := fs.ReadDir(archive, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), bundleExt) {
+			return db.loadBundle(archive, entry.Name(), notePath, usedNames)
+		}
+	}
+	return fmt.Errorf("no TextBundle found in TextPack")
+}
+
+// findText locates the text file of the bundle, which may have any
+// extension.
+func findText(fsys fs.FS, dir string) (string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "text.") {
+			return path.Join(dir, entry.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("no text file found in TextBundle")
+}
+
+// loadBundle adds the bundle to the database as the given note. The assets
+// of the bundle are placed in a directory alongside the note.
+func (db *Database) loadBundle(fsys fs.FS, dir string, notePath string, usedNames map[string]bool) error {
+	textPath, err := findText(fsys, dir)
+	if err != nil {
+		return err
+	}
+	data, err := fs.ReadFile(fsys, textPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(fsys, textPath)
+	if err != nil {
+		return err
+	}
+	if err := db.WriteFile(notePath, data, info.ModTime()); err != nil {
+		return err
+	}
+
+	db.assets[notePath] = ""
+	source := path.Join(dir, assetsDir)
+	if _, err := fs.Stat(fsys, source); err != nil {
+		return nil
+	}
+	dest := path.Join(path.Dir(notePath), notedb.UniqueName(strings.TrimSuffix(path.Base(notePath), ".md"), usedNames))
+	db.assets[notePath] = dest
+	return fs.WalkDir(fsys, source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return db.copyFile(fsys, p, path.Join(dest, strings.TrimPrefix(p, source+"/")))
+	})
+}
+
+func (db *Database) copyFile(fsys fs.FS, source string, dest string) error {
+	data, err := fs.ReadFile(fsys, source)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(fsys, source)
+	if err != nil {
+		return err
+	}
+	return db.WriteFile(dest, data, info.ModTime())
+}
+
+// resolveLink converts a link in the bundle into a link in the database.
+// Links to assets point to the directory of assets, and links to other
+// bundles point to their notes.
+func (db *Database) resolveLink(notePath string, dest []byte) []byte {
+	assets, ok := db.assets[notePath]
+	if !ok {
+		return dest
+	}
+	// Links in the bundle are relative to the bundle, which is treated as
+	// though it were in the same directory as the note.
+	source := strings.TrimSuffix(notePath, ".md") + bundleExt
+	target, fragment, ok := notedb.LocalLink(path.Join(source, "text.md"), dest)
+	if !ok {
+		return dest
+	}
+	if prefix := path.Join(source, assetsDir) + "/"; assets != "" && strings.HasPrefix(target, prefix) {
+		target = path.Join(assets, strings.TrimPrefix(target, prefix))
+	} else if ext := path.Ext(target); ext == bundleExt || ext == packExt {
+		target = strings.TrimSuffix(target, ext) + ".md"
+	} else {
+		return dest
+	}
+	return notedb.RelativeLink(notePath, target, fragment)
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &bundleFile{f.(mem.File), db, name}, nil
+}
+
+type bundleFile struct {
+	mem.File
+	db   *Database
+	path string
+}
+
+var _ notedb.Note = (*bundleFile)(nil)
+var _ fs.ReadDirFile = (*bundleFile)(nil)
+
+func (f *bundleFile) ParseAST() (ast.Node, error) {
+	doc, err := parser.Parse(f.Data())
+	if err != nil {
+		return nil, err
+	}
+	return doc, notedb.MapLinks(doc, func(dest []byte) ([]byte, error) {
+		return f.db.resolveLink(f.path, dest), nil
+	})
+}
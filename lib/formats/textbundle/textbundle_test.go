@@ -0,0 +1,103 @@
+package textbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bundles")
+	dbURL := &url.URL{Scheme: "textbundle", Path: dir}
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Notebook/images", 0777))
+	notedbtest.WriteFile(t, db, "Notebook/First.md", "# First\n\nSee [second](../Second.md#top) and ![img](images/a.png).\n")
+	notedbtest.WriteFile(t, db, "Notebook/images/a.png", "PNG")
+	notedbtest.WriteFile(t, db, "Second.md", "Back to [first](Notebook/First.md).\n")
+	notedbtest.WriteFile(t, db, "unused.txt", "TXT")
+	require.NoError(t, fs.Chtimes(db, "Second.md", modTime, modTime))
+	require.NoError(t, notedb.CloseDatabase(db))
+
+	text, err := os.ReadFile(filepath.Join(dir, "Notebook/First.textbundle/text.md"))
+	require.NoError(t, err)
+	require.Equal(t, "# First\n\nSee [second](../../Second.textbundle#top) and ![img](assets/a.png).\n", string(text))
+	_, err = os.Stat(filepath.Join(dir, "Notebook/First.textbundle/info.json"))
+	require.NoError(t, err)
+
+	db, err = OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Notebook",
+		"Notebook/First",
+		"Notebook/First/a.png",
+		"Notebook/First.md",
+		"Second.md",
+		"unused.txt",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "# First\n\nSee [second](../Second.md#top) and ![img](First/a.png).\n", notedbtest.RenderNote(t, db, "Notebook/First.md"))
+	require.Equal(t, "Back to [first](Notebook/First.md).\n", notedbtest.RenderNote(t, db, "Second.md"))
+
+	info, err := fs.Stat(db, "Second.md")
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(modTime))
+}
+
+func TestReadBearBackup(t *testing.T) {
+	var pack bytes.Buffer
+	zw := zip.NewWriter(&pack)
+	for name, data := range map[string]string{
+		"Packed.textbundle/info.json":    "{}",
+		"Packed.textbundle/text.md":      "Packed\n",
+		"Packed.textbundle/assets/a.png": "PNG",
+	} {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	backupPath := filepath.Join(t.TempDir(), "Backup.bear2bk")
+	var backup bytes.Buffer
+	zw = zip.NewWriter(&backup)
+	for name, data := range map[string]string{
+		"Note.textbundle/info.json":     "{}",
+		"Note.textbundle/text.markdown": "# Note\n\n![](assets/b.png) [packed](../Packed.textpack)\n",
+		"Note.textbundle/assets/b.png":  "PNG",
+		"Packed.textpack":               pack.String(),
+	} {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(backupPath, backup.Bytes(), 0666))
+
+	require.Equal(t, notedb.DetectResultPositive, Detect(&url.URL{Path: backupPath}))
+	db, err := OpenDatabase(&url.URL{Scheme: "textbundle", Path: backupPath})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".",
+		"Note",
+		"Note/b.png",
+		"Note.md",
+		"Packed",
+		"Packed/a.png",
+		"Packed.md",
+	}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "# Note\n\n![](Note/b.png) [packed](Packed.md)\n", notedbtest.RenderNote(t, db, "Note.md"))
+}
@@ -0,0 +1,141 @@
+package textbundle
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"go.uber.org/multierr"
+)
+
+// infoJSON is the contents of info.json for the bundles which are written.
+const infoJSON = `{
+  "version": 2,
+  "type": "net.daringfireball.markdown",
+  "transient": false
+}
+`
+
+// Writer is a database which holds the written notes in memory and produces
+// a directory of TextBundles when it is closed.
+type Writer struct {
+	*mem.Database
+	path string
+}
+
+var _ io.Closer = (*Writer)(nil)
+
+func newWriter(path string) *Writer {
+	return &Writer{mem.New(), path}
+}
+
+// Close writes the TextBundles. Attachments which are not linked from any note
+// are copied into the directory as they are.
+func (w *Writer) Close() error {
+	var notePaths []string
+	attachments := map[string]bool{}
+	err := fs.WalkDir(w.Database, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(p, ".md") {
+			notePaths = append(notePaths, p)
+		} else {
+			attachments[p] = false
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, notePath := range notePaths {
+		if noteErr := w.writeBundle(notePath, attachments); noteErr != nil {
+			for _, err := range multierr.Errors(noteErr) {
+				errs = multierr.Append(errs, &fs.PathError{Op: "convert", Path: notePath, Err: err})
+			}
+		}
+	}
+	for p, used := range attachments {
+		if used {
+			continue
+		}
+		data, err := fs.ReadFile(w.Database, p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if err := mem.WriteHostFile(w.path, p, data, w.ModTime(p)); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// writeBundle writes the note as a TextBundle, copying the attachments it
+// links to into the bundle's assets. Attachments which are copied are marked
+// as used.
+func (w *Writer) writeBundle(notePath string, attachments map[string]bool) error {
+	data, err := fs.ReadFile(w.Database, notePath)
+	if err != nil {
+		return err
+	}
+	bundlePath := strings.TrimSuffix(notePath, ".md") + bundleExt
+	assets := map[string]string{}
+	usedAssets := map[string]bool{}
+	data, err = rewriteLinks(notePath, data, func(target string) (string, bool) {
+		if strings.HasSuffix(target, ".md") {
+			if _, statErr := fs.Stat(w.Database, target); statErr != nil {
+				return "", false
+			}
+			return strings.TrimSuffix(target, ".md") + bundleExt, true
+		}
+		if _, ok := attachments[target]; !ok {
+			return "", false
+		}
+		name, ok := assets[target]
+		if !ok {
+			name = path.Join(bundlePath, assetsDir, notedb.UniqueName(path.Base(target), usedAssets))
+			assets[target] = name
+		}
+		return name, true
+	})
+
+	modTime := w.ModTime(notePath)
+	for source, dest := range assets {
+		content, readErr := fs.ReadFile(w.Database, source)
+		if readErr != nil {
+			err = multierr.Append(err, readErr)
+			continue
+		}
+		attachments[source] = true
+		err = multierr.Append(err, mem.WriteHostFile(w.path, dest, content, w.ModTime(source)))
+	}
+	err = multierr.Append(err, mem.WriteHostFile(w.path, path.Join(bundlePath, "info.json"), []byte(infoJSON), modTime))
+	err = multierr.Append(err, mem.WriteHostFile(w.path, path.Join(bundlePath, "text.md"), data, modTime))
+	return err
+}
+
+// rewriteLinks updates the relative links in the note to point to the
+// locations returned by the resolve function, which are relative to the
+// database. Since the text of the note is stored inside of the bundle, links
+// are made relative to the bundle instead of to the note.
+func rewriteLinks(notePath string, data []byte, resolve func(target string) (string, bool)) ([]byte, error) {
+	bundlePath := strings.TrimSuffix(notePath, ".md") + bundleExt
+	return notedb.RewriteLinks(data, func(dest []byte) ([]byte, error) {
+		target, fragment, ok := notedb.LocalLink(notePath, dest)
+		if !ok {
+			return dest, nil
+		}
+		found, ok := resolve(target)
+		if !ok {
+			return dest, fmt.Errorf("dead link: %s", dest)
+		}
+		return notedb.RelativeLink(path.Join(bundlePath, "text.md"), found, fragment), nil
+	})
+}
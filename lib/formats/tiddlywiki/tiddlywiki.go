@@ -0,0 +1,416 @@
+package tiddlywiki
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/formats/mem"
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+const (
+	// infoFile is the file which marks a directory as a TiddlyWiki folder.
+	infoFile     = "tiddlywiki.info"
+	tiddlersDir  = "tiddlers"
+	timeLayout   = "20060102150405"
+	systemPrefix = "$:/"
+)
+
+func init() {
+	notedb.RegisterFormat(notedb.FormatDescription{
+		ID:          "tiddlywiki",
+		Description: "TiddlyWiki",
+		Documentation: `This format reads a TiddlyWiki, which is either a single HTML file or a
+directory of .tid files. The path may point at the HTML file, at a wiki
+folder containing a tiddlers directory, or at the tiddlers directory
+itself.
+
+Each tiddler becomes a note named after its title, and WikiText markup such
+as ''bold'', //italic//, ! headings, lists, tables, and [[links]] is
+converted into Markdown. Other text is escaped, except for macros, widgets,
+and HTML, which are copied unchanged. Markdown tiddlers are kept as they are,
+and images and other binary tiddlers become attachments. System tiddlers and
+drafts are skipped. The modification time of each note is the modified field
+of the tiddler. The title, tags, creation time, and creator of each tiddler
+are available as its metadata, with the other fields in the extra metadata.`,
+		Open:   OpenDatabase,
+		Detect: Detect,
+	})
+}
+
+// tiddler is a tiddler read from the wiki. Binary tiddlers which are stored
+// in separate files have their contents in data instead of the text field.
+type tiddler struct {
+	fields  map[string]string
+	data    []byte
+	modTime time.Time
+}
+
+var (
+	storePattern     = regexp.MustCompile(`(?s)<script[^>]*class="tiddlywiki-tiddler-store"[^>]*>(.*?)</script>`)
+	storeAreaPattern = regexp.MustCompile(`(?s)<div\s([^>]*\btitle="[^"]*"[^>]*)>\s*<pre>(.*?)</pre>\s*</div>`)
+	attributePattern = regexp.MustCompile(`([\w.:-]+)="([^"]*)"`)
+	tagPattern       = regexp.MustCompile(`\[\[([^\]]+)\]\]|(\S+)`)
+)
+
+// Database is a read-only database loaded from a TiddlyWiki.
+type Database struct {
+	*mem.Database
+	// titles maps the lowercase titles of the tiddlers to the paths of their
+	// notes and attachments.
+	titles map[string]string
+}
+
+// OpenDatabase is the entrypoint for the TiddlyWiki format.
+func OpenDatabase(dbURL *url.URL) (notedb.Database, error) {
+	info, err := os.Stat(dbURL.Path)
+	if err != nil {
+		return nil, err
+	}
+	var tiddlers []tiddler
+	if info.IsDir() {
+		tiddlers, err = readDir(findTiddlersDir(dbURL.Path))
+	} else {
+		var data []byte
+		data, err = os.ReadFile(dbURL.Path)
+		if err == nil {
+			tiddlers, err = readHTML(data, info.ModTime())
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", dbURL.Path, err)
+	}
+	db := &Database{Database: mem.New(), titles: map[string]string{}}
+	if err := db.load(tiddlers); err != nil {
+		return nil, err
+	}
+	db.SetReadOnly()
+	return db, nil
+}
+
+// Detect determines if the URL is likely to be a TiddlyWiki.
+func Detect(dbURL *url.URL) notedb.DetectResult {
+	info, err := os.Stat(dbURL.Path)
+	if err != nil {
+		return notedb.DetectResultNegative
+	}
+	if !info.IsDir() {
+		if ext := path.Ext(dbURL.Path); ext != ".html" && ext != ".htm" {
+			return notedb.DetectResultNegative
+		}
+		data, err := os.ReadFile(dbURL.Path)
+		if err == nil && (storePattern.Match(data) || bytes.Contains(data, []byte(`id="storeArea"`))) {
+			return notedb.DetectResultPositive
+		}
+		return notedb.DetectResultNegative
+	}
+	if _, err := os.Stat(filepath.Join(dbURL.Path, infoFile)); err == nil {
+		return notedb.DetectResultPositive
+	}
+	if matches, _ := filepath.Glob(filepath.Join(findTiddlersDir(dbURL.Path), "*.tid")); len(matches) > 0 {
+		return notedb.DetectResultPositive
+	}
+	return notedb.DetectResultNegative
+}
+
+// findTiddlersDir returns the directory holding the tiddlers of a wiki
+// folder, which may be the directory itself.
+func findTiddlersDir(root string) string {
+	dir := filepath.Join(root, tiddlersDir)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return root
+}
+
+// readHTML reads the tiddlers from a single-file wiki. Newer versions store
+// the tiddlers as JSON, and older versions as HTML elements.
+func readHTML(data []byte, modTime time.Time) ([]tiddler, error) {
+	var tiddlers []tiddler
+	for _, m := range storePattern.FindAllSubmatch(data, -1) {
+		var store []map[string]string
+		if err := json.Unmarshal(m[1], &store); err != nil {
+			return nil, err
+		}
+		for _, fields := range store {
+			tiddlers = append(tiddlers, tiddler{fields, nil, modTime})
+		}
+	}
+	if idx := bytes.Index(data, []byte(`id="storeArea"`)); idx != -1 {
+		for _, m := range storeAreaPattern.FindAllSubmatch(data[idx:], -1) {
+			fields := map[string]string{}
+			for _, attr := range attributePattern.FindAllSubmatch(m[1], -1) {
+				fields[string(attr[1])] = html.UnescapeString(string(attr[2]))
+			}
+			fields["text"] = html.UnescapeString(string(m[2]))
+			tiddlers = append(tiddlers, tiddler{fields, nil, modTime})
+		}
+	}
+	if len(tiddlers) == 0 {
+		return nil, fmt.Errorf("no tiddlers found")
+	}
+	return tiddlers, nil
+}
+
+// readDir reads the tiddlers from a directory. Text tiddlers are stored in
+// .tid files, and binary tiddlers are stored as ordinary files with their
+// fields in a .meta file next to them.
+func readDir(dir string) ([]tiddler, error) {
+	var tiddlers []tiddler
+	root := fs.DirFS(dir)
+	err := fs.WalkDir(root, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") || path.Ext(p) == ".meta" {
+			return err
+		}
+		data, err := fs.ReadFile(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if path.Ext(p) == ".tid" {
+			fields, text := readFields(data)
+			fields["text"] = text
+			tiddlers = append(tiddlers, tiddler{fields, nil, info.ModTime()})
+			return nil
+		}
+		meta, err := fs.ReadFile(root, p+".meta")
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields, _ := readFields(meta)
+		if fields["title"] == "" {
+			fields["title"] = path.Base(p)
+		}
+		tiddlers = append(tiddlers, tiddler{fields, data, info.ModTime()})
+		return nil
+	})
+	return tiddlers, err
+}
+
+// readFields parses the "name: value" lines at the start of a .tid file,
+// returning the fields and the text which follows them.
+func readFields(data []byte) (map[string]string, string) {
+	fields := map[string]string{}
+	header, text := strings.ReplaceAll(string(data), "\r\n", "\n"), ""
+	if idx := strings.Index(header, "\n\n"); idx != -1 {
+		header, text = header[:idx], header[idx+2:]
+	}
+	for _, line := range strings.Split(header, "\n") {
+		if idx := strings.Index(line, ":"); idx != -1 {
+			fields[strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+		}
+	}
+	return fields, text
+}
+
+func (db *Database) load(tiddlers []tiddler) error {
+	sort.SliceStable(tiddlers, func(i, j int) bool {
+		return tiddlers[i].fields["title"] < tiddlers[j].fields["title"]
+	})
+	used := map[string]bool{}
+	names := make([]string, len(tiddlers))
+	for i, t := range tiddlers {
+		title := t.fields["title"]
+		if title == "" || strings.HasPrefix(title, systemPrefix) || t.fields["draft.of"] != "" {
+			continue
+		}
+		name := notedb.EscapeName(title)
+		if name == "" {
+			name = "Untitled"
+		}
+		if isNote(t) {
+			name += ".md"
+		} else if path.Ext(name) == "" {
+			if exts, _ := mime.ExtensionsByType(t.fields["type"]); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		names[i] = notedb.UniqueName(name, used)
+		if _, ok := db.titles[strings.ToLower(title)]; !ok {
+			db.titles[strings.ToLower(title)] = names[i]
+		}
+	}
+
+	for i, t := range tiddlers {
+		if names[i] == "" {
+			continue
+		}
+		data, err := contents(t)
+		if err != nil {
+			return fmt.Errorf("tiddler %s: %w", t.fields["title"], err)
+		}
+		metadata := notedb.Metadata{
+			Title:   t.fields["title"],
+			Tags:    parseTags(t.fields["tags"]),
+			Created: parseTime(t.fields["created"]),
+			Updated: parseTime(t.fields["modified"]),
+			Author:  t.fields["creator"],
+			Extra:   map[string]interface{}{},
+		}
+		for k, v := range t.fields {
+			switch k {
+			case "title", "tags", "created", "modified", "creator", "text", "type":
+			default:
+				metadata.Extra[k] = v
+			}
+		}
+		modTime := metadata.Updated
+		if modTime.IsZero() {
+			modTime = metadata.Created
+		}
+		if modTime.IsZero() {
+			modTime = t.modTime
+		}
+		if err := db.WriteFile(names[i], data, modTime); err != nil {
+			return err
+		}
+		if err := db.SetMetadata(names[i], metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isNote reports whether the tiddler contains text, rather than an image or
+// other file.
+func isNote(t tiddler) bool {
+	if t.data != nil {
+		return false
+	}
+	switch t.fields["type"] {
+	case "", "text/vnd.tiddlywiki", "text/x-tiddlywiki", "text/x-markdown", "text/markdown", "text/plain":
+		return true
+	}
+	return false
+}
+
+// contents returns the contents of the note or attachment for the tiddler.
+func contents(t tiddler) ([]byte, error) {
+	if t.data != nil {
+		return t.data, nil
+	}
+	text := t.fields["text"]
+	switch typ := t.fields["type"]; {
+	case typ == "", typ == "text/vnd.tiddlywiki", typ == "text/x-tiddlywiki":
+		return []byte(convertWikiText(text)), nil
+	case isBinaryType(typ):
+		// Binary tiddlers are stored base64-encoded.
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	default:
+		return []byte(text), nil
+	}
+}
+
+// isBinaryType reports whether TiddlyWiki stores tiddlers of the type
+// base64-encoded.
+func isBinaryType(typ string) bool {
+	switch {
+	case typ == "image/svg+xml":
+		return false
+	case strings.HasPrefix(typ, "image/"), strings.HasPrefix(typ, "audio/"), strings.HasPrefix(typ, "video/"),
+		strings.HasPrefix(typ, "font/"), typ == "application/pdf", typ == "application/zip":
+		return true
+	}
+	return false
+}
+
+// parseTags parses a list of tags, where tags containing spaces are wrapped
+// in [[brackets]].
+func parseTags(tags string) []string {
+	var ret []string
+	for _, m := range tagPattern.FindAllStringSubmatch(tags, -1) {
+		ret = append(ret, m[1]+m[2])
+	}
+	return ret
+}
+
+// parseTime parses a TiddlyWiki date, which is a UTC time formatted as
+// YYYYMMDDHHMMSSmmm.
+func parseTime(value string) time.Time {
+	if len(value) < len(timeLayout) {
+		return time.Time{}
+	}
+	t, err := time.Parse(timeLayout, value[:len(timeLayout)])
+	if err != nil {
+		return time.Time{}
+	}
+	var ms int
+	fmt.Sscanf(value[len(timeLayout):], "%d", &ms)
+	return t.Add(time.Duration(ms) * time.Millisecond)
+}
+
+// resolveLink converts a link to a tiddler, written as [[Title]], into a link
+// to its note.
+func (db *Database) resolveLink(dest []byte) ([]byte, error) {
+	title := string(dest)
+	if !strings.HasPrefix(title, "[[") || !strings.HasSuffix(title, "]]") {
+		return dest, nil
+	}
+	name, ok := db.titles[strings.ToLower(title[2:len(title)-2])]
+	if !ok {
+		return dest, fmt.Errorf("dead link: %s", dest)
+	}
+	return []byte((&url.URL{Path: name}).String()), nil
+}
+
+// Open satisfies notedb.Database.
+func (db *Database) Open(name string) (fs.File, error) {
+	f, err := db.Database.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tiddlerFile{f.(mem.File), db}, nil
+}
+
+type tiddlerFile struct {
+	mem.File
+	db *Database
+}
+
+var _ notedb.Note = (*tiddlerFile)(nil)
+var _ fs.ReadDirFile = (*tiddlerFile)(nil)
+
+func (f *tiddlerFile) ParseAST() (ast.Node, error) {
+	doc, err := parser.Parse(f.Data(), parser.WikiLinks(func(target string) []byte {
+		return []byte("[[" + target + "]]")
+	}))
+	if err != nil {
+		return nil, err
+	}
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var linkErr error
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination, linkErr = f.db.resolveLink(n.Destination)
+		case *ast.Image:
+			n.Destination, linkErr = f.db.resolveLink(n.Destination)
+		}
+		err = multierr.Append(err, linkErr)
+		return ast.WalkContinue, nil
+	})
+	return doc, multierr.Append(err, walkErr)
+}
@@ -0,0 +1,85 @@
+package tiddlywiki
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+const wikiHTML = `<!doctype html>
+<html><body>
+<script class="tiddlywiki-tiddler-store" type="application/json">[
+{"title":"$:/core","text":"{}","type":"application/json"},
+{"title":"Home","created":"20210601120000000","modified":"20210602133000500","tags":"start [[Getting Started]]","color":"red",
+ "text":"! Welcome\n\nSome ''bold'' and //italic// text, see [[Project Plan]] and [[the plan|Project Plan]].\n\n* One\n** Nested\n# First\n\n|!Name|!Count|h\n|a|1|\n\n[img[logo.png]] https://example.com"},
+{"title":"Project Plan","modified":"20210603000000000","type":"text/x-markdown","text":"# Plan\n\nSee [[Home]].\n"},
+{"title":"logo.png","type":"image/png","text":"cG5n"}
+]</script>
+<div id="storeArea" style="display:none;"><div created="20200101000000000" title="Old &amp; Classic"><pre>Kept from an &lt;old&gt; version.</pre></div></div>
+</body></html>
+`
+
+func TestReadHTML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wiki.html")
+	require.NoError(t, os.WriteFile(file, []byte(wikiHTML), 0666))
+	dbURL, err := notedb.ResolveURL(file)
+	require.NoError(t, err)
+	require.Equal(t, "tiddlywiki", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Home.md", "Old & Classic.md", "Project Plan.md", "logo.png"}, notedbtest.ListPaths(t, db))
+
+	require.Equal(t, "# Welcome\n\n"+
+		"Some **bold** and *italic* text, see [Project Plan](Project%20Plan.md) and [the plan](Project%20Plan.md).\n\n"+
+		"- One\n  - Nested\n1. First\n\n"+
+		"| Name | Count |\n|------|-------|\n| a    | 1     |\n\n"+
+		"![](logo.png) https://example.com\n",
+		notedbtest.RenderNote(t, db, "Home.md"))
+	require.Equal(t, "# Plan\n\nSee [Home](Home.md).\n", notedbtest.RenderNote(t, db, "Project Plan.md"))
+	require.Equal(t, "Kept from an <old> version.\n", notedbtest.RenderNote(t, db, "Old & Classic.md"))
+	data, err := fs.ReadFile(db, "logo.png")
+	require.NoError(t, err)
+	require.Equal(t, "png", string(data))
+
+	info, err := fs.Stat(db, "Home.md")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 6, 2, 13, 30, 0, 500*int(time.Millisecond), time.UTC), info.ModTime())
+	f, err := db.Open("Home.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"start", "Getting Started"}, metadata.Tags)
+	require.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), metadata.Created)
+	require.Equal(t, map[string]interface{}{"color": "red"}, metadata.Extra)
+}
+
+func TestReadDir(t *testing.T) {
+	root := t.TempDir()
+	tiddlers := filepath.Join(root, "tiddlers")
+	require.NoError(t, os.MkdirAll(tiddlers, 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "tiddlywiki.info"), []byte("{}"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(tiddlers, "Journal.tid"), []byte(
+		"created: 20210601120000000\nmodified: 20210601130000000\ntags: daily\ntitle: Journal\n\n"+
+			"<<<\nA quote\n<<< Someone\n\n!! Photo\n[img[Photo|photo.jpg]]\n\n- 2*3 is <<six>> for a_b\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(tiddlers, "photo.jpg"), []byte("jpg"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(tiddlers, "photo.jpg.meta"), []byte("title: photo.jpg\ntype: image/jpeg\n"), 0666))
+
+	dbURL, err := notedb.ResolveURL(root)
+	require.NoError(t, err)
+	require.Equal(t, "tiddlywiki", dbURL.Scheme)
+	db, err := OpenDatabase(dbURL)
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Journal.md", "photo.jpg"}, notedbtest.ListPaths(t, db))
+	require.Equal(t, "> A quote\n>\n> — Someone\n\n## Photo\n\n![Photo](photo.jpg)\n\n\\- 2\\*3 is <<six>> for a\\_b\n", notedbtest.RenderNote(t, db, "Journal.md"))
+	f, err := db.Open("Journal.md")
+	require.NoError(t, err)
+	metadata, err := f.(notedb.Note).Metadata()
+	require.NoError(t, err)
+	require.Equal(t, []string{"daily"}, metadata.Tags)
+}
@@ -0,0 +1,265 @@
+package tiddlywiki
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+)
+
+var (
+	headingPattern = regexp.MustCompile(`^(!{1,6})\s*(.*)$`)
+	listPattern    = regexp.MustCompile(`^([*#;:]+)\s*(.*)$`)
+	quotePattern   = regexp.MustCompile(`^(>+)\s?(.*)$`)
+	rulePattern    = regexp.MustCompile(`^-{3,}\s*$`)
+	tablePattern   = regexp.MustCompile(`^\|.*\|([hfkc]?)\s*$`)
+	// inlinePattern matches the inline markup which is converted. Code spans
+	// and URLs are matched so that their contents are left alone, as are
+	// macros, widgets, HTML tags, and entities, which are kept as they are.
+	// The text between the matches is escaped.
+	inlinePattern = regexp.MustCompile("``.+?``|`[^`]+`" +
+		`|\[img[^\[\]]*\[(?:([^\]|]*)\|)?([^\]]+)\]\]` +
+		`|\[ext\[(?:([^\]|]*)\|)?([^\]]+)\]\]` +
+		`|\[\[(?:([^\]|]*)\|)?([^\]]+)\]\]` +
+		`|\{\{([^{}|]+)\}\}` +
+		`|(?:https?|ftp|file|mailto):[^\s<>\[\]"']+` +
+		`|''(.+?)''|//(.+?)//|__(.+?)__|\^\^(.+?)\^\^|,,(.+?),,` +
+		`|<<.+?>>|</?[$\w][^<>]*>|&(?:\w+|#\d+|#x[0-9a-fA-F]+);`)
+	urlPattern = regexp.MustCompile(`^(?:[a-z][a-z0-9+.-]*://|mailto:)`)
+)
+
+// convertWikiText converts the text of a tiddler from WikiText into Markdown.
+// Links to other tiddlers become [[Title]] or [label](<[[Title]]>), which are
+// resolved when the note is parsed, and transclusions become embeds.
+// Constructs without an equivalent, such as macros and widgets, are kept as
+// they are, and the rest of the text is escaped.
+func convertWikiText(text string) string {
+	var out []string
+	var table []string
+	inCode, inQuote, hardBreaks := false, false, false
+	// previous is the kind of the last line written, which determines whether
+	// a blank line is needed to separate it from the next one.
+	previous := ""
+	blank := func() {
+		if inQuote {
+			out = append(out, ">")
+		} else {
+			out = append(out, "")
+		}
+	}
+	block := func(kind string, lines ...string) {
+		if previous != "" && previous != "blank" && previous != kind {
+			blank()
+		}
+		out = append(out, lines...)
+		previous = kind
+	}
+	flushTable := func() {
+		if len(table) > 0 {
+			block("table", convertTable(table)...)
+			previous = "table-end"
+			table = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimRight(line, " \t")
+		if strings.HasPrefix(trimmed, "```") {
+			flushTable()
+			if !inCode && previous != "" && previous != "blank" {
+				out = append(out, "")
+			}
+			out = append(out, line)
+			inCode = !inCode
+			previous = "code"
+			continue
+		} else if inCode {
+			out = append(out, line)
+			continue
+		}
+		if m := tablePattern.FindStringSubmatch(trimmed); m != nil {
+			table = append(table, trimmed)
+			continue
+		}
+		flushTable()
+
+		prefix := ""
+		if inQuote {
+			prefix = "> "
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "<<<") && !inQuote:
+			block("quote-start")
+			inQuote = true
+			previous = ""
+		case strings.HasPrefix(trimmed, "<<<"):
+			// The text after the closing marker is the citation.
+			if cite := strings.TrimSpace(trimmed[3:]); cite != "" {
+				block("cite", "> — "+convertInline(cite))
+			}
+			inQuote = false
+			previous = "quote-end"
+		case trimmed == `"""`:
+			hardBreaks = !hardBreaks
+		case trimmed == "":
+			if previous != "blank" && previous != "" {
+				blank()
+				previous = "blank"
+			}
+		case headingPattern.MatchString(trimmed):
+			m := headingPattern.FindStringSubmatch(trimmed)
+			block("heading", prefix+strings.Repeat("#", len(m[1]))+" "+convertInline(m[2]))
+			previous = "heading-end"
+		case rulePattern.MatchString(trimmed):
+			block("rule", prefix+"---")
+			previous = "rule-end"
+		case listPattern.MatchString(trimmed):
+			m := listPattern.FindStringSubmatch(trimmed)
+			block("list", prefix+listItem(m[1], parser.EscapeLineStart(convertInline(m[2]))))
+		case quotePattern.MatchString(trimmed):
+			m := quotePattern.FindStringSubmatch(trimmed)
+			block("quote", prefix+strings.Repeat("> ", len(m[1]))+parser.EscapeLineStart(convertInline(m[2])))
+		default:
+			converted := parser.EscapeLineStart(convertInline(trimmed))
+			if hardBreaks {
+				converted += `\`
+			}
+			block("text", prefix+converted)
+		}
+	}
+	flushTable()
+	return strings.TrimSpace(strings.Join(out, "\n")) + "\n"
+}
+
+// listItem converts the markers of a WikiText list item, like "*#", into a
+// Markdown list item, indented to nest inside of the parent items.
+func listItem(markers, text string) string {
+	var indent strings.Builder
+	for _, m := range markers[:len(markers)-1] {
+		if m == '#' {
+			indent.WriteString("   ")
+		} else {
+			indent.WriteString("  ")
+		}
+	}
+	switch markers[len(markers)-1] {
+	case '#':
+		return indent.String() + "1. " + text
+	case ';':
+		// Definition lists become a bold term followed by its definitions.
+		return indent.String() + "- **" + text + "**"
+	case ':':
+		return indent.String() + "  " + text
+	default:
+		return indent.String() + "- " + text
+	}
+}
+
+// convertTable converts the rows of a WikiText table into a Markdown table.
+// Markdown tables require a header, so the first row is used if no row is
+// marked as the header.
+func convertTable(rows []string) []string {
+	var header []string
+	var body [][]string
+	columns := 0
+	for _, row := range rows {
+		m := tablePattern.FindStringSubmatch(row)
+		row = strings.TrimSuffix(row, m[1])
+		var cells []string
+		for _, cell := range strings.Split(row[1:len(row)-1], "|") {
+			cells = append(cells, convertInline(strings.TrimPrefix(strings.TrimSpace(cell), "!")))
+		}
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		switch {
+		case m[1] == "h" && header == nil:
+			header = cells
+		case m[1] == "c" || m[1] == "k":
+			// Captions and class names have no equivalent.
+		default:
+			body = append(body, cells)
+		}
+	}
+	if header == nil && len(body) > 0 {
+		header, body = body[0], body[1:]
+	}
+	formatRow := func(cells []string) string {
+		for len(cells) < columns {
+			cells = append(cells, "")
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+	out := []string{formatRow(header), strings.Repeat("| --- ", columns) + "|"}
+	for _, cells := range body {
+		out = append(out, formatRow(cells))
+	}
+	return out
+}
+
+// convertInline converts the inline markup of a line of WikiText.
+func convertInline(text string) string {
+	matches := inlinePattern.FindAllStringSubmatchIndex(text, -1)
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		sb.WriteString(parser.EscapeInline(text[last:m[0]]))
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] == -1 {
+				return ""
+			}
+			return text[m[2*i]:m[2*i+1]]
+		}
+		match := text[m[0]:m[1]]
+		switch {
+		case strings.HasPrefix(match, "`"), strings.HasPrefix(match, "<"), strings.HasPrefix(match, "&"):
+			sb.WriteString(match)
+		case strings.HasPrefix(match, "[img"):
+			sb.WriteString("![" + parser.EscapeInline(group(1)) + "](" + linkDestination(group(2)) + ")")
+		case strings.HasPrefix(match, "[ext["):
+			label := group(3)
+			if label == "" {
+				label = group(4)
+			}
+			sb.WriteString("[" + parser.EscapeInline(label) + "](<" + group(4) + ">)")
+		case strings.HasPrefix(match, "[["):
+			label, target := group(5), group(6)
+			if label == "" && !urlPattern.MatchString(target) {
+				sb.WriteString("[[" + target + "]]")
+			} else {
+				if label == "" {
+					label = target
+				}
+				sb.WriteString("[" + parser.EscapeInline(label) + "](" + linkDestination(target) + ")")
+			}
+		case strings.HasPrefix(match, "{{"):
+			sb.WriteString("![[" + strings.TrimSpace(group(7)) + "]]")
+		case m[16] != -1:
+			sb.WriteString("**" + convertInline(group(8)) + "**")
+		case m[18] != -1:
+			sb.WriteString("*" + convertInline(group(9)) + "*")
+		case m[20] != -1:
+			sb.WriteString("<u>" + convertInline(group(10)) + "</u>")
+		case m[22] != -1:
+			sb.WriteString("<sup>" + convertInline(group(11)) + "</sup>")
+		case m[24] != -1:
+			sb.WriteString("<sub>" + convertInline(group(12)) + "</sub>")
+		default:
+			// Bare URLs are links in WikiText, but not in Markdown.
+			sb.WriteString("<" + match + ">")
+		}
+	}
+	sb.WriteString(parser.EscapeInline(text[last:]))
+	return sb.String()
+}
+
+// linkDestination returns the Markdown destination for the target of a
+// link, which is either a URL or the title of a tiddler.
+func linkDestination(target string) string {
+	if urlPattern.MatchString(target) {
+		return "<" + target + ">"
+	}
+	return fmt.Sprintf("<[[%s]]>", target)
+}
@@ -0,0 +1,89 @@
+package webdav
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	"github.com/CGamesPlay/pilikino/lib/notedb/notedbtest"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestDatabase(t *testing.T) {
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && path.Base(r.URL.Path) == "Broken.md" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	dbURL, err := url.Parse(server.URL + "/dav/My Notes")
+	require.NoError(t, err)
+	dbURL.Scheme = "webdav+" + dbURL.Scheme
+
+	db, err := notedb.OpenDatabase(dbURL)
+	require.NoError(t, err)
+	_, err = db.Open(".")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, fs.MkdirAll(db, "Folder/Sub dir", 0777))
+	notedbtest.WriteFile(t, db, "Folder/Note #1.md", "# Note\n\nSee [other](../Other.md).\n")
+	notedbtest.WriteFile(t, db, "Other.md", "Other\n")
+	notedbtest.WriteFile(t, db, "image.png", "PNG")
+	require.NoError(t, fs.Chtimes(db, "Folder/Note #1.md", modTime, modTime))
+	require.NoError(t, fs.MkdirAll(db, "Folder", 0777))
+	require.Error(t, fs.MkdirAll(db, "Other.md", 0777))
+
+	var paths []string
+	err = fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		require.Equal(t, path != "." && path != "Folder" && path != "Folder/Sub dir", !d.IsDir(), path)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "Folder", "Folder/Note #1.md", "Folder/Sub dir", "Other.md", "image.png"}, paths)
+
+	f, err := db.Open("Folder/Note #1.md")
+	require.NoError(t, err)
+	note := f.(notedb.Note)
+	require.True(t, note.IsNote())
+	doc, err := note.ParseAST()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, renderer.NewRenderer().Render(&buf, note.Data(), doc))
+	require.Equal(t, "# Note\n\nSee [other](../Other.md).\n", buf.String())
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(info.ModTime()))
+	require.Equal(t, int64(len(note.Data())), info.Size())
+	require.NoError(t, f.Close())
+
+	data, err := fs.ReadFile(db, "image.png")
+	require.NoError(t, err)
+	require.Equal(t, "PNG", string(data))
+	_, err = fs.Stat(db, "Missing.md")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Notes are downloaded when they are opened.
+	notedbtest.WriteFile(t, db, "Broken.md", "Broken\n")
+	_, err = fs.Stat(db, "Broken.md")
+	require.NoError(t, err)
+	_, err = db.Open("Broken.md")
+	require.EqualError(t, err, "read Broken.md: server returned 500 Internal Server Error")
+}
@@ -0,0 +1,311 @@
+// Package enml renders a Markdown AST into ENML, the XHTML dialect used for
+// the content of Evernote notes.
+package enml
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+
+	mathjax "github.com/litao91/goldmark-mathjax"
+	"github.com/yuin/goldmark/ast"
+	extAST "github.com/yuin/goldmark/extension/ast"
+	"go.uber.org/multierr"
+)
+
+const header = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+`
+
+// Media describes a file which is attached to the note.
+type Media struct {
+	// Hash is the hex-encoded MD5 hash of the file contents.
+	Hash string
+	// Type is the MIME type of the file.
+	Type string
+}
+
+// MediaResolver determines whether the destination of a link or image refers
+// to an attached file.
+type MediaResolver func(dest []byte) (Media, bool)
+
+// Renderer renders a Markdown AST into ENML. It holds configuration only, and
+// is reusable across renders.
+type Renderer struct {
+	resolveMedia MediaResolver
+}
+
+type Option func(r *Renderer)
+
+// WithMediaResolver configures the renderer to embed attached files as
+// en-media elements.
+func WithMediaResolver(resolve MediaResolver) Option {
+	return func(r *Renderer) {
+		r.resolveMedia = resolve
+	}
+}
+
+func NewRenderer(opts ...Option) *Renderer {
+	r := &Renderer{}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// render represents a single ENML rendering operation.
+type render struct {
+	r      *Renderer
+	w      *bufio.Writer
+	source []byte
+	errs   error
+}
+
+// Render renders the document to the writer. Constructs which cannot be
+// represented in ENML are rendered as closely as possible and reported in the
+// returned error, which may contain multiple errors.
+func (r *Renderer) Render(w io.Writer, source []byte, node ast.Node) error {
+	rr := &render{r: r, w: bufio.NewWriter(w), source: source}
+	rr.w.WriteString(header)
+	if err := ast.Walk(node, rr.renderNode); err != nil {
+		return multierr.Append(rr.errs, err)
+	}
+	if err := rr.w.Flush(); err != nil {
+		return multierr.Append(rr.errs, err)
+	}
+	return rr.errs
+}
+
+func (r *render) unsupported(format string, a ...interface{}) {
+	r.errs = multierr.Append(r.errs, fmt.Errorf(format, a...))
+}
+
+func (r *render) tag(entering bool, open, close string) {
+	if entering {
+		r.w.WriteString(open)
+	} else {
+		r.w.WriteString(close)
+	}
+}
+
+func (r *render) text(value []byte) {
+	r.w.WriteString(html.EscapeString(string(value)))
+}
+
+// lines writes the raw lines of a block, escaped.
+func (r *render) lines(node ast.Node) {
+	for i := 0; i < node.Lines().Len(); i++ {
+		line := node.Lines().At(i)
+		r.text(line.Value(r.source))
+	}
+}
+
+func (r *render) renderNode(node ast.Node, entering bool) (ast.WalkStatus, error) {
+	switch tnode := node.(type) {
+	case *ast.Document:
+		r.tag(entering, "<en-note>", "</en-note>")
+
+	// Blocks.
+	case *ast.Paragraph:
+		r.tag(entering, "<div>", "</div>")
+	case *ast.TextBlock:
+		break
+	case *ast.Heading:
+		r.tag(entering, fmt.Sprintf("<h%d>", tnode.Level), fmt.Sprintf("</h%d>", tnode.Level))
+	case *ast.Blockquote:
+		r.tag(entering, "<blockquote>", "</blockquote>")
+	case *ast.List:
+		if tnode.IsOrdered() {
+			if tnode.Start > 1 {
+				r.tag(entering, fmt.Sprintf(`<ol start="%d">`, tnode.Start), "</ol>")
+			} else {
+				r.tag(entering, "<ol>", "</ol>")
+			}
+		} else {
+			r.tag(entering, "<ul>", "</ul>")
+		}
+	case *ast.ListItem:
+		r.tag(entering, "<li>", "</li>")
+	case *ast.ThematicBreak:
+		if entering {
+			r.w.WriteString("<hr/>")
+		}
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		if entering {
+			r.w.WriteString("<pre>")
+			r.lines(node)
+			r.w.WriteString("</pre>")
+		}
+		return ast.WalkSkipChildren, nil
+	case *ast.HTMLBlock:
+		if entering {
+			r.unsupported("HTML blocks are not supported in ENML")
+			r.w.WriteString("<pre>")
+			r.lines(node)
+			if tnode.HasClosure() {
+				r.text(tnode.ClosureLine.Value(r.source))
+			}
+			r.w.WriteString("</pre>")
+		}
+		return ast.WalkSkipChildren, nil
+	case *mathjax.MathBlock:
+		if entering {
+			r.unsupported("math blocks are not supported in ENML")
+			r.w.WriteString("<pre>")
+			r.lines(node)
+			r.w.WriteString("</pre>")
+		}
+		return ast.WalkSkipChildren, nil
+	case *extAST.Table:
+		r.tag(entering, "<table>", "</table>")
+	case *extAST.TableHeader, *extAST.TableRow:
+		r.tag(entering, "<tr>", "</tr>")
+	case *extAST.TableCell:
+		name := "td"
+		if _, isHeader := node.Parent().(*extAST.TableHeader); isHeader {
+			name = "th"
+		}
+		if !entering {
+			r.w.WriteString("</" + name + ">")
+			break
+		}
+		switch tnode.Alignment {
+		case extAST.AlignLeft, extAST.AlignRight, extAST.AlignCenter:
+			fmt.Fprintf(r.w, `<%s style="text-align:%s;">`, name, tnode.Alignment.String())
+		default:
+			r.w.WriteString("<" + name + ">")
+		}
+
+	// Spans.
+	case *ast.Text:
+		if !entering {
+			break
+		}
+		r.text(tnode.Segment.Value(r.source))
+		if tnode.HardLineBreak() {
+			r.w.WriteString("<br/>")
+		} else if tnode.SoftLineBreak() {
+			r.w.WriteString("\n")
+		}
+	case *ast.String:
+		if entering {
+			r.text(tnode.Value)
+		}
+	case *ast.CodeSpan:
+		r.tag(entering, "<code>", "</code>")
+	case *ast.Emphasis:
+		if tnode.Level == 2 {
+			r.tag(entering, "<b>", "</b>")
+		} else {
+			r.tag(entering, "<i>", "</i>")
+		}
+	case *extAST.Strikethrough:
+		r.tag(entering, "<s>", "</s>")
+	case *extAST.TaskCheckBox:
+		if entering {
+			if tnode.IsChecked {
+				r.w.WriteString(`<en-todo checked="true"/>`)
+			} else {
+				r.w.WriteString(`<en-todo checked="false"/>`)
+			}
+		}
+	case *ast.AutoLink:
+		if entering {
+			url := tnode.URL(r.source)
+			if tnode.AutoLinkType == ast.AutoLinkEmail {
+				url = append([]byte("mailto:"), url...)
+			}
+			fmt.Fprintf(r.w, `<a href="%s">`, html.EscapeString(string(url)))
+			r.text(tnode.Label(r.source))
+			r.w.WriteString("</a>")
+		}
+	case *ast.Link:
+		return r.renderLink(tnode, entering), nil
+	case *ast.Image:
+		return r.renderImage(tnode, entering), nil
+	case *ast.RawHTML:
+		if entering {
+			r.unsupported("inline HTML is not supported in ENML")
+			for i := 0; i < tnode.Segments.Len(); i++ {
+				segment := tnode.Segments.At(i)
+				r.text(segment.Value(r.source))
+			}
+		}
+	case *mathjax.InlineMath:
+		if entering {
+			r.unsupported("inline math is not supported in ENML")
+		}
+		r.tag(entering, "<code>", "</code>")
+	default:
+		return ast.WalkStop, fmt.Errorf("detected unexpected tree type %s", tnode.Kind().String())
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *render) media(dest []byte) (Media, bool) {
+	if r.r.resolveMedia == nil {
+		return Media{}, false
+	}
+	return r.r.resolveMedia(dest)
+}
+
+func (r *render) writeMedia(m Media) {
+	fmt.Fprintf(r.w, `<en-media hash="%s" type="%s"/>`, m.Hash, html.EscapeString(m.Type))
+}
+
+func (r *render) renderLink(node *ast.Link, entering bool) ast.WalkStatus {
+	if m, ok := r.media(node.Destination); ok {
+		if entering {
+			r.writeMedia(m)
+		}
+		return ast.WalkSkipChildren
+	}
+	if !isAbsoluteURL(node.Destination) {
+		if entering {
+			r.unsupported("links between notes are not supported in ENML: %s", node.Destination)
+		}
+		return ast.WalkContinue
+	}
+	if entering {
+		fmt.Fprintf(r.w, `<a href="%s"`, html.EscapeString(string(node.Destination)))
+		if len(node.Title) > 0 {
+			fmt.Fprintf(r.w, ` title="%s"`, html.EscapeString(string(node.Title)))
+		}
+		r.w.WriteString(">")
+	} else {
+		r.w.WriteString("</a>")
+	}
+	return ast.WalkContinue
+}
+
+func (r *render) renderImage(node *ast.Image, entering bool) ast.WalkStatus {
+	if !entering {
+		return ast.WalkContinue
+	}
+	if m, ok := r.media(node.Destination); ok {
+		r.writeMedia(m)
+	} else if isAbsoluteURL(node.Destination) {
+		fmt.Fprintf(r.w, `<img src="%s" alt="%s"/>`,
+			html.EscapeString(string(node.Destination)),
+			html.EscapeString(string(node.Text(r.source))))
+	} else {
+		r.unsupported("missing image: %s", node.Destination)
+		r.text(node.Text(r.source))
+	}
+	return ast.WalkSkipChildren
+}
+
+func isAbsoluteURL(dest []byte) bool {
+	for i, c := range dest {
+		switch {
+		case c == ':':
+			return i > 0
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return false
+}
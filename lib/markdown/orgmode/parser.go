@@ -0,0 +1,686 @@
+// Package orgmode converts between Org mode documents and the Markdown AST
+// used by the rest of Pilikino. Parse reads an Org document into the AST, and
+// Renderer writes the AST back out as Org.
+package orgmode
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	mathjax "github.com/litao91/goldmark-mathjax"
+	"github.com/yuin/goldmark/ast"
+	extAST "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"go.uber.org/multierr"
+)
+
+var (
+	headingPattern  = regexp.MustCompile(`^(\*+)[ \t]+(.*?)(?:[ \t]+(:[\w@#%:]+:))?[ \t]*$`)
+	priorityPattern = regexp.MustCompile(`^\[#[A-Z]\][ \t]*`)
+	listPattern     = regexp.MustCompile(`^([ \t]*)([-+*]|\d+[.)])(?:[ \t]+|$)`)
+	blockPattern    = regexp.MustCompile(`(?i)^[ \t]*#\+begin_(\w+)(.*)$`)
+	keywordPattern  = regexp.MustCompile(`^[ \t]*#\+(\w+):[ \t]*(.*)$`)
+	drawerPattern   = regexp.MustCompile(`^[ \t]*:([\w-]+):[ \t]*$`)
+	drawerEnd       = regexp.MustCompile(`(?i)^[ \t]*:end:[ \t]*$`)
+	propertyPattern = regexp.MustCompile(`^[ \t]*:([\w-]+):[ \t]*(.*)$`)
+	rulePattern     = regexp.MustCompile(`^[ \t]*-{5,}[ \t]*$`)
+	planningPattern = regexp.MustCompile(`^[ \t]*(?:SCHEDULED|DEADLINE|CLOSED):`)
+	mathEnd         = regexp.MustCompile(`^[ \t]*\\\][ \t]*$`)
+)
+
+// Keyword is a setting from the start of an Org document, either a
+// #+KEYWORD: line or an entry in the top-level property drawer.
+type Keyword struct {
+	Key   string
+	Value string
+}
+
+// Keywords returns the keywords and properties which appear before the first
+// heading of the document.
+func Keywords(input []byte) []Keyword {
+	var ret []Keyword
+	inDrawer := false
+	for _, l := range splitLines(input) {
+		line := string(input[l.start:l.end])
+		switch {
+		case headingPattern.MatchString(line):
+			return ret
+		case drawerEnd.MatchString(line):
+			inDrawer = false
+		case strings.EqualFold(strings.TrimSpace(line), ":PROPERTIES:"):
+			inDrawer = true
+		case inDrawer:
+			if m := propertyPattern.FindStringSubmatch(line); m != nil {
+				ret = append(ret, Keyword{m[1], strings.TrimSpace(m[2])})
+			}
+		default:
+			if m := keywordPattern.FindStringSubmatch(line); m != nil {
+				ret = append(ret, Keyword{strings.ToUpper(m[1]), strings.TrimSpace(m[2])})
+			}
+		}
+	}
+	return ret
+}
+
+// line is the location of a line in the input, not including the line
+// ending. Lines inside of list items are shifted past the indentation of the
+// item.
+type line struct {
+	start, end int
+}
+
+func splitLines(input []byte) []line {
+	var lines []line
+	for start := 0; start < len(input); {
+		end := bytes.IndexByte(input[start:], '\n')
+		next := start + end + 1
+		if end == -1 {
+			end = len(input) - start
+			next = len(input)
+		}
+		stop := start + end
+		if stop > start && input[stop-1] == '\r' {
+			stop--
+		}
+		lines = append(lines, line{start, stop})
+		start = next
+	}
+	return lines
+}
+
+// maxHeadingLevel is the deepest heading which Markdown supports.
+const maxHeadingLevel = 6
+
+// Parse parses an Org document into a Markdown AST. The nodes refer to the
+// input, which must be used as the source when rendering the AST.
+//
+// Headings keep their TODO keywords, and their tags are written after the
+// title as #tags. The property drawer of a heading becomes the attributes of
+// the heading, with the CUSTOM_ID property as its id. Headings nested deeper
+// than Markdown allows become paragraphs of bold text, which are reported in
+// the returned error. Other drawers, comments, and keywords are omitted.
+func Parse(input []byte) (ast.Node, error) {
+	p := &orgParser{source: input}
+	doc := ast.NewDocument()
+	p.parseBlocks(doc, splitLines(input), true)
+	return doc, p.errs
+}
+
+type orgParser struct {
+	source []byte
+	errs   error
+}
+
+func (p *orgParser) text(l line) string {
+	return string(p.source[l.start:l.end])
+}
+
+func (p *orgParser) isBlank(l line) bool {
+	return strings.TrimSpace(p.text(l)) == ""
+}
+
+// indent returns the width of the indentation of the line.
+func (p *orgParser) indent(l line) int {
+	t := p.text(l)
+	return len(t) - len(strings.TrimLeft(t, " \t"))
+}
+
+// parseBlocks parses the lines into blocks, which are added to the parent.
+// Headings are only recognized at the top level of the document.
+func (p *orgParser) parseBlocks(parent ast.Node, lines []line, top bool) {
+	blankBefore := false
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		t := p.text(l)
+		var node ast.Node
+		next := i + 1
+		switch {
+		case p.isBlank(l):
+			blankBefore = true
+			i++
+			continue
+		case top && headingPattern.MatchString(t):
+			node = p.parseHeading(l)
+		case drawerPattern.MatchString(t) && p.findLine(lines, i+1, drawerEnd) != -1:
+			end := p.findLine(lines, i+1, drawerEnd)
+			if heading := p.drawerHeading(parent, lines, i); heading != nil && strings.EqualFold(strings.TrimSpace(t), ":PROPERTIES:") {
+				p.setProperties(heading, lines[i+1:end])
+			}
+			i = end + 1
+			continue
+		case blockPattern.MatchString(t) && p.findBlockEnd(lines, i) != -1:
+			end := p.findBlockEnd(lines, i)
+			node = p.parseBlock(lines[i], lines[i+1:end])
+			next = end + 1
+		case keywordPattern.MatchString(t) || isComment(t):
+			// Keywords and comments are not part of the text.
+			i++
+			continue
+		case strings.HasPrefix(strings.TrimSpace(t), "|"):
+			for next < len(lines) && strings.HasPrefix(strings.TrimSpace(p.text(lines[next])), "|") {
+				next++
+			}
+			node = p.parseTable(lines[i:next])
+		case rulePattern.MatchString(t):
+			node = ast.NewThematicBreak()
+		case strings.TrimSpace(t) == `\[`:
+			end := p.findLine(lines, i+1, mathEnd)
+			if end == -1 {
+				node, next = p.parseParagraph(lines, i, top)
+				break
+			}
+			block := mathjax.NewMathBlock()
+			for _, inner := range lines[i+1 : end] {
+				block.Lines().Append(p.lineSegment(inner))
+			}
+			node = block
+			next = end + 1
+		case p.isListItem(t, top):
+			node, next = p.parseList(lines, i, top)
+		default:
+			node, next = p.parseParagraph(lines, i, top)
+		}
+		node.SetBlankPreviousLines(blankBefore)
+		blankBefore = false
+		parent.AppendChild(parent, node)
+		i = next
+	}
+}
+
+// isComment reports whether the line is a comment, which is not part of the
+// text.
+func isComment(t string) bool {
+	t = strings.TrimLeft(t, " \t")
+	return t == "#" || strings.HasPrefix(t, "# ")
+}
+
+// findLine returns the index of the first line at or after start which
+// matches the pattern, or -1.
+func (p *orgParser) findLine(lines []line, start int, pattern *regexp.Regexp) int {
+	for i := start; i < len(lines); i++ {
+		if pattern.MatchString(p.text(lines[i])) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findBlockEnd returns the index of the #+END line matching the #+BEGIN line
+// at the index, or -1.
+func (p *orgParser) findBlockEnd(lines []line, begin int) int {
+	name := blockPattern.FindStringSubmatch(p.text(lines[begin]))[1]
+	end := regexp.MustCompile(`(?i)^[ \t]*#\+end_` + regexp.QuoteMeta(name) + `[ \t]*$`)
+	return p.findLine(lines, begin+1, end)
+}
+
+// lineSegment returns the segment for a line of a code or math block, which
+// includes the line ending.
+func (p *orgParser) lineSegment(l line) text.Segment {
+	end := l.end
+	if end < len(p.source) && p.source[end] == '\r' {
+		end++
+	}
+	if end < len(p.source) && p.source[end] == '\n' {
+		end++
+	}
+	return text.NewSegment(l.start, end)
+}
+
+func (p *orgParser) isListItem(t string, top bool) bool {
+	m := listPattern.FindStringSubmatch(t)
+	if m == nil {
+		return false
+	}
+	// At the top level, a star at the start of the line is a heading.
+	return m[2] != "*" || !top || m[1] != ""
+}
+
+// drawerHeading returns the heading which the drawer at the index belongs
+// to, or nil. A drawer belongs to the heading directly before it, or before
+// the planning line which follows the heading.
+func (p *orgParser) drawerHeading(parent ast.Node, lines []line, i int) *ast.Heading {
+	last := parent.LastChild()
+	if i > 0 && planningPattern.MatchString(p.text(lines[i-1])) && last != nil {
+		last = last.PreviousSibling()
+	}
+	heading, _ := last.(*ast.Heading)
+	return heading
+}
+
+// setProperties stores the properties in the lines of a property drawer as
+// attributes of the heading.
+func (p *orgParser) setProperties(heading *ast.Heading, lines []line) {
+	for _, l := range lines {
+		m := propertyPattern.FindStringSubmatch(p.text(l))
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		if strings.EqualFold(name, "CUSTOM_ID") {
+			name = "id"
+		}
+		heading.SetAttributeString(name, []byte(strings.TrimSpace(m[2])))
+	}
+}
+
+func (p *orgParser) parseHeading(l line) ast.Node {
+	t := p.text(l)
+	m := headingPattern.FindSubmatchIndex(p.source[l.start:l.end])
+	level := m[3] - m[2]
+	heading := ast.NewHeading(level)
+	start, end := l.start+m[4], l.start+m[5]
+	// Drop the priority cookie, but keep the TODO keyword, which is
+	// meaningful to readers.
+	if fields := strings.SplitN(t[m[4]:m[5]], " ", 2); len(fields) == 2 && (fields[0] == "TODO" || fields[0] == "DONE") {
+		if cookie := priorityPattern.FindString(fields[1]); cookie != "" {
+			p.parseInline(heading, start, start+len(fields[0])+1)
+			start += len(fields[0]) + 1 + len(cookie)
+		}
+	} else if cookie := priorityPattern.FindString(t[m[4]:m[5]]); cookie != "" {
+		start += len(cookie)
+	}
+	p.parseInline(heading, start, end)
+	if m[6] != -1 {
+		var tags []string
+		for _, tag := range strings.Split(strings.Trim(t[m[6]:m[7]], ":"), ":") {
+			if tag != "" {
+				tags = append(tags, "#"+tag)
+			}
+		}
+		heading.AppendChild(heading, ast.NewString([]byte(" "+strings.Join(tags, " "))))
+	}
+	if level > maxHeadingLevel {
+		// Markdown has no deeper headings, and using a shallower level would
+		// change the structure of the document, so the heading is kept as
+		// bold text instead.
+		p.errs = multierr.Append(p.errs, fmt.Errorf("heading at level %d is deeper than Markdown allows, converted to bold text: %s", level, strings.TrimSpace(t[m[4]:m[5]])))
+		strong := ast.NewEmphasis(2)
+		for c := heading.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			strong.AppendChild(strong, c)
+			c = next
+		}
+		para := ast.NewParagraph()
+		para.AppendChild(para, strong)
+		return para
+	}
+	return heading
+}
+
+// parseBlock parses a #+BEGIN_NAME ... #+END_NAME block.
+func (p *orgParser) parseBlock(begin line, lines []line) ast.Node {
+	m := blockPattern.FindSubmatchIndex(p.source[begin.start:begin.end])
+	name := strings.ToLower(string(p.source[begin.start+m[2] : begin.start+m[3]]))
+	switch name {
+	case "src", "example", "export":
+		var block ast.Node
+		args := bytes.TrimSpace(p.source[begin.start+m[4] : begin.start+m[5]])
+		if len(args) > 0 && name != "example" {
+			// The first argument is the language.
+			langStart := begin.start + m[4] + bytes.Index(p.source[begin.start+m[4]:begin.start+m[5]], args)
+			langEnd := langStart + len(bytes.Fields(args)[0])
+			block = ast.NewFencedCodeBlock(ast.NewTextSegment(text.NewSegment(langStart, langEnd)))
+		} else {
+			block = ast.NewCodeBlock()
+		}
+		for _, l := range lines {
+			segment := p.lineSegment(l)
+			// Org escapes lines which would otherwise be parsed as headings
+			// or keywords with a comma.
+			if t := strings.TrimLeft(p.text(l), " \t"); strings.HasPrefix(t, ",*") || strings.HasPrefix(t, ",#+") || strings.HasPrefix(t, ",,") {
+				comma := segment.Start + strings.IndexByte(p.text(l), ',')
+				block.Lines().Append(text.NewSegment(segment.Start, comma))
+				segment = text.NewSegment(comma+1, segment.Stop)
+			}
+			block.Lines().Append(segment)
+		}
+		return block
+	case "quote":
+		quote := ast.NewBlockquote()
+		p.parseBlocks(quote, lines, false)
+		return quote
+	default:
+		// Other blocks, such as verse and center, have no equivalent, so
+		// only the contents are kept.
+		para := ast.NewParagraph()
+		for i, l := range lines {
+			p.parseInline(para, l.start, l.end)
+			if i != len(lines)-1 {
+				p.softBreak(para, l.end)
+			}
+		}
+		return para
+	}
+}
+
+// softBreak ends the current line of an inline container.
+func (p *orgParser) softBreak(parent ast.Node, pos int) {
+	last, ok := parent.LastChild().(*ast.Text)
+	if !ok {
+		last = ast.NewTextSegment(text.NewSegment(pos, pos))
+		parent.AppendChild(parent, last)
+	}
+	last.SetSoftLineBreak(true)
+}
+
+func (p *orgParser) parseParagraph(lines []line, start int, top bool) (ast.Node, int) {
+	para := ast.NewParagraph()
+	i := start
+	for ; i < len(lines); i++ {
+		t := p.text(lines[i])
+		if i > start && (p.isBlank(lines[i]) || (top && headingPattern.MatchString(t)) ||
+			blockPattern.MatchString(t) || keywordPattern.MatchString(t) || isComment(t) ||
+			p.isListItem(t, top) || strings.HasPrefix(strings.TrimSpace(t), "|") ||
+			drawerPattern.MatchString(t) || rulePattern.MatchString(t) || strings.TrimSpace(t) == `\[`) {
+			break
+		}
+		if i > start {
+			p.softBreak(para, lines[i-1].end)
+		}
+		trimmed := lines[i]
+		trimmed.start += p.indent(trimmed)
+		p.parseInline(para, trimmed.start, trimmed.end)
+	}
+	return para, i
+}
+
+func (p *orgParser) parseTable(lines []line) ast.Node {
+	table := extAST.NewTable()
+	var rows [][]line
+	for _, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(p.text(l)), "|-") {
+			continue
+		}
+		// Split the row into cells, not including the outer pipes.
+		start := l.start + strings.IndexByte(p.text(l), '|') + 1
+		end := l.end
+		for end > start && (p.source[end-1] == ' ' || p.source[end-1] == '\t') {
+			end--
+		}
+		if end > start && p.source[end-1] == '|' {
+			end--
+		}
+		var cells []line
+		for pos := start; ; {
+			sep := bytes.IndexByte(p.source[pos:end], '|')
+			if sep == -1 {
+				cells = append(cells, line{pos, end})
+				break
+			}
+			cells = append(cells, line{pos, pos + sep})
+			pos += sep + 1
+		}
+		rows = append(rows, cells)
+	}
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	alignments := make([]extAST.Alignment, columns)
+	for i := range alignments {
+		alignments[i] = extAST.AlignNone
+	}
+	for i, cells := range rows {
+		row := extAST.NewTableRow(alignments)
+		for c := 0; c < columns; c++ {
+			cell := extAST.NewTableCell()
+			if c < len(cells) {
+				l := cells[c]
+				for l.start < l.end && (p.source[l.start] == ' ' || p.source[l.start] == '\t') {
+					l.start++
+				}
+				for l.end > l.start && (p.source[l.end-1] == ' ' || p.source[l.end-1] == '\t') {
+					l.end--
+				}
+				p.parseInline(cell, l.start, l.end)
+			}
+			row.AppendChild(row, cell)
+		}
+		// Markdown tables always have a header, so the first row is used
+		// even when the Org table does not have one.
+		if i == 0 {
+			table.AppendChild(table, extAST.NewTableHeader(row))
+		} else {
+			table.AppendChild(table, row)
+		}
+	}
+	return table
+}
+
+// parseList parses the list starting at the line, returning the list and the
+// index of the line after it.
+func (p *orgParser) parseList(lines []line, start int, top bool) (ast.Node, int) {
+	first := listPattern.FindStringSubmatch(p.text(lines[start]))
+	indent := len(first[1])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	var list *ast.List
+	if ordered {
+		list = ast.NewList(first[2][len(first[2])-1])
+		list.Start, _ = strconv.Atoi(first[2][:len(first[2])-1])
+	} else {
+		list = ast.NewList('-')
+	}
+	list.IsTight = true
+
+	// continues reports whether the line is another item of this list.
+	continues := func(l line) bool {
+		m := listPattern.FindStringSubmatch(p.text(l))
+		if m == nil || len(m[1]) != indent || !p.isListItem(p.text(l), top) {
+			return false
+		}
+		return (m[2][0] >= '0' && m[2][0] <= '9') == ordered
+	}
+
+	i := start
+	for i < len(lines) && continues(lines[i]) {
+		m := listPattern.FindStringSubmatch(p.text(lines[i]))
+		// The content of the item is everything indented past the bullet,
+		// and continues until a line which is not indented or two blank
+		// lines in a row.
+		contentIndent := len(m[0])
+		itemLines := []line{{lines[i].start + contentIndent, lines[i].end}}
+		j := i + 1
+		blanks := 0
+		for ; j < len(lines); j++ {
+			if p.isBlank(lines[j]) {
+				blanks++
+				if blanks == 2 {
+					break
+				}
+				itemLines = append(itemLines, lines[j])
+				continue
+			}
+			if p.indent(lines[j]) <= indent {
+				break
+			}
+			blanks = 0
+			shift := p.indent(lines[j])
+			if shift > contentIndent {
+				shift = contentIndent
+			}
+			itemLines = append(itemLines, line{lines[j].start + shift, lines[j].end})
+		}
+		// Trailing blank lines belong to the list, not the item.
+		for len(itemLines) > 1 && p.isBlank(itemLines[len(itemLines)-1]) {
+			itemLines = itemLines[:len(itemLines)-1]
+			j--
+		}
+		for k := 1; k < len(itemLines); k++ {
+			if p.isBlank(itemLines[k]) {
+				list.IsTight = false
+			}
+		}
+
+		item := ast.NewListItem(contentIndent)
+		p.parseBlocks(item, itemLines, false)
+		if para, ok := item.FirstChild().(*ast.Paragraph); ok && list.IsTight {
+			// Tight list items hold their text directly.
+			block := ast.NewTextBlock()
+			for c := para.FirstChild(); c != nil; {
+				next := c.NextSibling()
+				block.AppendChild(block, c)
+				c = next
+			}
+			item.ReplaceChild(item, para, block)
+		}
+		if i > start && i > 0 && p.isBlank(lines[i-1]) {
+			item.SetBlankPreviousLines(true)
+			list.IsTight = false
+		}
+		list.AppendChild(list, item)
+		i = j
+		// Skip a single blank line between items.
+		if i+1 < len(lines) && p.isBlank(lines[i]) && continues(lines[i+1]) {
+			i++
+		}
+	}
+	return list, i
+}
+
+var (
+	emphasisPre  = " \t('\"{-"
+	emphasisPost = " \t-.,;:!?')}\"["
+)
+
+// parseInline parses the text between the offsets into inline nodes, which
+// are added to the parent.
+func (p *orgParser) parseInline(parent ast.Node, start, end int) {
+	src := p.source
+	textStart := start
+	flush := func(upto int) {
+		if upto > textStart {
+			parent.AppendChild(parent, ast.NewTextSegment(text.NewSegment(textStart, upto)))
+		}
+	}
+	for pos := start; pos < end; {
+		c := src[pos]
+		switch {
+		case c == '[' && pos+1 < end && src[pos+1] == '[':
+			closing := bytes.Index(src[pos+2:end], []byte("]]"))
+			if closing == -1 {
+				break
+			}
+			closing += pos + 2
+			flush(pos)
+			parent.AppendChild(parent, p.parseLink(pos+2, closing))
+			pos = closing + 2
+			textStart = pos
+			continue
+		case c == '\\' && pos+1 < end && src[pos+1] == '(':
+			closing := bytes.Index(src[pos+2:end], []byte(`\)`))
+			if closing == -1 {
+				break
+			}
+			closing += pos + 2
+			flush(pos)
+			math := mathjax.NewInlineMath()
+			math.AppendChild(math, ast.NewRawTextSegment(text.NewSegment(pos+2, closing)))
+			parent.AppendChild(parent, math)
+			pos = closing + 2
+			textStart = pos
+			continue
+		case strings.IndexByte("*/+=~", c) != -1 && (pos == start || strings.IndexByte(emphasisPre, src[pos-1]) != -1):
+			closing := p.findEmphasisEnd(c, pos, end)
+			if closing == -1 {
+				break
+			}
+			flush(pos)
+			var node ast.Node
+			switch c {
+			case '=', '~':
+				node = ast.NewCodeSpan()
+				node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(pos+1, closing)))
+			case '*':
+				node = ast.NewEmphasis(2)
+				p.parseInline(node, pos+1, closing)
+			case '/':
+				node = ast.NewEmphasis(1)
+				p.parseInline(node, pos+1, closing)
+			case '+':
+				node = extAST.NewStrikethrough()
+				p.parseInline(node, pos+1, closing)
+			}
+			parent.AppendChild(parent, node)
+			pos = closing + 1
+			textStart = pos
+			continue
+		}
+		pos++
+	}
+	flush(end)
+}
+
+// findEmphasisEnd finds the closing marker for emphasis which starts at the
+// position, or -1.
+func (p *orgParser) findEmphasisEnd(marker byte, start, end int) int {
+	src := p.source
+	if start+1 >= end || src[start+1] == ' ' || src[start+1] == '\t' {
+		return -1
+	}
+	for i := start + 2; i < end; i++ {
+		if src[i] != marker || src[i-1] == ' ' || src[i-1] == '\t' {
+			continue
+		}
+		if i+1 == end || strings.IndexByte(emphasisPost, src[i+1]) != -1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseLink parses the contents of [[target][description]].
+func (p *orgParser) parseLink(start, end int) ast.Node {
+	targetEnd, descStart := end, -1
+	if sep := bytes.Index(p.source[start:end], []byte("][")); sep != -1 {
+		targetEnd = start + sep
+		descStart = targetEnd + 2
+	}
+	target := string(p.source[start:targetEnd])
+	dest, isFile := linkDestination(target)
+	link := ast.NewLink()
+	link.Destination = []byte(dest)
+	if descStart == -1 {
+		if isFile && parser.IsImagePath(dest) {
+			link.AppendChild(link, ast.NewTextSegment(text.NewSegment(start, targetEnd)))
+			return ast.NewImage(link)
+		}
+		link.AppendChild(link, ast.NewTextSegment(text.NewSegment(start, targetEnd)))
+		return link
+	}
+	p.parseInline(link, descStart, end)
+	return link
+}
+
+// linkDestination converts the target of an Org link into the destination
+// of a Markdown link, reporting whether it refers to a file.
+func linkDestination(target string) (string, bool) {
+	switch {
+	case strings.HasPrefix(target, "file:"):
+		target = strings.TrimPrefix(target, "file:")
+	case strings.HasPrefix(target, "./"), strings.HasPrefix(target, "../"), strings.HasPrefix(target, "/"):
+	case strings.HasPrefix(target, "*"):
+		return (&url.URL{Fragment: strings.TrimSpace(target[1:])}).String(), false
+	case strings.HasPrefix(target, "#"):
+		return (&url.URL{Fragment: target[1:]}).String(), false
+	default:
+		return target, false
+	}
+	// Search options which refer to a heading or custom ID, like
+	// file:notes.org::*Heading, become the fragment. Other search options are
+	// not supported.
+	fragment := ""
+	if idx := strings.Index(target, "::"); idx != -1 {
+		if search := target[idx+2:]; strings.HasPrefix(search, "*") || strings.HasPrefix(search, "#") {
+			fragment = strings.TrimSpace(search[1:])
+		}
+		target = target[:idx]
+	}
+	return (&url.URL{Path: target, Fragment: fragment}).String(), true
+}
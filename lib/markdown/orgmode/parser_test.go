@@ -0,0 +1,48 @@
+package orgmode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	input := `#+TITLE: Notes
+* TODO [#A] Plan :work:
+SCHEDULED: <2021-06-01 Tue>
+:PROPERTIES:
+:CUSTOM_ID: plan
+:CREATED: [2021-06-01]
+:END:
+Some *bold* and [[file:other.org::*Intro][a link]].
+******* Too deep :x:
+:LOGBOOK:
+- Note taken
+:END:
+- item
+`
+	doc, err := Parse([]byte(input))
+	require.EqualError(t, err, "heading at level 7 is deeper than Markdown allows, converted to bold text: Too deep")
+	var buf bytes.Buffer
+	require.NoError(t, renderer.NewRenderer().Render(&buf, []byte(input), doc))
+	require.Equal(t, `# TODO Plan #work {#plan CREATED="[2021-06-01]"}
+
+SCHEDULED: <2021-06-01 Tue>
+
+Some **bold** and [a link](other.org#Intro).
+
+**Too deep #x**
+- item
+`, buf.String())
+}
+
+func TestKeywords(t *testing.T) {
+	input := "#+TITLE: Notes\n:PROPERTIES:\n:ID: 1234\n:END:\n#+filetags: :a:b:\n* Heading\n#+AUTHOR: Nobody\n"
+	require.Equal(t, []Keyword{
+		{"TITLE", "Notes"},
+		{"ID", "1234"},
+		{"FILETAGS", ":a:b:"},
+	}, Keywords([]byte(input)))
+}
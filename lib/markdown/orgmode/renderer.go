@@ -0,0 +1,384 @@
+package orgmode
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"unicode"
+
+	mathjax "github.com/litao91/goldmark-mathjax"
+	"github.com/mattn/go-runewidth"
+	"github.com/yuin/goldmark/ast"
+	extAST "github.com/yuin/goldmark/extension/ast"
+	"go.uber.org/multierr"
+)
+
+// Renderer renders a Markdown AST into an Org document. It holds
+// configuration only, and is reusable across renders.
+type Renderer struct{}
+
+type Option func(r *Renderer)
+
+func NewRenderer(opts ...Option) *Renderer {
+	r := &Renderer{}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// render represents a single Org rendering operation.
+type render struct {
+	r      *Renderer
+	source []byte
+	errs   error
+}
+
+// Render renders the document to the writer. Constructs which cannot be
+// represented in Org are omitted and reported in the returned error, which
+// may contain multiple errors.
+func (r *Renderer) Render(w io.Writer, source []byte, node ast.Node) error {
+	rr := &render{r: r, source: source}
+	var text string
+	if _, ok := node.(*ast.Document); ok {
+		text = rr.blocks(node)
+	} else if node.Type() == ast.TypeInline {
+		text = rr.inline(node)
+	} else {
+		text = rr.block(node)
+	}
+	if text != "" {
+		text += "\n"
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		return multierr.Append(rr.errs, err)
+	}
+	return rr.errs
+}
+
+func (r *render) unsupported(format string, a ...interface{}) {
+	r.errs = multierr.Append(r.errs, fmt.Errorf(format, a...))
+}
+
+// blocks renders the children of the node, which must all be blocks. Tight
+// text is followed directly by the next block, and other blocks are
+// separated by blank lines.
+func (r *render) blocks(parent ast.Node) string {
+	var sb strings.Builder
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		text := r.block(c)
+		if text == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			if _, ok := c.PreviousSibling().(*ast.TextBlock); ok {
+				sb.WriteString("\n")
+			} else {
+				sb.WriteString("\n\n")
+			}
+		}
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
+
+// block renders a single block, without a trailing newline.
+func (r *render) block(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inline(n)
+	case *ast.Heading:
+		return strings.Repeat("*", n.Level) + " " + headingTags(r.inline(n)) + properties(n)
+	case *ast.Blockquote:
+		return "#+BEGIN_QUOTE\n" + r.blocks(n) + "\n#+END_QUOTE"
+	case *ast.List:
+		return r.list(n)
+	case *ast.ThematicBreak:
+		return "-----"
+	case *ast.FencedCodeBlock:
+		if lang := n.Language(r.source); len(lang) > 0 {
+			return "#+BEGIN_SRC " + string(lang) + "\n" + r.lines(n, true) + "#+END_SRC"
+		}
+		return "#+BEGIN_EXAMPLE\n" + r.lines(n, true) + "#+END_EXAMPLE"
+	case *ast.CodeBlock:
+		return "#+BEGIN_EXAMPLE\n" + r.lines(n, true) + "#+END_EXAMPLE"
+	case *ast.HTMLBlock:
+		text := r.lines(n, true)
+		if n.HasClosure() {
+			text += string(n.ClosureLine.Value(r.source))
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+		}
+		return "#+BEGIN_EXPORT html\n" + text + "#+END_EXPORT"
+	case *mathjax.MathBlock:
+		return "\\[\n" + r.lines(n, false) + "\\]"
+	case *extAST.Table:
+		return r.table(n)
+	default:
+		r.unsupported("detected unexpected tree type %s", node.Kind().String())
+		return ""
+	}
+}
+
+// properties returns the property drawer holding the attributes of the
+// heading, including the line ending which separates it from the heading, or
+// an empty string if it has none. The id attribute is the CUSTOM_ID property.
+func properties(n *ast.Heading) string {
+	attrs := n.Attributes()
+	if len(attrs) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n:PROPERTIES:\n")
+	for _, attr := range attrs {
+		name := string(attr.Name)
+		if name == "id" {
+			name = "CUSTOM_ID"
+		}
+		fmt.Fprintf(&sb, ":%s: %s\n", name, attr.Value)
+	}
+	sb.WriteString(":END:")
+	return sb.String()
+}
+
+// lines returns the raw lines of a block, ending with a newline. Lines which
+// Org would otherwise interpret are escaped with a comma.
+func (r *render) lines(node ast.Node, escape bool) string {
+	var sb strings.Builder
+	for i := 0; i < node.Lines().Len(); i++ {
+		line := node.Lines().At(i)
+		sb.Write(line.Value(r.source))
+	}
+	text := sb.String()
+	if text == "" {
+		return ""
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if !escape {
+		return text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "*") || strings.HasPrefix(trimmed, "#+") || strings.HasPrefix(trimmed, ",") {
+			lines[i] = line[:len(line)-len(trimmed)] + "," + trimmed
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func (r *render) list(n *ast.List) string {
+	var sb strings.Builder
+	number := n.Start
+	if number == 0 {
+		number = 1
+	}
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if n.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+			if !n.IsTight {
+				sb.WriteString("\n")
+			}
+		}
+		sb.WriteString(marker)
+		sb.WriteString(indent(r.blocks(item), len(marker)))
+	}
+	return sb.String()
+}
+
+// indent indents every line of the text after the first, other than blank
+// lines.
+func indent(text string, width int) string {
+	prefix := strings.Repeat(" ", width)
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *render) table(n *extAST.Table) string {
+	var rows [][]string
+	var widths []int
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			text := strings.ReplaceAll(r.inline(cell), "|", "\\vert{}")
+			if len(widths) <= len(cells) {
+				widths = append(widths, 0)
+			}
+			if w := runewidth.StringWidth(text); w > widths[len(cells)] {
+				widths[len(cells)] = w
+			}
+			cells = append(cells, text)
+		}
+		rows = append(rows, cells)
+	}
+	var sb strings.Builder
+	for i, cells := range rows {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("|")
+		for c, width := range widths {
+			text := ""
+			if c < len(cells) {
+				text = cells[c]
+			}
+			sb.WriteString(" " + runewidth.FillRight(text, width) + " |")
+		}
+		if _, ok := n.FirstChild().(*extAST.TableHeader); ok && i == 0 {
+			sb.WriteString("\n|")
+			for c, width := range widths {
+				if c > 0 {
+					sb.WriteString("+")
+				}
+				sb.WriteString(strings.Repeat("-", width+2))
+			}
+			sb.WriteString("|")
+		}
+	}
+	return sb.String()
+}
+
+// headingTags converts the #tags at the end of a heading into Org tags.
+func headingTags(title string) string {
+	fields := strings.Split(title, " ")
+	i := len(fields)
+	for i > 1 && isTag(fields[i-1]) {
+		i--
+	}
+	if i == len(fields) {
+		return title
+	}
+	var tags []string
+	for _, f := range fields[i:] {
+		tags = append(tags, f[1:])
+	}
+	return strings.Join(fields[:i], " ") + " :" + strings.Join(tags, ":") + ":"
+}
+
+func isTag(word string) bool {
+	if len(word) < 2 || word[0] != '#' {
+		return false
+	}
+	letter := false
+	for _, c := range word[1:] {
+		switch {
+		case unicode.IsLetter(c):
+			letter = true
+		case unicode.IsDigit(c), c == '_', c == '@', c == '-':
+		default:
+			return false
+		}
+	}
+	return letter
+}
+
+// inline renders the children of the node, which must all be inlines.
+func (r *render) inline(parent ast.Node) string {
+	var sb strings.Builder
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		switch n := c.(type) {
+		case *ast.Text:
+			sb.Write(n.Segment.Value(r.source))
+			if n.HardLineBreak() {
+				sb.WriteString("\\\\\n")
+			} else if n.SoftLineBreak() {
+				sb.WriteString("\n")
+			}
+		case *ast.String:
+			sb.Write(n.Value)
+		case *ast.CodeSpan:
+			code := r.rawText(n)
+			if strings.Contains(code, "~") {
+				sb.WriteString("=" + code + "=")
+			} else {
+				sb.WriteString("~" + code + "~")
+			}
+		case *ast.Emphasis:
+			marker := "/"
+			if n.Level == 2 {
+				marker = "*"
+			}
+			sb.WriteString(marker + r.inline(n) + marker)
+		case *extAST.Strikethrough:
+			sb.WriteString("+" + r.inline(n) + "+")
+		case *ast.Link:
+			target := linkTarget(n.Destination)
+			label := r.inline(n)
+			if label == "" || label == target || label == string(n.Destination) {
+				sb.WriteString("[[" + target + "]]")
+			} else {
+				sb.WriteString("[[" + target + "][" + label + "]]")
+			}
+		case *ast.Image:
+			sb.WriteString("[[" + linkTarget(n.Destination) + "]]")
+		case *ast.AutoLink:
+			sb.Write(n.URL(r.source))
+		case *ast.RawHTML:
+			var html strings.Builder
+			for i := 0; i < n.Segments.Len(); i++ {
+				segment := n.Segments.At(i)
+				html.Write(segment.Value(r.source))
+			}
+			sb.WriteString("@@html:" + html.String() + "@@")
+		case *mathjax.InlineMath:
+			sb.WriteString("\\(" + r.rawText(n) + "\\)")
+		case *extAST.TaskCheckBox:
+			if n.IsChecked {
+				sb.WriteString("[X] ")
+			} else {
+				sb.WriteString("[ ] ")
+			}
+		default:
+			r.unsupported("detected unexpected tree type %s", c.Kind().String())
+		}
+	}
+	return sb.String()
+}
+
+// rawText returns the text of the children of the node without any
+// formatting.
+func (r *render) rawText(parent ast.Node) string {
+	var sb strings.Builder
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			sb.Write(t.Segment.Value(r.source))
+		} else if s, ok := c.(*ast.String); ok {
+			sb.Write(s.Value)
+		}
+	}
+	return sb.String()
+}
+
+// linkTarget converts the destination of a Markdown link into the target of
+// an Org link. Relative paths become file: links.
+func linkTarget(dest []byte) string {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return string(dest)
+	}
+	if u.Path == "" {
+		if u.Fragment != "" {
+			return "#" + u.Fragment
+		}
+		return string(dest)
+	}
+	target := "file:" + u.Path
+	if u.Fragment != "" {
+		target += "::#" + u.Fragment
+	}
+	return target
+}
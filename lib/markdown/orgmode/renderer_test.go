@@ -0,0 +1,49 @@
+package orgmode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	input := "# Plan #work\n\nSome **bold**, *italic*, `code` and [a link](other.md#Intro).\n\n- [ ] Task\n  1. Nested\n\n| A | B |\n|---|---|\n| 1 | 2 |\n\n```go\n* not a heading\n```\n\n> Quoted\n\n<div>raw</div>\n"
+	doc, err := parser.Parse([]byte(input))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, NewRenderer().Render(&buf, []byte(input), doc))
+	require.Equal(t, `* Plan :work:
+
+Some *bold*, /italic/, ~code~ and [[file:other.md::#Intro][a link]].
+
+- [ ] Task
+  1. Nested
+
+| A | B |
+|---+---|
+| 1 | 2 |
+
+#+BEGIN_SRC go
+,* not a heading
+#+END_SRC
+
+#+BEGIN_QUOTE
+Quoted
+#+END_QUOTE
+
+#+BEGIN_EXPORT html
+<div>raw</div>
+#+END_EXPORT
+`, buf.String())
+}
+
+func TestRenderProperties(t *testing.T) {
+	input := "* Plan\n:PROPERTIES:\n:CUSTOM_ID: plan\n:CREATED: [2021-06-01]\n:END:\nText\n"
+	doc, err := Parse([]byte(input))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, NewRenderer().Render(&buf, []byte(input), doc))
+	require.Equal(t, "* Plan\n:PROPERTIES:\n:CUSTOM_ID: plan\n:CREATED: [2021-06-01]\n:END:\n\nText\n", buf.String())
+}
@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"strings"
+)
+
+// inlineEscaper escapes the characters which start inline markup anywhere in a
+// line, including math and table cells.
+var inlineEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"`", "\\`",
+	"*", "\\*",
+	"_", "\\_",
+	"[", "\\[",
+	"]", "\\]",
+	"<", "\\<",
+	"&", "\\&",
+	"$", "\\$",
+	"|", "\\|",
+)
+
+// EscapeText converts plain text into Markdown which is parsed back into the
+// same text. Lines are kept, so the text becomes paragraphs separated where
+// the text has blank lines. Leading indentation is removed, since it would
+// otherwise start a code block.
+func EscapeText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = EscapeLineStart(EscapeInline(strings.TrimLeft(line, " \t")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// EscapeInline escapes the characters which would start inline markup, such
+// as emphasis, links, code spans, and HTML, so that the text is parsed as
+// plain text. Line starts are not escaped; see EscapeLineStart.
+func EscapeInline(text string) string {
+	return inlineEscaper.Replace(text)
+}
+
+// EscapeLineStart escapes the characters at the start of the line which
+// would make it a heading, quote, list item, thematic break, or code fence.
+// The rest of the line is not changed.
+func EscapeLineStart(line string) string {
+	if line == "" {
+		return line
+	}
+	switch line[0] {
+	case '#', '>', '-', '+', '=', '~':
+		return "\\" + line
+	}
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	if digits > 0 && digits < len(line) && (line[digits] == '.' || line[digits] == ')') {
+		return line[:digits] + "\\" + line[digits:]
+	}
+	return line
+}
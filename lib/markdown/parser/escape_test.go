@@ -0,0 +1,25 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yuin/goldmark/ast"
+)
+
+func TestEscapeText(t *testing.T) {
+	text := "# Not a heading\n- not a list\n1. not ordered\n  indented *not em* [not](link)\n<b>&amp; $x$ | `code`\n\n---"
+	escaped := EscapeText(text)
+	require.Equal(t, "\\# Not a heading\n\\- not a list\n1\\. not ordered\nindented \\*not em\\* \\[not\\](link)\n\\<b>\\&amp; \\$x\\$ \\| \\`code\\`\n\n\\---", escaped)
+
+	doc, err := Parse([]byte(escaped))
+	require.NoError(t, err)
+	// The escaped text is only paragraphs of plain text.
+	err = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && n != doc {
+			require.Contains(t, []ast.NodeKind{ast.KindParagraph, ast.KindText}, n.Kind())
+		}
+		return ast.WalkContinue, nil
+	})
+	require.NoError(t, err)
+}
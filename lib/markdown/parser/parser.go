@@ -1,20 +1,137 @@
 package parser
 
 import (
+	"bytes"
+
 	mathjax "github.com/litao91/goldmark-mathjax"
 	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
-func Parse(input []byte) (ast.Node, error) {
+// frontMatterAttribute is the attribute of the document which holds its
+// front matter.
+var frontMatterAttribute = []byte("front-matter")
+
+// Parse parses the Markdown input into an AST. Additional extensions, such as
+// WikiLinks, can be provided for formats which use a different Markdown
+// dialect.
+//
+// YAML front matter at the start of the input is not part of the AST. The
+// values in it are available from the Meta method of the document, and the
+// front matter itself from FrontMatter, so that it can be rendered unchanged.
+// If the YAML is invalid, the front matter is still kept, but the document
+// has no Meta.
+func Parse(input []byte, extensions ...goldmark.Extender) (ast.Node, error) {
+	front, _ := SplitFrontMatter(input)
+	builtin := []goldmark.Extender{mathjax.MathJax, extension.Table}
+	if front != nil {
+		builtin = append(builtin, meta.Meta)
+	}
 	markdown := goldmark.New(
-		goldmark.WithExtensions(mathjax.MathJax, extension.Table),
+		goldmark.WithExtensions(builtin...),
+		goldmark.WithExtensions(extensions...),
 	)
 	context := parser.NewContext()
 	reader := text.NewReader(input)
 	doc := markdown.Parser().Parse(reader, parser.WithContext(context))
+	if front != nil {
+		values, err := meta.TryGet(context)
+		if err != nil {
+			// goldmark-meta leaves invalid front matter in the document as
+			// a text block.
+			doc.RemoveChild(doc, doc.FirstChild())
+		} else if document, ok := doc.(*ast.Document); ok {
+			document.SetMeta(values)
+		}
+		doc.SetAttribute(frontMatterAttribute, input[:len(front)+blankLines(input[len(front):])])
+	}
 	return doc, nil
 }
+
+// HeadingIDs is an extension which gives each heading an id derived from its
+// text, so that links to a heading of a note resolve when it is rendered as
+// HTML.
+var HeadingIDs goldmark.Extender = headingIDs{}
+
+type headingIDs struct{}
+
+func (headingIDs) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithAutoHeadingID())
+}
+
+// FrontMatter returns the front matter of a document returned by Parse,
+// including the lines which delimit it and the blank lines which separate it
+// from the rest of the document, or nil if it has none.
+func FrontMatter(doc ast.Node) []byte {
+	if value, ok := doc.Attribute(frontMatterAttribute); ok {
+		return value.([]byte)
+	}
+	return nil
+}
+
+// SplitFrontMatter finds the YAML front matter at the start of the input. It
+// returns the front matter including the lines which delimit it, and the YAML
+// between those lines. Both are nil if the input does not start with front
+// matter. The front matter starts with a line of at least three dashes and
+// ends with the next line made up only of dashes, as in goldmark-meta.
+func SplitFrontMatter(input []byte) (front, yaml []byte) {
+	line, rest := splitLine(input)
+	if !isSeparator(line) || len(util.TrimRightSpace(util.TrimLeftSpace(line))) < 3 || len(rest) == 0 {
+		return nil, nil
+	}
+	start := len(input) - len(rest)
+	for len(rest) > 0 {
+		offset := len(input) - len(rest)
+		line, rest = splitLine(rest)
+		if isSeparator(line) && !util.IsBlank(line) {
+			return input[:len(input)-len(rest)], input[start:offset]
+		}
+	}
+	return nil, nil
+}
+
+// StripFrontMatter removes the YAML front matter, and the blank lines after
+// it, from the start of the input. Formats which store the metadata of a note
+// separately use it to avoid keeping a second copy in the Markdown.
+func StripFrontMatter(input []byte) []byte {
+	front, _ := SplitFrontMatter(input)
+	if front == nil {
+		return input
+	}
+	rest := input[len(front):]
+	return rest[blankLines(rest):]
+}
+
+// isSeparator reports whether the line is made up only of dashes, ignoring
+// surrounding whitespace.
+func isSeparator(line []byte) bool {
+	return len(bytes.Trim(util.TrimRightSpace(util.TrimLeftSpace(line)), "-")) == 0
+}
+
+// blankLines returns the length of the blank lines at the start of the input.
+func blankLines(input []byte) int {
+	length := 0
+	for rest := input; len(rest) > 0; {
+		var line []byte
+		line, rest = splitLine(rest)
+		if !util.IsBlank(line) {
+			break
+		}
+		length += len(line)
+	}
+	return length
+}
+
+// splitLine returns the first line of the input, including its line ending,
+// and the rest of the input.
+func splitLine(input []byte) (line, rest []byte) {
+	if idx := bytes.IndexByte(input, '\n'); idx != -1 {
+		return input[:idx+1], input[idx+1:]
+	}
+	return input, nil
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yuin/goldmark/ast"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	cases := []struct {
+		input string
+		front string
+		yaml  string
+	}{
+		{"---\ntitle: A\n---\n# Hi\n", "---\ntitle: A\n---\n", "title: A\n"},
+		{"----  \ntitle: A\n  --- \n\nText", "----  \ntitle: A\n  --- \n", "title: A\n"},
+		{"---\n\ntitle: A\n-\n", "---\n\ntitle: A\n-\n", "\ntitle: A\n"},
+		{"---\n---", "---\n---", ""},
+		{"--\ntitle: A\n---\n", "", ""},
+		{"---\ntitle: A\n", "", ""},
+		{"---", "", ""},
+		{"# ---\n---\n", "", ""},
+	}
+	for _, c := range cases {
+		front, yaml := SplitFrontMatter([]byte(c.input))
+		require.Equal(t, c.front, string(front), c.input)
+		require.Equal(t, c.yaml, string(yaml), c.input)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	doc, err := Parse([]byte("---\ntitle: A\n---\n# Hi\n"))
+	require.NoError(t, err)
+	require.Equal(t, "---\ntitle: A\n---\n", string(FrontMatter(doc)))
+	require.Equal(t, map[string]interface{}{"title": "A"}, doc.(*ast.Document).Meta())
+	require.Equal(t, ast.KindHeading, doc.FirstChild().Kind())
+
+	// The blank lines after the front matter are kept with it.
+	doc, err = Parse([]byte("---\ntitle: A\n---\n\n\nText\n"))
+	require.NoError(t, err)
+	require.Equal(t, "---\ntitle: A\n---\n\n\n", string(FrontMatter(doc)))
+
+	// Invalid YAML is kept, but is not part of the document.
+	doc, err = Parse([]byte("---\ntitle: [\n---\nText\n"))
+	require.NoError(t, err)
+	require.Equal(t, "---\ntitle: [\n---\n", string(FrontMatter(doc)))
+	require.Empty(t, doc.(*ast.Document).Meta())
+	require.Equal(t, ast.KindParagraph, doc.FirstChild().Kind())
+	require.Nil(t, doc.FirstChild().NextSibling())
+
+	doc, err = Parse([]byte("- item\n"))
+	require.NoError(t, err)
+	require.Nil(t, FrontMatter(doc))
+}
+
+func TestStripFrontMatter(t *testing.T) {
+	require.Equal(t, "# Hi\n", string(StripFrontMatter([]byte("---\ntitle: A\n---\n\n# Hi\n"))))
+	require.Equal(t, "", string(StripFrontMatter([]byte("---\ntitle: A\n---\n"))))
+	require.Equal(t, "# Hi\n", string(StripFrontMatter([]byte("# Hi\n"))))
+}
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// WikiLinkResolver converts the target of a wikilink, such as "Note#Heading",
+// into the destination of a Markdown link.
+type WikiLinkResolver func(target string) []byte
+
+// WikiLinks returns an extension which parses `[[Target|Label]]` and
+// `![[Target]]` into links and images, using the resolver to determine the
+// destination. Embeds of anything other than images become ordinary links.
+func WikiLinks(resolve WikiLinkResolver) goldmark.Extender {
+	return &wikiLinks{resolve}
+}
+
+type wikiLinks struct {
+	resolve WikiLinkResolver
+}
+
+func (e *wikiLinks) Extend(m goldmark.Markdown) {
+	// Run before the standard link parser, which also triggers on '['.
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&wikiLinkParser{e.resolve}, 199),
+	))
+}
+
+type wikiLinkParser struct {
+	resolve WikiLinkResolver
+}
+
+var (
+	wikiLinkOpen  = []byte("[[")
+	wikiEmbedOpen = []byte("![[")
+	wikiLinkClose = []byte("]]")
+)
+
+func (p *wikiLinkParser) Trigger() []byte {
+	return []byte{'!', '['}
+}
+
+func (p *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	var start int
+	embed := false
+	if bytes.HasPrefix(line, wikiEmbedOpen) {
+		start = len(wikiEmbedOpen)
+		embed = true
+	} else if bytes.HasPrefix(line, wikiLinkOpen) {
+		start = len(wikiLinkOpen)
+	} else {
+		return nil
+	}
+	length := bytes.Index(line[start:], wikiLinkClose)
+	if length < 1 {
+		return nil
+	}
+	content := line[start : start+length]
+	if bytes.ContainsAny(content, "[]") {
+		return nil
+	}
+
+	target := content
+	targetSegment := text.NewSegment(segment.Start+start, segment.Start+start+length)
+	label := targetSegment
+	if sep := bytes.IndexByte(content, '|'); sep != -1 {
+		target = content[:sep]
+		targetSegment = text.NewSegment(segment.Start+start, segment.Start+start+sep)
+		label = text.NewSegment(segment.Start+start+sep+1, segment.Start+start+length)
+	}
+	block.Advance(start + length + len(wikiLinkClose))
+
+	link := ast.NewLink()
+	link.Destination = p.resolve(string(target))
+	if embed && IsImagePath(string(target)) {
+		// In an embed, the text after the separator is the image size, so
+		// the target is used as the alt text instead.
+		link.AppendChild(link, ast.NewTextSegment(targetSegment))
+		return ast.NewImage(link)
+	}
+	link.AppendChild(link, ast.NewTextSegment(label))
+	return link
+}
+
+// IsImagePath reports whether the path refers to a file which is commonly
+// displayed inline as an image.
+func IsImagePath(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".svg", ".webp":
+		return true
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"unsafe"
 
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
 	mathjax "github.com/litao91/goldmark-mathjax"
 	"github.com/yuin/goldmark/ast"
 	extAST "github.com/yuin/goldmark/extension/ast"
@@ -108,11 +109,22 @@ func (r *render) renderNode(node ast.Node, entering bool) (ast.WalkStatus, error
 
 	switch tnode := node.(type) {
 	case *ast.Document:
+		front := parser.FrontMatter(node)
 		if entering {
+			if front != nil {
+				// Front matter is written exactly as it was read, along with
+				// the blank lines which followed it.
+				_, _ = r.w.Write(front)
+				if !bytes.HasSuffix(front, newLineChar) {
+					_, _ = r.w.Write(newLineChar)
+				}
+			}
 			break
 		}
 
-		_, _ = r.w.Write(newLineChar)
+		if front == nil || node.HasChildren() {
+			_, _ = r.w.Write(newLineChar)
+		}
 
 	// Spans, meaning no newlines before or after.
 	case *ast.Text:
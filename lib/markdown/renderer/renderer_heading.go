@@ -3,13 +3,19 @@ package renderer
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/yuin/goldmark/ast"
 )
 
+// unquotedAttribute matches the attribute values which can be written
+// without quotes.
+var unquotedAttribute = regexp.MustCompile(`^[\w.-]+$`)
+
 func (r *render) renderHeading(node *ast.Heading) error {
 	underlineHeading := false
 	if r.mr.underlineHeadings {
@@ -63,7 +69,11 @@ func (r *render) renderHeading(node *ast.Heading) error {
 				hAttr = append(hAttr, string(attr.Name))
 				continue
 			}
-			hAttr = append(hAttr, fmt.Sprintf("%s=%s", string(attr.Name), attr.Value))
+			value := fmt.Sprintf("%s", attr.Value)
+			if !unquotedAttribute.MatchString(value) {
+				value = strconv.Quote(value)
+			}
+			hAttr = append(hAttr, fmt.Sprintf("%s=%s", string(attr.Name), value))
 		}
 	}
 	if len(hAttr) != 0 {
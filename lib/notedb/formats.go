@@ -2,7 +2,6 @@ package notedb
 
 import (
 	"net/url"
-	"os"
 	"path/filepath"
 )
 
@@ -30,11 +29,18 @@ type FormatDescription struct {
 	// Open should load and return the database at the provided URL.
 	Open func(dbURL *url.URL) (Database, error)
 	// Detect should examine the URL and determine if it is likely to be a
-	// database handled by this format. This process should only examine the
-	// URL and not the content pointed to by the URL. The format which is "most
-	// confident" about the detection is the one which will be selected. The
-	// default implementation returns DetectResultNegative.
+	// database handled by this format. Every format is asked about each URL,
+	// so detection must be cheap: it may check whether files exist, list a
+	// directory, or read the first few kilobytes of a file (see SniffJSON),
+	// but it must not read or parse the whole database. The format which is
+	// "most confident" about the detection is the one which will be selected.
+	// The default implementation returns DetectResultNegative.
 	Detect func(dbURL *url.URL) DetectResult
+	// Wrapper indicates that the format holds another database, whose format
+	// is given after the `+` in the URL scheme, like "zip+file". Wrappers are
+	// only selected by ResolveURL when no other format is confident about the
+	// URL, and the contents are assumed to be in the file format.
+	Wrapper bool
 }
 
 var registeredFormats map[string]*FormatDescription
@@ -55,27 +61,19 @@ func ResolveURL(path string) (*url.URL, error) {
 		return nil, err
 	}
 	if dbURL.Scheme == "" {
-		_, err := os.Stat(path)
-		if err != nil {
-			return nil, err
-		}
-
+		// The path is not required to exist, since it may be the destination
+		// of a conversion.
 		path, err = filepath.Abs(path)
 		if err != nil {
 			return nil, err
 		}
 		dbURL = &url.URL{Path: path}
 
-		var bestFormat *FormatDescription
-		bestConfidence := DetectResultNegative
-		for _, fmt := range registeredFormats {
-			if fmt.Detect == nil {
-				continue
-			}
-			confidence := fmt.Detect(dbURL)
-			if confidence > bestConfidence {
-				bestFormat = fmt
-				bestConfidence = confidence
+		bestFormat, bestConfidence := detectFormat(dbURL, false)
+		if bestConfidence != DetectResultPositive {
+			if wrapper, confidence := detectFormat(dbURL, true); confidence == DetectResultPositive {
+				dbURL.Scheme = wrapper.ID + "+file"
+				return dbURL, nil
 			}
 		}
 
@@ -88,3 +86,21 @@ func ResolveURL(path string) (*url.URL, error) {
 
 	return dbURL, nil
 }
+
+// detectFormat returns the format which is most confident about the URL,
+// considering either only the wrapper formats or only the others.
+func detectFormat(dbURL *url.URL, wrapper bool) (*FormatDescription, DetectResult) {
+	var bestFormat *FormatDescription
+	bestConfidence := DetectResultNegative
+	for _, fmt := range registeredFormats {
+		if fmt.Detect == nil || fmt.Wrapper != wrapper {
+			continue
+		}
+		confidence := fmt.Detect(dbURL)
+		if confidence > bestConfidence {
+			bestFormat = fmt
+			bestConfidence = confidence
+		}
+	}
+	return bestFormat, bestConfidence
+}
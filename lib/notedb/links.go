@@ -0,0 +1,90 @@
+package notedb
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/parser"
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/yuin/goldmark/ast"
+	"go.uber.org/multierr"
+)
+
+// LocalLink resolves the destination of a link in the note at notePath. If the
+// link points to another file in the database, the path of that file and the
+// fragment of the link are returned. Links with a scheme or host, absolute
+// paths, and links to a fragment of the same note are not local.
+func LocalLink(notePath string, dest []byte) (target, fragment string, ok bool) {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", "", false
+	}
+	return path.Join(path.Dir(notePath), u.Path), u.Fragment, true
+}
+
+// RelativePath returns the path of target relative to the directory dir. Both
+// paths must be relative to the same root.
+func RelativePath(dir, target string) string {
+	relative, err := filepath.Rel(dir, target)
+	if err != nil {
+		// Should never happen, since both paths are relative to the same
+		// root.
+		panic(err)
+	}
+	return filepath.ToSlash(relative)
+}
+
+// RelativeLink returns the destination of a link from the note at notePath to
+// the file at target.
+func RelativeLink(notePath, target, fragment string) []byte {
+	dest := &url.URL{Path: RelativePath(path.Dir(notePath), target), Fragment: fragment}
+	return []byte(dest.String())
+}
+
+// MapLinks replaces the destination of every link and image in the document
+// with the result of fn. Errors returned by fn are combined, and do not stop
+// the remaining links from being replaced.
+func MapLinks(doc ast.Node, fn func(dest []byte) ([]byte, error)) error {
+	var err error
+	walkErr := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var linkErr error
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination, linkErr = fn(n.Destination)
+		case *ast.Image:
+			n.Destination, linkErr = fn(n.Destination)
+		}
+		err = multierr.Append(err, linkErr)
+		return ast.WalkContinue, nil
+	})
+	return multierr.Append(err, walkErr)
+}
+
+// RewriteLinks replaces the destinations of the links in the note using fn, as
+// MapLinks does. The data is returned unchanged if no destination changed.
+func RewriteLinks(data []byte, fn func(dest []byte) ([]byte, error)) ([]byte, error) {
+	doc, err := parser.Parse(data)
+	if err != nil {
+		return data, err
+	}
+	changed := false
+	err = MapLinks(doc, func(dest []byte) ([]byte, error) {
+		newDest, linkErr := fn(dest)
+		changed = changed || !bytes.Equal(newDest, dest)
+		return newDest, linkErr
+	})
+	if !changed {
+		return data, err
+	}
+	var buf bytes.Buffer
+	if renderErr := renderer.NewRenderer().Render(&buf, data, doc); renderErr != nil {
+		return data, multierr.Append(err, renderErr)
+	}
+	return buf.Bytes(), err
+}
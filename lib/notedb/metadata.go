@@ -0,0 +1,57 @@
+package notedb
+
+import (
+	"time"
+)
+
+// Metadata holds the information about a note which is not part of its
+// content. Formats fill in the fields that they know about and leave the rest
+// empty.
+type Metadata struct {
+	// Title is the title of the note, which may contain characters that cannot
+	// be used in a file name.
+	Title string
+	Tags  []string
+	// Created and Updated are the times the note was created and last
+	// edited by its author. Updated may differ from the modification time of
+	// the file, which can change when a note is synchronized or converted.
+	Created   time.Time
+	Updated   time.Time
+	Author    string
+	SourceURL string
+	Location  *Location
+	// Extra holds any other properties, which are specific to the format
+	// that produced them.
+	Extra map[string]interface{}
+}
+
+// Location is a position on the Earth, in degrees and meters.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+// IsZero reports whether no metadata is set.
+func (m *Metadata) IsZero() bool {
+	return m.Title == "" && len(m.Tags) == 0 && m.Created.IsZero() &&
+		m.Updated.IsZero() && m.Author == "" && m.SourceURL == "" &&
+		m.Location == nil && len(m.Extra) == 0
+}
+
+// WriteMetadataNote extends Note with a method to store the metadata of the
+// note while it is being written.
+type WriteMetadataNote interface {
+	Note
+	WriteMetadata(Metadata) error
+}
+
+// WriteMetadata stores the metadata of a note which is being written. Formats
+// which cannot store metadata ignore it, and formats which can only store some
+// of the fields ignore the others.
+func WriteMetadata(n Note, m Metadata) error {
+	if wn, ok := n.(WriteMetadataNote); ok {
+		return wn.WriteMetadata(m)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package notedb
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var escapeNameReplacer = strings.NewReplacer(
+	"<", "(",
+	">", ")",
+	":", "",
+	"\"", "",
+	"/", "",
+	"\\", "",
+	"|", "",
+	"?", "",
+	"*", "",
+	"\n", " ",
+	"\t", " ",
+)
+
+// EscapeName converts a title into a name which is safe to use as a file name
+// on all common platforms.
+func EscapeName(in string) string {
+	return strings.Join(strings.Fields(escapeNameReplacer.Replace(in)), " ")
+}
+
+// UniqueName returns a variant of the name which does not appear in the list
+// of used names, and adds it to the list. Names are compared without regard to
+// case, since many file systems are case-insensitive. The first duplicate of
+// "Note.md" is "Note 2.md".
+func UniqueName(name string, used map[string]bool) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s %d%s", stem, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -2,6 +2,7 @@ package notedb
 
 import (
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 
@@ -32,6 +33,16 @@ func OpenDatabase(dbURL *url.URL) (Database, error) {
 	return format.Open(dbURL)
 }
 
+// CloseDatabase releases the resources held by the database. Formats which
+// buffer writes in memory, such as archive files, produce their output at this
+// point, so it must be called after writing to a database.
+func CloseDatabase(db Database) error {
+	if closer, ok := db.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // Note extends fs.File with additional methods specific to note databases.
 type Note interface {
 	fs.File
@@ -43,6 +54,10 @@ type Note interface {
 	// Return the raw data of the note as a slice. This provides copy-free
 	// access to the underlying data read from the database.
 	Data() []byte
+	// Return the metadata of the note, such as its tags and creation time.
+	// Like ParseAST, the metadata is always returned if possible, and any
+	// errors returned in this case are non-fatal.
+	Metadata() (Metadata, error)
 }
 
 // WriteASTNote extends Note with additional methods to rewrite Markdown during
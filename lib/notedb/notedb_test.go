@@ -1,8 +1,10 @@
 package notedb
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -16,6 +18,12 @@ func init() {
 		Open:   testOpen,
 		Detect: testDetect,
 	})
+	RegisterFormat(FormatDescription{
+		ID:      "testwrap",
+		Open:    testOpen,
+		Detect:  testWrapDetect,
+		Wrapper: true,
+	})
 }
 
 func testOpen(dbURL *url.URL) (Database, error) {
@@ -29,6 +37,13 @@ func testDetect(dbURL *url.URL) DetectResult {
 	return DetectResultNegative
 }
 
+func testWrapDetect(dbURL *url.URL) DetectResult {
+	if strings.HasSuffix(dbURL.Path, ".testwrap") || strings.HasSuffix(dbURL.Path, ".test") {
+		return DetectResultPositive
+	}
+	return DetectResultNegative
+}
+
 func TestOpenDatabase(t *testing.T) {
 	t.Run("basic", func(t *testing.T) {
 		dbURL, err := url.Parse("test:///")
@@ -73,6 +88,14 @@ func TestResolveURL(t *testing.T) {
 			Path:   "/a.test",
 		})
 	})
+	t.Run("wrapped file path", func(t *testing.T) {
+		ret, err := ResolveURL("/a.testwrap")
+		require.NoError(t, err)
+		require.Equal(t, ret, &url.URL{
+			Scheme: "testwrap+file",
+			Path:   "/a.testwrap",
+		})
+	})
 	t.Run("URL", func(t *testing.T) {
 		ret, err := ResolveURL("test:///a/b")
 		require.NoError(t, err)
@@ -82,3 +105,24 @@ func TestResolveURL(t *testing.T) {
 		})
 	})
 }
+
+func TestSniffJSON(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.json")
+	data := `{"version": 1, "items": [{"uuid": "a", "content": {"text": "x"}, "content_type": "Note"}], "text": "` + strings.Repeat("x", 5000) + `"}`
+	require.NoError(t, os.WriteFile(p, []byte(data), 0666))
+	first, keys := SniffJSON(p)
+	require.Equal(t, json.Delim('{'), first)
+	require.Equal(t, map[string]bool{
+		"version":            true,
+		"items":              true,
+		"items.uuid":         true,
+		"items.content":      true,
+		"items.content.text": true,
+		"items.content_type": true,
+		"text":               true,
+	}, keys)
+
+	require.NoError(t, os.WriteFile(p, []byte("# Not JSON\n"), 0666))
+	first, _ = SniffJSON(p)
+	require.Equal(t, json.Delim(0), first)
+}
@@ -0,0 +1,56 @@
+// Package notedbtest provides helpers for testing note database formats.
+package notedbtest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/pilikino/lib/markdown/renderer"
+	"github.com/CGamesPlay/pilikino/lib/notedb"
+	fs "github.com/relab/wrfs"
+	"github.com/stretchr/testify/require"
+)
+
+// WriteFile creates a file in the database with the given contents.
+func WriteFile(t testing.TB, db notedb.Database, path string, data string) {
+	f, err := fs.Create(db, path)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// WriteFileAt creates a file in the database with the given contents and
+// modification time.
+func WriteFileAt(t testing.TB, db notedb.Database, path string, data string, modTime time.Time) {
+	WriteFile(t, db, path, data)
+	require.NoError(t, fs.Chtimes(db, path, time.Now(), modTime))
+}
+
+// RenderNote parses the note at the given path and renders it back into
+// Markdown.
+func RenderNote(t testing.TB, db notedb.Database, path string) string {
+	f, err := db.Open(path)
+	require.NoError(t, err)
+	note := f.(notedb.Note)
+	require.True(t, note.IsNote())
+	doc, err := note.ParseAST()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, renderer.NewRenderer().Render(&buf, note.Data(), doc))
+	return buf.String()
+}
+
+// ListPaths returns the paths of all of the files and directories in the
+// database, in the order visited by fs.WalkDir.
+func ListPaths(t testing.TB, db notedb.Database) []string {
+	var paths []string
+	err := fs.WalkDir(db, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+	return paths
+}
@@ -0,0 +1,76 @@
+package notedb
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// sniffSize is the number of bytes at the start of a file which SniffJSON
+// examines.
+const sniffSize = 4096
+
+// SniffJSON examines the start of a JSON file, so that formats can detect
+// their files without reading all of them. It returns the delimiter which
+// opens the document, '[' or '{', and the object keys which appear in the
+// examined prefix. Keys of nested objects are joined to the key holding them
+// with a dot, and arrays are transparent, so the key "b" in {"a": [{"b": 1}]}
+// is reported as "a.b". If the file is not JSON, the delimiter is 0.
+func SniffJSON(path string) (json.Delim, map[string]bool) {
+	keys := map[string]bool{}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, keys
+	}
+	defer f.Close()
+
+	type frame struct {
+		object    bool
+		prefix    string
+		key       string
+		expectKey bool
+	}
+	var first json.Delim
+	var stack []*frame
+	decoder := json.NewDecoder(io.LimitReader(f, sniffSize))
+	for {
+		// The prefix usually ends in the middle of the document, so any error
+		// ends the examination.
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if key, ok := tok.(string); ok && top != nil && top.object && top.expectKey {
+			top.key = key
+			top.expectKey = false
+			keys[top.prefix+key] = true
+			continue
+		}
+		delim, ok := tok.(json.Delim)
+		switch {
+		case !ok:
+			if top == nil {
+				return 0, keys
+			}
+			top.expectKey = top.object
+		case delim == '{' || delim == '[':
+			prefix := ""
+			if top == nil {
+				first = delim
+			} else if prefix = top.prefix; top.object {
+				prefix += top.key + "."
+			}
+			stack = append(stack, &frame{object: delim == '{', prefix: prefix, expectKey: true})
+		default:
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].expectKey = stack[len(stack)-1].object
+			}
+		}
+	}
+	return first, keys
+}